@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// A user permission on a repo that is also given by groups the user is a member of.
+type RedundantPermission struct {
+	Repo   string
+	User   string
+	Action string
+	Groups []string
+}
+
+// A user or group whose permissions on a repo are exactly those of a role.
+type RoleMatch struct {
+	Repo      string
+	Principal string
+	Role      string
+}
+
+type PermissionAnalysis struct {
+	Redundant   []RedundantPermission
+	RoleMatches []RoleMatch
+}
+
+type repoActionList struct {
+	action     string
+	principals *[]string
+}
+
+func getRepoActionLists(repo *Repo) []repoActionList {
+	return []repoActionList{
+		{"READ", &repo.Read},
+		{"ANNOTATE", &repo.Annotate},
+		{"WRITE", &repo.Write},
+		{"DELETE", &repo.Delete},
+		{"MANAGE", &repo.Manage},
+		{"SCAN", &repo.Scan},
+	}
+}
+
+// Returns the actions of each user and group on a repo, in the order of permissionActions.
+func getPrincipalActions(repo Repo) map[string][]string {
+	actions := make(map[string][]string)
+	for _, list := range getRepoActionLists(&repo) {
+		for _, principal := range *list.principals {
+			if !slices.Contains(actions[principal], list.action) {
+				actions[principal] = append(actions[principal], list.action)
+			}
+		}
+	}
+	return actions
+}
+
+// Finds user permissions given by group membership, and permission sets matching a role.
+// Names that are keys of groupMembers are groups, all other names are users.
+func AnalyzeRepos(repos []Repo, groupMembers map[string][]string, roles []Role) PermissionAnalysis {
+	var analysis PermissionAnalysis
+
+	for _, repo := range repos {
+		reponame := getRepoLabel(repo)
+		actions := getPrincipalActions(repo)
+
+		for _, principal := range slices.Sorted(maps.Keys(actions)) {
+			if role := getMatchingRole(actions[principal], roles); role != "" {
+				analysis.RoleMatches = append(analysis.RoleMatches, RoleMatch{reponame, principal, role})
+			}
+
+			if _, isGroup := groupMembers[principal]; isGroup {
+				continue
+			}
+			for _, action := range actions[principal] {
+				var groups []string
+				for group, members := range groupMembers {
+					if slices.Contains(members, principal) && slices.Contains(actions[group], action) {
+						groups = append(groups, group)
+					}
+				}
+				if len(groups) > 0 {
+					slices.Sort(groups)
+					analysis.Redundant = append(analysis.Redundant, RedundantPermission{reponame, principal, action, groups})
+				}
+			}
+		}
+	}
+
+	return analysis
+}
+
+func getRepoLabel(repo Repo) string {
+	if repo.Name != "" {
+		return repo.Name
+	}
+	return strings.Join(repo.Names, ", ")
+}
+
+func getMatchingRole(actions []string, roles []Role) string {
+	for _, role := range roles {
+		if equalStringSlices(actions, role.Actions) {
+			return role.Name
+		}
+	}
+	return ""
+}
+
+func (a PermissionAnalysis) Print() {
+	for _, redundant := range a.Redundant {
+		fmt.Printf("'%s': User '%s' has %s, also given by group membership: %s\n", redundant.Repo, redundant.User, redundant.Action, strings.Join(redundant.Groups, ", "))
+	}
+	for _, match := range a.RoleMatches {
+		fmt.Printf("'%s': '%s' has the permissions of role '%s'\n", match.Repo, match.Principal, match.Role)
+	}
+
+	roleCounts := make(map[string]int)
+	for _, match := range a.RoleMatches {
+		roleCounts[match.Role]++
+	}
+	var counts []string
+	for _, role := range slices.Sorted(maps.Keys(roleCounts)) {
+		counts = append(counts, fmt.Sprintf("%s: %d", role, roleCounts[role]))
+	}
+
+	fmt.Printf("Redundant user permissions: %d\n", len(a.Redundant))
+	fmt.Printf("Permission sets matching a role: %d (%s)\n", len(a.RoleMatches), strings.Join(counts, ", "))
+}
+
+// Removes the user permissions that are given by group membership.
+func CompactRepos(repos []Repo, analysis PermissionAnalysis) []Repo {
+	for i := range repos {
+		reponame := getRepoLabel(repos[i])
+		for _, list := range getRepoActionLists(&repos[i]) {
+			*list.principals = slices.DeleteFunc(*list.principals, func(principal string) bool {
+				return slices.ContainsFunc(analysis.Redundant, func(r RedundantPermission) bool {
+					return r.Repo == reponame && r.User == principal && r.Action == list.action
+				})
+			})
+		}
+	}
+	return repos
+}
+
+// Retrieves the members of groups, concurrently.
+func getGroupMembers(client *http.Client, baseurl string, token string, groupnames []string, concurrency int) (map[string][]string, error) {
+	members := make([][]string, len(groupnames))
+	err := runConcurrently(concurrency, len(groupnames), func(i int) error {
+		details, err := getGroupDetails(client, baseurl, token, groupnames[i])
+		if err != nil {
+			return fmt.Errorf("error retrieving group '%s': %w", groupnames[i], err)
+		}
+		members[i] = details.Members
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groupMembers := make(map[string][]string)
+	for i, groupname := range groupnames {
+		groupMembers[groupname] = members[i]
+	}
+	return groupMembers, nil
+}
+
+// Returns the groups among the users and groups of the repos, sorted.
+func getRepoGroupNames(repos []Repo, isGroup func(string) bool) []string {
+	var groupnames []string
+	for _, repo := range repos {
+		for principal := range getPrincipalActions(repo) {
+			if isGroup(principal) {
+				groupnames = append(groupnames, principal)
+			}
+		}
+	}
+	slices.Sort(groupnames)
+	return slices.Compact(groupnames)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeRepos(t *testing.T) {
+	repos := []Repo{
+		{
+			Name:     "repo1",
+			Read:     []string{"alice", "bob", "devs", "readers"},
+			Annotate: []string{"alice", "devs"},
+			Write:    []string{"alice", "devs"},
+			Manage:   []string{"alice"},
+		},
+		{
+			Names: []string{"repo2", "repo3"},
+			Read:  []string{"bob"},
+		},
+	}
+	groupMembers := map[string][]string{
+		"devs":    {"alice"},
+		"readers": {"alice", "bob"},
+	}
+
+	got := AnalyzeRepos(repos, groupMembers, standardRoles)
+
+	want := PermissionAnalysis{
+		Redundant: []RedundantPermission{
+			{"repo1", "alice", "READ", []string{"devs", "readers"}},
+			{"repo1", "alice", "ANNOTATE", []string{"devs"}},
+			{"repo1", "alice", "WRITE", []string{"devs"}},
+			{"repo1", "bob", "READ", []string{"readers"}},
+		},
+		RoleMatches: []RoleMatch{
+			{"repo1", "bob", "reader"},
+			{"repo1", "devs", "deployer"},
+			{"repo1", "readers", "reader"},
+			{"repo2, repo3", "bob", "reader"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AnalyzeRepos: got\n%+v\nwant\n%+v", got, want)
+	}
+
+	compacted := CompactRepos(repos, got)
+	wantRepo1 := Repo{
+		Name:     "repo1",
+		Read:     []string{"devs", "readers"},
+		Annotate: []string{"devs"},
+		Write:    []string{"devs"},
+		Manage:   []string{"alice"},
+	}
+	if !reflect.DeepEqual(compacted[0], wantRepo1) {
+		t.Errorf("CompactRepos: got %+v, want %+v", compacted[0], wantRepo1)
+	}
+	if !reflect.DeepEqual(compacted[1].Read, []string{"bob"}) {
+		t.Errorf("CompactRepos: repo without redundant permissions changed: %+v", compacted[1])
+	}
+}
+
+func TestGenerateCompact(t *testing.T) {
+	repos := []ArtifactoryRepoDetailsResponse{
+		{Key: "repo1", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+	}
+	permissiondetails := []ArtifactoryPermissionDetails{
+		{Name: "repo1", Resources: ArtifactoryPermissionDetailsResources{Artifact: ArtifactoryPermissionDetailsArtifact{
+			Actions: ArtifactoryPermissionDetailsActions{
+				Users:  map[string][]string{"alice": {"READ", "WRITE"}, "bob": {"READ"}},
+				Groups: map[string][]string{"devs": {"READ"}},
+			},
+		}}},
+	}
+	client := mockPrincipalsClient(map[string]string{
+		"/access/api/v2/groups/devs": `{"name":"devs","members":["alice","carol"]}`,
+	}, &[]string{})
+
+	filename := filepath.Join(t.TempDir(), "repos.yaml")
+	err := Generate(client, "", "", repos, permissiondetails, filename, GenerateConfig{Compact: true})
+	if err != nil {
+		t.Fatalf("GenerateCompact: error = %v", err)
+	}
+
+	want := `- name: repo1
+  read:
+  - bob
+  - devs
+  write:
+  - alice
+`
+	data, _ := os.ReadFile(filename)
+	if string(data) != want {
+		t.Errorf("GenerateCompact: output mismatch:\nGot:\n%s\nWant:\n%s", string(data), want)
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const backupCreatedFilename = "created.json"
+
+// Keeps the previous json of every repo and permission target a run changes, and the
+// names of the objects it created, so that the run can be rolled back.
+type Backup struct {
+	Dir string
+
+	mutex   sync.Mutex
+	created BackupCreated
+	changed bool
+}
+
+type BackupCreated struct {
+	Repos       []string `json:"repos"`
+	Permissions []string `json:"permissions"`
+}
+
+// The run directory is created on the first change, so runs without changes leave nothing behind.
+func NewBackup(dir string) *Backup {
+	return &Backup{Dir: dir}
+}
+
+func (b *Backup) HasChanges() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.changed
+}
+
+func (b *Backup) SaveRepo(key string, source string) error {
+	return b.save("repos", key, source)
+}
+
+func (b *Backup) SavePermission(name string, source string) error {
+	return b.save("permissions", name, source)
+}
+
+func (b *Backup) save(kind string, name string, source string) error {
+	if b == nil {
+		return nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	folder := filepath.Join(b.Dir, kind)
+	err := os.MkdirAll(folder, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating backup folder '%s': %w", folder, err)
+	}
+
+	filename := filepath.Join(folder, url.PathEscape(name)+".json")
+	if _, err := os.Stat(filename); err == nil {
+		// The state before the run is what should be restored.
+		return nil
+	}
+
+	err = os.WriteFile(filename, []byte(source), 0600)
+	if err != nil {
+		return fmt.Errorf("error saving backup: %w", err)
+	}
+
+	b.changed = true
+
+	return nil
+}
+
+func (b *Backup) AddCreatedRepo(name string) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.created.Repos = append(b.created.Repos, name)
+	b.saveCreated()
+}
+
+func (b *Backup) AddCreatedPermission(name string) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.created.Permissions = append(b.created.Permissions, name)
+	b.saveCreated()
+}
+
+func (b *Backup) saveCreated() {
+	data, err := json.MarshalIndent(b.created, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(b.Dir, 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(b.Dir, backupCreatedFilename), data, 0600)
+	}
+	if err != nil {
+		fmt.Printf("Warning: Couldn't save created objects to backup: %v\n", err)
+		return
+	}
+
+	b.changed = true
+}
+
+func backupRepo(client *http.Client, baseurl string, token string, diffRepo repoDiff, backup *Backup, dryRun bool) error {
+	if dryRun || backup == nil || diffRepo.existingRepo == nil {
+		return nil
+	}
+
+	key := diffRepo.existingRepo.Key
+	url := fmt.Sprintf("%s/artifactory/api/repositories/%s", baseurl, url.PathEscape(key))
+	source, err := getBackupSource(client, token, url, diffRepo.existingRepo.JsonSource)
+	if err == nil {
+		err = backup.SaveRepo(key, source)
+	}
+	if err != nil {
+		return fmt.Errorf("not updating repo without backup: %w", err)
+	}
+
+	return nil
+}
+
+func backupPermission(client *http.Client, baseurl string, token string, diffRepo repoDiff, backup *Backup, dryRun bool) error {
+	if dryRun || backup == nil || diffRepo.existingPermission == nil {
+		return nil
+	}
+
+	name := diffRepo.existingPermission.Name
+	url := fmt.Sprintf("%s/access/api/v2/permissions/%s", baseurl, url.PathEscape(name))
+	source, err := getBackupSource(client, token, url, diffRepo.existingPermission.JsonSource)
+	if err == nil {
+		err = backup.SavePermission(name, source)
+	}
+	if err != nil {
+		return fmt.Errorf("not updating permission target without backup: %w", err)
+	}
+
+	return nil
+}
+
+// Returns the exact json of an object before it's changed. Details from the cache don't have
+// the json Artifactory returned, so the object is read again.
+func getBackupSource(client *http.Client, token string, url string, jsonSource string) (string, error) {
+	if jsonSource != "" {
+		return jsonSource, nil
+	}
+
+	source, err := rollbackGet(client, token, url)
+	if err != nil {
+		return "", fmt.Errorf("error reading current json: %w", err)
+	}
+	if source == "" {
+		return "", fmt.Errorf("not found in Artifactory")
+	}
+
+	return source, nil
+}
+
+// Restores the repos and permission targets saved in a run directory, and deletes the
+// objects the run created. Permission targets are deleted before repos, in reverse order of creation.
+func Rollback(client *http.Client, baseurl string, token string, runDir string, lock *Lock, showDiff bool, dryRun bool) error {
+	var created BackupCreated
+	data, err := os.ReadFile(filepath.Join(runDir, backupCreatedFilename))
+	if err == nil {
+		err = json.Unmarshal(data, &created)
+		if err != nil {
+			return fmt.Errorf("error parsing '%s': %w", backupCreatedFilename, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error reading '%s': %w", backupCreatedFilename, err)
+	}
+
+	repoFiles, _ := filepath.Glob(filepath.Join(runDir, "repos", "*.json"))
+	permissionFiles, _ := filepath.Glob(filepath.Join(runDir, "permissions", "*.json"))
+
+	if len(repoFiles) == 0 && len(permissionFiles) == 0 && len(created.Repos) == 0 && len(created.Permissions) == 0 {
+		return fmt.Errorf("no backup found in: '%s'", runDir)
+	}
+
+	errorCount := 0
+
+	err = lock.Check()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range slices.Backward(created.Permissions) {
+		url := fmt.Sprintf("%s/access/api/v2/permissions/%s", baseurl, url.PathEscape(name))
+		err := rollbackRequest(client, token, "DELETE", url, "", dryRun)
+		if err != nil {
+			fmt.Printf("'%s': Warning: Couldn't delete created permission target: %v\n", name, err)
+			errorCount++
+			continue
+		}
+		fmt.Printf("'%s': Deleted created permission target.\n", name)
+	}
+
+	err = lock.Check()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range slices.Backward(created.Repos) {
+		url := fmt.Sprintf("%s/artifactory/api/repositories/%s", baseurl, url.PathEscape(name))
+		err := rollbackRequest(client, token, "DELETE", url, "", dryRun)
+		if err != nil {
+			fmt.Printf("'%s': Warning: Couldn't delete created repo: %v\n", name, err)
+			errorCount++
+			continue
+		}
+		fmt.Printf("'%s': Deleted created repo.\n", name)
+	}
+
+	err = lock.Check()
+	if err != nil {
+		return err
+	}
+
+	for _, filename := range repoFiles {
+		err := rollbackRepo(client, baseurl, token, filename, showDiff, dryRun)
+		if err != nil {
+			fmt.Printf("Warning: Couldn't restore repo from '%s': %v\n", filename, err)
+			errorCount++
+		}
+	}
+
+	err = lock.Check()
+	if err != nil {
+		return err
+	}
+
+	for _, filename := range permissionFiles {
+		err := rollbackPermission(client, baseurl, token, filename, showDiff, dryRun)
+		if err != nil {
+			fmt.Printf("Warning: Couldn't restore permission target from '%s': %v\n", filename, err)
+			errorCount++
+		}
+	}
+
+	if errorCount > 0 {
+		return fmt.Errorf("rollback incomplete, %d errors", errorCount)
+	}
+
+	return nil
+}
+
+func rollbackRepo(client *http.Client, baseurl string, token string, filename string, showDiff bool, dryRun bool) error {
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var repo ArtifactoryRepoDetailsResponse
+	err = json.Unmarshal(source, &repo)
+	if err != nil {
+		return fmt.Errorf("error parsing backup: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/artifactory/api/repositories/%s", baseurl, url.PathEscape(repo.Key))
+
+	current, err := rollbackGet(client, token, url)
+	if err != nil {
+		return err
+	}
+
+	printRollbackDiff(repo.Key, "Repo", current, string(source), showDiff)
+
+	method := "POST"
+	if current == "" {
+		method = "PUT"
+	}
+
+	err = rollbackRequest(client, token, method, url, string(source), dryRun)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("'%s': Restored repo.\n", repo.Key)
+
+	return nil
+}
+
+func rollbackPermission(client *http.Client, baseurl string, token string, filename string, showDiff bool, dryRun bool) error {
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var permission struct {
+		Name      string `json:"name"`
+		Resources struct {
+			Artifact json.RawMessage `json:"artifact"`
+		} `json:"resources"`
+	}
+	err = json.Unmarshal(source, &permission)
+	if err != nil {
+		return fmt.Errorf("error parsing backup: %w", err)
+	}
+	if len(permission.Resources.Artifact) == 0 {
+		return fmt.Errorf("no artifact resource in backup of permission target '%s'", permission.Name)
+	}
+
+	url := fmt.Sprintf("%s/access/api/v2/permissions/%s", baseurl, url.PathEscape(permission.Name))
+
+	current, err := rollbackGet(client, token, url)
+	if err != nil {
+		return err
+	}
+
+	if current == "" {
+		printRollbackDiff(permission.Name, "Permission", current, string(source), showDiff)
+		err = rollbackRequest(client, token, "POST", fmt.Sprintf("%s/access/api/v2/permissions", baseurl), string(source), dryRun)
+	} else {
+		printRollbackDiff(permission.Name, "Permission", GetArtifactJson(current), string(permission.Resources.Artifact), showDiff)
+		err = rollbackRequest(client, token, "PUT", url+"/artifact", string(permission.Resources.Artifact), dryRun)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("'%s': Restored permission target.\n", permission.Name)
+
+	return nil
+}
+
+func printRollbackDiff(name string, kind string, current string, restored string, showDiff bool) {
+	if current == "" {
+		fmt.Printf("'%s': %s was deleted after the run, recreating.\n", name, kind)
+		return
+	}
+
+	if showDiff {
+		difftext, _ := PrintDiff(current, restored, true)
+		fmt.Printf("%s", difftext)
+	}
+	difftext, _ := PrintDiff(current, restored, false)
+	log.Printf("%s (rollback) diff: '%s'\n%s", kind, name, difftext)
+}
+
+// Returns the current json of an object, or an empty string if it doesn't exist anymore.
+func rollbackGet(client *http.Client, token string, url string) (string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := doRequest(client, req)
+	if err != nil {
+		return "", fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != 200 {
+		return "", newHTTPStatusError(req, resp, body)
+	}
+
+	return string(body), nil
+}
+
+func rollbackRequest(client *http.Client, token string, method string, url string, body string, dryRun bool) error {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	resp, err := doRequest(client, req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newHTTPStatusError(req, resp, respBody)
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestProvisionBackup(t *testing.T) {
+	reposToProvision := []Repo{
+		{Name: "existing", Description: "new description"},
+		{Name: "created"},
+	}
+	repos := []ArtifactoryRepoDetailsResponse{
+		{Key: "existing", Description: "old description", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default",
+			JsonSource: `{"key":"existing","description":"old description","rclass":"local","packageType":"generic","repoLayoutRef":"simple-default","xrayIndex":true}`},
+	}
+
+	client := mockHTTPClient(func(req *http.Request) (*http.Response, error) {
+		status := 200
+		if req.Method == "POST" && strings.HasSuffix(req.URL.Path, "/access/api/v2/permissions") {
+			status = 201
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+	})
+
+	backup := NewBackup(filepath.Join(t.TempDir(), "run"))
+
+	ClearStats()
+	err := Provision(client, "", "", reposToProvision, repos, nil, nil, nil, false, false, LdapConfig{}, PropertiesConfig{}, nil, backup, nil, 1, false)
+	if err != nil {
+		t.Fatalf("ProvisionBackup: error = %v", err)
+	}
+
+	saved, err := os.ReadFile(filepath.Join(backup.Dir, "repos", "existing.json"))
+	if err != nil {
+		t.Fatalf("ProvisionBackup: repo backup not saved: %v", err)
+	}
+	if string(saved) != repos[0].JsonSource {
+		t.Errorf("ProvisionBackup: got backup '%s', want previous json", saved)
+	}
+
+	var created BackupCreated
+	data, _ := os.ReadFile(filepath.Join(backup.Dir, backupCreatedFilename))
+	json.Unmarshal(data, &created)
+	if !slices.Equal(created.Repos, []string{"created"}) || !slices.Equal(created.Permissions, []string{"existing", "created"}) {
+		t.Errorf("ProvisionBackup: got created %+v", created)
+	}
+}
+
+func TestProvisionBackupFromCache(t *testing.T) {
+	reposToProvision := []Repo{
+		{Name: "cached", Description: "new description"},
+		{Name: "unreadable", Description: "new description"},
+	}
+	// Cached details don't have the json source.
+	repos := []ArtifactoryRepoDetailsResponse{
+		{Key: "cached", Description: "old description", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "unreadable", Description: "old description", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+	}
+	current := `{"key":"cached","description":"old description","rclass":"local","packageType":"generic","xrayIndex":true}`
+
+	var updated []string
+	var mutex sync.Mutex
+	client := mockHTTPClient(func(req *http.Request) (*http.Response, error) {
+		status, body := 200, ""
+		switch {
+		case req.Method == "GET" && req.URL.Path == "/artifactory/api/repositories/cached":
+			body = current
+		case req.Method == "GET" && req.URL.Path == "/artifactory/api/repositories/unreadable":
+			status = 403
+		case req.Method == "POST" && strings.HasPrefix(req.URL.Path, "/artifactory/api/repositories/"):
+			mutex.Lock()
+			updated = append(updated, strings.TrimPrefix(req.URL.Path, "/artifactory/api/repositories/"))
+			mutex.Unlock()
+		case req.Method == "POST":
+			status = 201
+		}
+		return &http.Response{StatusCode: status, Status: http.StatusText(status), Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+	})
+
+	backup := NewBackup(filepath.Join(t.TempDir(), "run"))
+
+	ClearStats()
+	err := Provision(client, "", "", reposToProvision, repos, nil, nil, nil, false, false, LdapConfig{}, PropertiesConfig{}, nil, backup, nil, 1, false)
+	if err != nil {
+		t.Fatalf("ProvisionBackupFromCache: error = %v", err)
+	}
+
+	saved, err := os.ReadFile(filepath.Join(backup.Dir, "repos", "cached.json"))
+	if err != nil {
+		t.Fatalf("ProvisionBackupFromCache: repo backup not saved: %v", err)
+	}
+	if string(saved) != current {
+		t.Errorf("ProvisionBackupFromCache: got backup '%s', want current json '%s'", saved, current)
+	}
+
+	if !slices.Equal(updated, []string{"cached"}) {
+		t.Errorf("ProvisionBackupFromCache: updated %v, want only the repo with a backup", updated)
+	}
+	if stats.IgnoredInvalidRepoCount != 1 {
+		t.Errorf("ProvisionBackupFromCache: got %d ignored repos, want 1", stats.IgnoredInvalidRepoCount)
+	}
+}
+
+func TestProvisionBackupDryRun(t *testing.T) {
+	reposToProvision := []Repo{{Name: "created"}}
+
+	backup := NewBackup(filepath.Join(t.TempDir(), "run"))
+
+	err := Provision(nil, "", "", reposToProvision, nil, nil, nil, nil, false, false, LdapConfig{}, PropertiesConfig{}, nil, backup, nil, 1, true)
+	if err != nil {
+		t.Fatalf("ProvisionBackupDryRun: error = %v", err)
+	}
+
+	if _, err := os.Stat(backup.Dir); !os.IsNotExist(err) {
+		t.Errorf("ProvisionBackupDryRun: backup folder created in dry run")
+	}
+}
+
+func TestRollback(t *testing.T) {
+	runDir := t.TempDir()
+	os.MkdirAll(filepath.Join(runDir, "repos"), 0755)
+	os.MkdirAll(filepath.Join(runDir, "permissions"), 0755)
+	os.WriteFile(filepath.Join(runDir, "repos", "repo1.json"), []byte(`{"key":"repo1","description":"old"}`), 0600)
+	os.WriteFile(filepath.Join(runDir, "permissions", "repo1.json"), []byte(`{"name":"repo1","resources":{"artifact":{"actions":{"users":{"user1":["READ"]}}}}}`), 0600)
+	os.WriteFile(filepath.Join(runDir, backupCreatedFilename), []byte(`{"repos":["new1","new2"],"permissions":["new1"]}`), 0600)
+
+	var requests []string
+	var bodies []string
+	var mutex sync.Mutex
+	client := mockHTTPClient(func(req *http.Request) (*http.Response, error) {
+		body := ""
+		if req.Body != nil {
+			data, _ := io.ReadAll(req.Body)
+			body = string(data)
+		}
+
+		mutex.Lock()
+		requests = append(requests, req.Method+" "+req.URL.Path)
+		bodies = append(bodies, body)
+		mutex.Unlock()
+
+		response := ""
+		switch req.URL.Path {
+		case "/artifactory/api/repositories/repo1":
+			response = `{"key":"repo1","description":"changed"}`
+		case "/access/api/v2/permissions/repo1":
+			response = `{"name":"repo1","resources":{"artifact":{"actions":{"users":{"user2":["READ"]}}}}}`
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(response)), Header: make(http.Header)}, nil
+	})
+
+	err := Rollback(client, "", "", runDir, nil, false, false)
+	if err != nil {
+		t.Fatalf("Rollback: error = %v", err)
+	}
+
+	want := []string{
+		"DELETE /access/api/v2/permissions/new1",
+		"DELETE /artifactory/api/repositories/new2",
+		"DELETE /artifactory/api/repositories/new1",
+		"GET /artifactory/api/repositories/repo1",
+		"POST /artifactory/api/repositories/repo1",
+		"GET /access/api/v2/permissions/repo1",
+		"PUT /access/api/v2/permissions/repo1/artifact",
+	}
+	if !slices.Equal(requests, want) {
+		t.Fatalf("Rollback: got requests %v, want %v", requests, want)
+	}
+	if bodies[4] != `{"key":"repo1","description":"old"}` {
+		t.Errorf("Rollback: repo restored with '%s'", bodies[4])
+	}
+	if bodies[6] != `{"actions":{"users":{"user1":["READ"]}}}` {
+		t.Errorf("Rollback: permission target restored with '%s'", bodies[6])
+	}
+}
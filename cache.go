@@ -0,0 +1,556 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const cacheMetadataFilename = "metadata.json"
+
+// Resource types that can be invalidated, and the cached resources they cover.
+var cacheResourceTypes = map[string][]string{
+	"repos":       {"repos", "repodetails"},
+	"permissions": {"permissions", "permissiondetails"},
+	"users":       {"users"},
+	"groups":      {"groups"},
+	"ldap":        {"ldapsettings", "ldapgroups"},
+}
+
+// In incremental mode these are always retrieved from the list endpoints, and only details
+// of new keys and a sample of the existing keys are retrieved again.
+var incrementalCacheResources = []string{"repos", "repodetails", "permissions", "permissiondetails"}
+
+type Cache struct {
+	Folder           string
+	BaseURL          string
+	TokenHash        string
+	MaxAge           time.Duration
+	Invalidate       []string
+	Use              bool
+	Incremental      bool
+	RevalidateSample int
+
+	mutex    sync.Mutex
+	metadata CacheMetadata
+}
+
+type CacheMetadata struct {
+	BaseURL   string                          `json:"baseUrl"`
+	TokenHash string                          `json:"tokenHash"`
+	Resources map[string]time.Time            `json:"resources"`
+	Items     map[string]map[string]CacheItem `json:"items,omitempty"`
+}
+
+type CacheItem struct {
+	Fetched time.Time `json:"fetched"`
+	ETag    string    `json:"etag,omitempty"`
+}
+
+func NewCache(folder string, baseurl string, token string, use bool, maxAge time.Duration, invalidate []string) (*Cache, error) {
+	for _, resourceType := range invalidate {
+		if _, ok := cacheResourceTypes[resourceType]; !ok && resourceType != "all" {
+			return nil, fmt.Errorf("unknown cache resource type: '%s', valid types: all, repos, permissions, users, groups, ldap", resourceType)
+		}
+	}
+
+	if _, err := os.Stat(folder); os.IsNotExist(err) {
+		fmt.Printf("Creating folder: '%s'\n", folder)
+		err = os.MkdirAll(folder, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("error creating folder '%s': %w", folder, err)
+		}
+	}
+
+	cache := &Cache{
+		Folder:     folder,
+		BaseURL:    baseurl,
+		TokenHash:  getTokenHash(token),
+		MaxAge:     maxAge,
+		Invalidate: invalidate,
+		Use:        use,
+	}
+
+	data, err := os.ReadFile(cache.Path(cacheMetadataFilename))
+	if err == nil {
+		err = json.Unmarshal(data, &cache.metadata)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing cache metadata: %w", err)
+		}
+
+		if cache.metadata.BaseURL != baseurl || cache.metadata.TokenHash != cache.TokenHash {
+			if use {
+				fmt.Printf("Warning: Cache was created for another Artifactory instance or token, not using it.\n")
+			}
+			cache.metadata = CacheMetadata{}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading cache metadata: %w", err)
+	}
+
+	cache.metadata.BaseURL = baseurl
+	cache.metadata.TokenHash = cache.TokenHash
+	if cache.metadata.Resources == nil {
+		cache.metadata.Resources = make(map[string]time.Time)
+	}
+	if cache.metadata.Items == nil {
+		cache.metadata.Items = make(map[string]map[string]CacheItem)
+	}
+
+	return cache, nil
+}
+
+// Each Artifactory instance gets its own subfolder, so that caches of different instances never mix.
+func GetCacheFolder(cacheDir string, baseurl string) string {
+	name := baseurl
+	if parsed, err := url.Parse(baseurl); err == nil && parsed.Host != "" {
+		name = parsed.Host
+	}
+	name = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, name)
+
+	hash := sha256.Sum256([]byte(baseurl))
+	return filepath.Join(cacheDir, fmt.Sprintf("%s-%s", name, hex.EncodeToString(hash[:])[:8]))
+}
+
+func getTokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])[:16]
+}
+
+func (c *Cache) Path(filename string) string {
+	return filepath.Join(c.Folder, filename)
+}
+
+func (c *Cache) IsValid(resource string, filename string) bool {
+	if c == nil || !c.Use {
+		return false
+	}
+
+	if c.Incremental && slices.Contains(incrementalCacheResources, resource) {
+		return false
+	}
+
+	return c.isFresh(resource, filename)
+}
+
+// Whether cached details can be reused for keys that are still listed.
+func (c *Cache) IsIncremental(resource string, filename string) bool {
+	return c != nil && c.Incremental && c.isFresh(resource, filename)
+}
+
+func (c *Cache) isFresh(resource string, filename string) bool {
+	for _, resourceType := range c.Invalidate {
+		if resourceType == "all" || slices.Contains(cacheResourceTypes[resourceType], resource) {
+			return false
+		}
+	}
+
+	if _, err := os.Stat(c.Path(filename)); err != nil {
+		return false
+	}
+
+	c.mutex.Lock()
+	fetched, ok := c.metadata.Resources[resource]
+	c.mutex.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	if c.MaxAge > 0 && time.Since(fetched) > c.MaxAge {
+		fmt.Printf("Cached %s are older than %s, refreshing.\n", resource, c.MaxAge)
+		return false
+	}
+
+	return true
+}
+
+// Returns the listed keys whose details should be retrieved: keys that aren't cached,
+// and the cached keys that were retrieved longest ago, up to RevalidateSample.
+func (c *Cache) GetKeysToFetch(resource string, keys []string, cachedKeys map[string]bool) map[string]bool {
+	fetch := make(map[string]bool)
+
+	var existing []string
+	for _, key := range keys {
+		if cachedKeys[key] {
+			existing = append(existing, key)
+		} else {
+			fetch[key] = true
+		}
+	}
+
+	c.mutex.Lock()
+	items := c.metadata.Items[resource]
+	slices.SortStableFunc(existing, func(a string, b string) int {
+		return items[a].Fetched.Compare(items[b].Fetched)
+	})
+	c.mutex.Unlock()
+
+	for _, key := range existing[:min(c.RevalidateSample, len(existing))] {
+		fetch[key] = true
+	}
+
+	return fetch
+}
+
+func (c *Cache) GetItem(resource string, key string) (CacheItem, bool) {
+	if c == nil {
+		return CacheItem{}, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	item, ok := c.metadata.Items[resource][key]
+	return item, ok
+}
+
+// Records when the details of a key were retrieved. Saved together with the resource.
+func (c *Cache) SetItem(resource string, key string, etag string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.metadata.Items[resource] == nil {
+		c.metadata.Items[resource] = make(map[string]CacheItem)
+	}
+	c.metadata.Items[resource][key] = CacheItem{Fetched: time.Now().UTC(), ETag: etag}
+}
+
+// Drops items of keys that no longer exist in Artifactory, returns the number of dropped items.
+func (c *Cache) PruneItems(resource string, keys []string) int {
+	if c == nil {
+		return 0
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	listed := make(map[string]bool)
+	for _, key := range keys {
+		listed[key] = true
+	}
+
+	count := 0
+	for key := range c.metadata.Items[resource] {
+		if !listed[key] {
+			delete(c.metadata.Items[resource], key)
+			count++
+		}
+	}
+
+	return count
+}
+
+// Writes a cached resource and records when it was fetched.
+func (c *Cache) Save(resource string, filename string, data []byte) error {
+	if c == nil {
+		return nil
+	}
+
+	err := os.WriteFile(c.Path(filename), data, 0600)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.metadata.Resources[resource] = time.Now().UTC()
+
+	return c.saveMetadata()
+}
+
+// Removes page files of earlier runs, which would otherwise be mixed with the current ones.
+func (c *Cache) RemovePageFiles(prefix string) error {
+	if c == nil {
+		return nil
+	}
+
+	files, err := filepath.Glob(c.Path(prefix + "_page_*.json"))
+	if err != nil {
+		return fmt.Errorf("error finding page files: %w", err)
+	}
+
+	for _, file := range files {
+		err = os.Remove(file)
+		if err != nil {
+			return fmt.Errorf("error removing page file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Cache) saveMetadata() error {
+	data, err := json.MarshalIndent(c.metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error generating json: %w", err)
+	}
+
+	err = os.WriteFile(c.Path(cacheMetadataFilename), data, 0600)
+	if err != nil {
+		return fmt.Errorf("error saving cache metadata: %w", err)
+	}
+
+	return nil
+}
+
+// Keeps the cached repos accurate after a successful write, without refetching them.
+func (c *Cache) UpdateRepo(repo Repo, existingRepo *ArtifactoryRepoDetailsResponse) {
+	if c == nil {
+		return
+	}
+
+	repodetails := getProvisionedRepoDetails(repo, existingRepo)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var allrepos []ArtifactoryRepoResponse
+	if c.readCachedFile("allrepos.json", &allrepos) {
+		index := slices.IndexFunc(allrepos, func(r ArtifactoryRepoResponse) bool { return r.Key == repodetails.Key })
+		listed := ArtifactoryRepoResponse{
+			Key:         repodetails.Key,
+			Description: repodetails.Description,
+			Type:        strings.ToUpper(repodetails.Rclass),
+			PackageType: repodetails.PackageType,
+		}
+		if index == -1 {
+			allrepos = append(allrepos, listed)
+		} else {
+			allrepos[index] = listed
+		}
+		c.writeCachedFile("allrepos.json", allrepos)
+	}
+
+	var allrepodetails []ArtifactoryRepoDetailsResponse
+	if c.readCachedFile("allrepodetails.json", &allrepodetails) {
+		index := slices.IndexFunc(allrepodetails, func(r ArtifactoryRepoDetailsResponse) bool { return r.Key == repodetails.Key })
+		if index == -1 {
+			allrepodetails = append(allrepodetails, repodetails)
+		} else {
+			allrepodetails[index] = repodetails
+		}
+		c.writeCachedFile("allrepodetails.json", allrepodetails)
+	}
+}
+
+// Keeps the cached permission targets accurate after a successful write, without refetching them.
+func (c *Cache) UpdatePermission(permissiondetails ArtifactoryPermissionDetails) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var allpermissions []ArtifactoryPermission
+	if c.readCachedFile("allpermissions.json", &allpermissions) {
+		if !slices.ContainsFunc(allpermissions, func(p ArtifactoryPermission) bool { return p.Name == permissiondetails.Name }) {
+			allpermissions = append(allpermissions, ArtifactoryPermission{Name: permissiondetails.Name})
+			c.writeCachedFile("allpermissions.json", allpermissions)
+		}
+	}
+
+	var allpermissiondetails []ArtifactoryPermissionDetails
+	if c.readCachedFile("allpermissiondetails.json", &allpermissiondetails) {
+		index := slices.IndexFunc(allpermissiondetails, func(p ArtifactoryPermissionDetails) bool { return p.Name == permissiondetails.Name })
+		if index == -1 {
+			allpermissiondetails = append(allpermissiondetails, permissiondetails)
+		} else {
+			allpermissiondetails[index] = permissiondetails
+		}
+		c.writeCachedFile("allpermissiondetails.json", allpermissiondetails)
+	}
+}
+
+func (c *Cache) readCachedFile(filename string, v any) bool {
+	data, err := os.ReadFile(c.Path(filename))
+	if err != nil {
+		return false
+	}
+
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		fmt.Printf("Warning: Couldn't parse cached file '%s': %v\n", filename, err)
+		return false
+	}
+
+	return true
+}
+
+func (c *Cache) writeCachedFile(filename string, v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err == nil {
+		err = os.WriteFile(c.Path(filename), data, 0600)
+	}
+	if err != nil {
+		fmt.Printf("Warning: Couldn't update cached file '%s': %v\n", filename, err)
+	}
+}
+
+func getProvisionedRepoDetails(repo Repo, existingRepo *ArtifactoryRepoDetailsResponse) ArtifactoryRepoDetailsResponse {
+	var repodetails ArtifactoryRepoDetailsResponse
+	if existingRepo != nil {
+		repodetails = *existingRepo
+	}
+
+	repodetails.Key = repo.Name
+	repodetails.Description = repo.Description
+	repodetails.Rclass = repo.Rclass
+	repodetails.PackageType = repo.PackageType
+	repodetails.RepoLayoutRef = repo.Layout
+
+	if repodetails.Rclass == "" {
+		repodetails.Rclass = "local"
+	}
+	if repodetails.PackageType == "" {
+		repodetails.PackageType = "generic"
+	}
+	if repodetails.RepoLayoutRef == "" {
+		repodetails.RepoLayoutRef = "simple-default"
+	}
+	if repodetails.Rclass == "remote" {
+		repodetails.Url = repo.Url
+	}
+	if repodetails.Rclass == "virtual" && repo.Repositories != nil {
+		repodetails.Repositories = repo.Repositories
+	}
+
+	return repodetails
+}
+
+func getProvisionedPermissionDetails(repo Repo, existingPermission *ArtifactoryPermissionDetails, users map[string][]string, groups map[string][]string) ArtifactoryPermissionDetails {
+	permissionName := repo.Name
+	if repo.PermissionName != "" {
+		permissionName = repo.PermissionName
+	}
+
+	repoName := repo.Name
+	if repo.Rclass == "remote" {
+		repoName = repo.Name + "-cache"
+	}
+
+	target := ArtifactoryPermissionDetailsTarget{IncludePatterns: []string{"**"}, ExcludePatterns: []string{}}
+	if existingPermission != nil {
+		target = existingPermission.Resources.Artifact.Targets[repoName]
+	}
+
+	return ArtifactoryPermissionDetails{
+		Name: permissionName,
+		Resources: ArtifactoryPermissionDetailsResources{
+			Artifact: ArtifactoryPermissionDetailsArtifact{
+				Actions: ArtifactoryPermissionDetailsActions{
+					Users:  users,
+					Groups: groups,
+				},
+				Targets: map[string]ArtifactoryPermissionDetailsTarget{
+					repoName: target,
+				},
+			},
+		},
+	}
+}
+
+func ListCaches(cacheDir string) error {
+	entries, err := os.ReadDir(cacheDir)
+	if os.IsNotExist(err) {
+		fmt.Printf("No caches found in: '%s'\n", cacheDir)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading cache folder '%s': %w", cacheDir, err)
+	}
+
+	count := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		folder := filepath.Join(cacheDir, entry.Name())
+
+		var metadata CacheMetadata
+		data, err := os.ReadFile(filepath.Join(folder, cacheMetadataFilename))
+		if err != nil || json.Unmarshal(data, &metadata) != nil {
+			continue
+		}
+		count++
+
+		fmt.Printf("%s: '%s'\n", metadata.BaseURL, folder)
+
+		var resources []string
+		for resource := range metadata.Resources {
+			resources = append(resources, resource)
+		}
+		slices.Sort(resources)
+
+		for _, resource := range resources {
+			fetched := metadata.Resources[resource]
+			fmt.Printf("  %s: %s (%s ago)\n", resource, fetched.Local().Format(time.DateTime), time.Since(fetched).Round(time.Second))
+		}
+	}
+
+	if count == 0 {
+		fmt.Printf("No caches found in: '%s'\n", cacheDir)
+	}
+
+	return nil
+}
+
+// Removes the cache of one Artifactory instance, or all caches if baseurl is empty.
+func ClearCaches(cacheDir string, baseurl string) error {
+	if baseurl != "" {
+		folder := GetCacheFolder(cacheDir, baseurl)
+		if _, err := os.Stat(folder); os.IsNotExist(err) {
+			fmt.Printf("No cache found for: '%s'\n", baseurl)
+			return nil
+		}
+
+		fmt.Printf("Removing cache: '%s'\n", folder)
+		return os.RemoveAll(folder)
+	}
+
+	entries, err := os.ReadDir(cacheDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading cache folder '%s': %w", cacheDir, err)
+	}
+
+	for _, entry := range entries {
+		folder := filepath.Join(cacheDir, entry.Name())
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(folder, cacheMetadataFilename)); err != nil {
+			continue
+		}
+
+		fmt.Printf("Removing cache: '%s'\n", folder)
+		err = os.RemoveAll(folder)
+		if err != nil {
+			return fmt.Errorf("error removing cache '%s': %w", folder, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCache(t *testing.T) *Cache {
+	cache, err := NewCache(t.TempDir(), "", "", false, 0, nil)
+	if err != nil {
+		t.Fatalf("NewCache: error = %v", err)
+	}
+	return cache
+}
+
+func TestCacheIsValid(t *testing.T) {
+	tests := []struct {
+		baseurl    string
+		token      string
+		maxAge     time.Duration
+		invalidate []string
+		fetchedAgo time.Duration
+		resource   string
+		want       bool
+	}{
+		// Fresh cache
+		{"http://artifactory", "token", 0, nil, time.Hour, "repos", true},
+		// Within max age
+		{"http://artifactory", "token", 2 * time.Hour, nil, time.Hour, "repos", true},
+		// Too old
+		{"http://artifactory", "token", 30 * time.Minute, nil, time.Hour, "repos", false},
+		// Invalidated resource type
+		{"http://artifactory", "token", 0, []string{"repos"}, time.Hour, "repodetails", false},
+		// Other resource type invalidated
+		{"http://artifactory", "token", 0, []string{"permissions"}, time.Hour, "repodetails", true},
+		// Everything invalidated
+		{"http://artifactory", "token", 0, []string{"all"}, time.Hour, "users", false},
+		// Other Artifactory instance
+		{"http://other", "token", 0, nil, time.Hour, "repos", false},
+		// Other token
+		{"http://artifactory", "other", 0, nil, time.Hour, "repos", false},
+	}
+
+	for i, tc := range tests {
+		folder := t.TempDir()
+
+		metadata := CacheMetadata{
+			BaseURL:   "http://artifactory",
+			TokenHash: getTokenHash("token"),
+			Resources: map[string]time.Time{tc.resource: time.Now().Add(-tc.fetchedAgo)},
+		}
+		data, _ := json.Marshal(metadata)
+		os.WriteFile(folder+"/"+cacheMetadataFilename, data, 0600)
+		os.WriteFile(folder+"/cached.json", []byte("[]"), 0600)
+
+		cache, err := NewCache(folder, tc.baseurl, tc.token, true, tc.maxAge, tc.invalidate)
+		if err != nil {
+			t.Fatalf("CacheIsValid (%d/%d): error = %v", i+1, len(tests), err)
+		}
+
+		got := cache.IsValid(tc.resource, "cached.json")
+		if got != tc.want {
+			t.Errorf("CacheIsValid (%d/%d): got %v, want %v", i+1, len(tests), got, tc.want)
+		}
+	}
+}
+
+func TestCacheInvalidResourceType(t *testing.T) {
+	_, err := NewCache(t.TempDir(), "", "", true, 0, []string{"repositories"})
+	if err == nil {
+		t.Errorf("CacheInvalidResourceType: expected error for unknown resource type")
+	}
+}
+
+func TestCacheUpdate(t *testing.T) {
+	cache := newTestCache(t)
+
+	cache.writeCachedFile("allrepos.json", []ArtifactoryRepoResponse{{Key: "repo1", Type: "LOCAL", PackageType: "generic"}})
+	cache.writeCachedFile("allrepodetails.json", []ArtifactoryRepoDetailsResponse{{Key: "repo1", Rclass: "local", PackageType: "generic", Description: "old"}})
+	cache.writeCachedFile("allpermissions.json", []ArtifactoryPermission{})
+	cache.writeCachedFile("allpermissiondetails.json", []ArtifactoryPermissionDetails{})
+
+	existing := ArtifactoryRepoDetailsResponse{Key: "repo1", Rclass: "local", PackageType: "generic", Description: "old"}
+	cache.UpdateRepo(Repo{Name: "repo1", Description: "new"}, &existing)
+	cache.UpdateRepo(Repo{Name: "repo2", Rclass: "remote", PackageType: "npm", Url: "https://registry.npmjs.org"}, nil)
+
+	users := map[string][]string{"user1": {"READ"}}
+	cache.UpdatePermission(getProvisionedPermissionDetails(Repo{Name: "repo2", Rclass: "remote"}, nil, users, nil))
+
+	var allrepos []ArtifactoryRepoResponse
+	cache.readCachedFile("allrepos.json", &allrepos)
+	if len(allrepos) != 2 || allrepos[1].Key != "repo2" || allrepos[1].Type != "REMOTE" {
+		t.Errorf("CacheUpdate: repos = %v", allrepos)
+	}
+
+	var allrepodetails []ArtifactoryRepoDetailsResponse
+	cache.readCachedFile("allrepodetails.json", &allrepodetails)
+	if len(allrepodetails) != 2 || allrepodetails[0].Description != "new" || allrepodetails[1].Url != "https://registry.npmjs.org" {
+		t.Errorf("CacheUpdate: repo details = %v", allrepodetails)
+	}
+
+	var allpermissiondetails []ArtifactoryPermissionDetails
+	cache.readCachedFile("allpermissiondetails.json", &allpermissiondetails)
+	if len(allpermissiondetails) != 1 || allpermissiondetails[0].Name != "repo2" {
+		t.Fatalf("CacheUpdate: permission details = %v", allpermissiondetails)
+	}
+	if _, ok := allpermissiondetails[0].Resources.Artifact.Targets["repo2-cache"]; !ok {
+		t.Errorf("CacheUpdate: remote repo permission target should point at the cache repo")
+	}
+
+	// Patterns of an updated remote repo's permission target are kept.
+	existingPermission := allpermissiondetails[0]
+	existingPermission.Resources.Artifact.Targets = map[string]ArtifactoryPermissionDetailsTarget{
+		"repo2-cache": {IncludePatterns: []string{"**"}, ExcludePatterns: []string{"*.tmp"}},
+	}
+	updated := getProvisionedPermissionDetails(Repo{Name: "repo2", Rclass: "remote"}, &existingPermission, users, nil)
+	if target := updated.Resources.Artifact.Targets["repo2-cache"]; !slices.Equal(target.ExcludePatterns, []string{"*.tmp"}) {
+		t.Errorf("CacheUpdate: remote repo permission target patterns = %+v, want exclude [*.tmp]", target)
+	}
+}
+
+func TestGetCacheFolder(t *testing.T) {
+	staging := GetCacheFolder("cache", "https://artifactory-staging.example.com")
+	production := GetCacheFolder("cache", "https://artifactory.example.com")
+	productionPath := GetCacheFolder("cache", "https://artifactory.example.com/other")
+
+	if staging == production || production == productionPath {
+		t.Errorf("GetCacheFolder: instances share cache folder: '%s', '%s', '%s'", staging, production, productionPath)
+	}
+	if !strings.HasPrefix(production, filepath.Join("cache", "artifactory.example.com-")) {
+		t.Errorf("GetCacheFolder: got '%s', want host based folder name", production)
+	}
+	if production != GetCacheFolder("cache", "https://artifactory.example.com") {
+		t.Errorf("GetCacheFolder: folder not stable")
+	}
+}
+
+func TestClearCaches(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	for _, baseurl := range []string{"https://staging", "https://production"} {
+		cache, err := NewCache(GetCacheFolder(cacheDir, baseurl), baseurl, "token", false, 0, nil)
+		if err != nil {
+			t.Fatalf("ClearCaches: error = %v", err)
+		}
+		cache.Save("repos", "allrepos.json", []byte("[]"))
+		os.WriteFile(cache.Path("allrepodetails_page_0007.json"), []byte("{}"), 0600)
+
+		cache.RemovePageFiles("allrepodetails")
+		if _, err := os.Stat(cache.Path("allrepodetails_page_0007.json")); !os.IsNotExist(err) {
+			t.Errorf("ClearCaches: stale page file not removed")
+		}
+	}
+
+	err := ClearCaches(cacheDir, "https://staging")
+	if err != nil {
+		t.Fatalf("ClearCaches: error = %v", err)
+	}
+	if _, err := os.Stat(GetCacheFolder(cacheDir, "https://staging")); !os.IsNotExist(err) {
+		t.Errorf("ClearCaches: staging cache not removed")
+	}
+	if _, err := os.Stat(GetCacheFolder(cacheDir, "https://production")); err != nil {
+		t.Errorf("ClearCaches: production cache removed")
+	}
+
+	err = ClearCaches(cacheDir, "")
+	if err != nil {
+		t.Fatalf("ClearCaches: error = %v", err)
+	}
+	entries, _ := os.ReadDir(cacheDir)
+	if len(entries) != 0 {
+		t.Errorf("ClearCaches: %d caches left", len(entries))
+	}
+}
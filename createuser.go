@@ -7,6 +7,8 @@ import (
 	"log"
 	"net/http"
 	"strings"
+
+	"github.com/go-ldap/ldap/v3"
 )
 
 // For mock testing.
@@ -45,7 +47,7 @@ func CreateUser(
 				basedn = basednPart
 			}
 
-			filter := strings.ReplaceAll(ldapSettingsSingle.Search.SearchFilter, "{0}", username)
+			filter := strings.ReplaceAll(ldapSettingsSingle.Search.SearchFilter, "{0}", ldap.EscapeFilter(username))
 
 			entries, err := queryldapCreateUserFn(
 				ldapSettingsSingle.LdapUrl,
@@ -118,7 +120,7 @@ func createSingleUser(client *http.Client, baseurl, token, username, emailaddres
 	req.Header.Set("Authorization", "Bearer "+token)
 
 	if !dryRun {
-		resp, err := client.Do(req)
+		resp, err := doRequest(client, req)
 		if err != nil {
 			return fmt.Errorf("error creating user: %w", err)
 		}
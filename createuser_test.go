@@ -102,3 +102,39 @@ func TestCreateUser(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateUserEscapedFilter(t *testing.T) {
+	ldapSettings := []ArtifactoryLDAPSettings{
+		{
+			Key:     "test-ldapsettings",
+			LdapUrl: "ldap://ldap.example.com",
+			Search: ArtifactoryLDAPSettingsSearch{
+				SearchFilter: "(&(objectClass=user)(sAMAccountName={0}))",
+				SearchBase:   "ou=users",
+			},
+			EmailAttribute: "mail",
+		},
+	}
+
+	origQuery := queryldapCreateUserFn
+	defer func() { queryldapCreateUserFn = origQuery }()
+
+	var gotFilter string
+	queryldapCreateUserFn = func(server, baseDN, filter, bindDN, bindPW string, attrs []string) ([]*ldap.Entry, error) {
+		gotFilter = filter
+		return []*ldap.Entry{}, nil
+	}
+
+	created, err := CreateUser(nil, "", "", "", "", "a*", ldapSettings, true)
+	if err != nil {
+		t.Errorf("CreateUserEscapedFilter: error = %v", err)
+	}
+	if created {
+		t.Errorf("CreateUserEscapedFilter: user unexpectedly created")
+	}
+
+	wantFilter := `(&(objectClass=user)(sAMAccountName=a\2a))`
+	if gotFilter != wantFilter {
+		t.Errorf("CreateUserEscapedFilter: got filter '%s', want '%s'", gotFilter, wantFilter)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Files with defaults for all repo files in their folder and subfolders.
+var defaultsFileNames = []string{"_defaults.yaml", "_defaults.yml", "_defaults.json"}
+
+func isDefaultsFile(path string) bool {
+	return slices.Contains(defaultsFileNames, filepath.Base(path))
+}
+
+type repoScalarField struct {
+	key   string
+	value *string
+}
+
+type repoListField struct {
+	key    string
+	values *[]string
+}
+
+func getRepoScalarFields(repo *Repo) []repoScalarField {
+	return []repoScalarField{
+		{"description", &repo.Description},
+		{"rclass", &repo.Rclass},
+		{"packageType", &repo.PackageType},
+		{"layout", &repo.Layout},
+		{"url", &repo.Url},
+		{"permissionName", &repo.PermissionName},
+	}
+}
+
+func getRepoListFields(repo *Repo) []repoListField {
+	fields := []repoListField{}
+	for _, list := range getRepoActionLists(repo) {
+		fields = append(fields, repoListField{strings.ToLower(list.action), list.principals})
+	}
+	return append(fields, repoListField{"repositories", &repo.Repositories})
+}
+
+// Applies defaults to a repo. Scalar fields of the repo win, and list fields are appended to
+// the default lists. Fields listed in 'override' are taken from the repo only, and with
+// 'inherit: false' no defaults are used at all. The control fields are cleared.
+func applyDefaults(repo *Repo, defaults *Repo) {
+	override := repo.Override
+	inherit := repo.Inherit == nil || *repo.Inherit
+	repo.Override = nil
+	repo.Inherit = nil
+
+	if defaults == nil || !inherit {
+		return
+	}
+
+	for _, key := range override {
+		if !isKnownRepoField(key) {
+			fmt.Printf("'%s': Warning: Ignoring unknown field '%s' in override.\n", repo.SourceFile, key)
+		}
+	}
+
+	defaultScalars := getRepoScalarFields(defaults)
+	for i, field := range getRepoScalarFields(repo) {
+		if *field.value == "" && !slices.Contains(override, field.key) {
+			*field.value = *defaultScalars[i].value
+		}
+	}
+
+	defaultLists := getRepoListFields(defaults)
+	for i, field := range getRepoListFields(repo) {
+		if slices.Contains(override, field.key) {
+			continue
+		}
+		values := slices.Clone(*defaultLists[i].values)
+		for _, value := range *field.values {
+			if !slices.Contains(values, value) {
+				values = append(values, value)
+			}
+		}
+		*field.values = values
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(defaults.ExtraFields)) {
+		if _, ok := repo.ExtraFields[key]; ok || slices.Contains(override, key) {
+			continue
+		}
+		if repo.ExtraFields == nil {
+			repo.ExtraFields = make(map[string]any)
+		}
+		repo.ExtraFields[key] = defaults.ExtraFields[key]
+	}
+}
+
+// Defaults of folders, chained from the parent folders.
+type repoDefaults struct {
+	folders map[string]*Repo
+}
+
+func newRepoDefaults() *repoDefaults {
+	return &repoDefaults{folders: make(map[string]*Repo)}
+}
+
+// Returns the defaults for repo files in a folder, or nil if there are none.
+func (d *repoDefaults) forFolder(folder string) (*Repo, error) {
+	folder = filepath.Clean(folder)
+	if defaults, ok := d.folders[folder]; ok {
+		return defaults, nil
+	}
+
+	var parentDefaults *Repo
+	if parent := filepath.Dir(folder); parent != folder {
+		var err error
+		parentDefaults, err = d.forFolder(parent)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	defaults := parentDefaults
+	for _, name := range defaultsFileNames {
+		filename := filepath.Join(folder, name)
+		data, err := os.ReadFile(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading defaults file '%s': %w", filename, err)
+		}
+
+		defaults, err = parseDefaults(data, filename)
+		if err != nil {
+			return nil, err
+		}
+		applyDefaults(defaults, parentDefaults)
+		break
+	}
+
+	d.folders[folder] = defaults
+	return defaults, nil
+}
+
+// Parses defaults, a mapping of repo fields without name.
+func parseDefaults(data []byte, source string) (*Repo, error) {
+	var defaults Repo
+	var rawDefaults map[string]any
+
+	// Json is valid yaml.
+	err := yaml.Unmarshal(data, &defaults)
+	if err == nil {
+		err = yaml.Unmarshal(data, &rawDefaults)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing defaults in '%s': %w", source, err)
+	}
+	if defaults.Name != "" || len(defaults.Names) > 0 {
+		return nil, fmt.Errorf("defaults in '%s' must not have a name", source)
+	}
+	if len(defaults.Matrix) > 0 {
+		return nil, fmt.Errorf("defaults in '%s' must not have a matrix", source)
+	}
+
+	defaults.SourceFile = source
+	defaults.ExtraFields = extractExtraFields(rawDefaults)
+	ExpandRoles(&defaults, repoRoles)
+
+	return &defaults, nil
+}
+
+// Parses a leading 'defaults:' yaml document of a repo file. The document is replaced with
+// blank lines in the returned data, so that line numbers and offsets of the repos are kept.
+func splitDefaultsDocument(data []byte, repofile string) (*Repo, []byte, error) {
+	lines := strings.SplitAfter(string(data), "\n")
+
+	start := 0
+	for start < len(lines) {
+		line := strings.TrimSpace(lines[start])
+		if line != "" && line != "---" && !strings.HasPrefix(line, "#") {
+			break
+		}
+		start++
+	}
+	if start == len(lines) || !strings.HasPrefix(lines[start], "defaults:") {
+		return nil, data, nil
+	}
+
+	end := slices.IndexFunc(lines[start:], func(line string) bool { return strings.TrimRight(line, " \r\n") == "---" })
+	if end == -1 {
+		return nil, nil, fmt.Errorf("defaults document must be followed by '---'")
+	}
+	end += start
+
+	var document map[string]yaml.RawMessage
+	err := yaml.Unmarshal([]byte(strings.Join(lines[:end], "")), &document)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing defaults document: %w", err)
+	}
+	if _, ok := document["defaults"]; !ok || len(document) != 1 {
+		return nil, nil, fmt.Errorf("defaults document must only have the 'defaults' field")
+	}
+
+	defaults, err := parseDefaults(document["defaults"], repofile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blanked := []byte(strings.Join(lines, ""))
+	for i := range len(strings.Join(lines[:end+1], "")) {
+		if blanked[i] != '\n' {
+			blanked[i] = ' '
+		}
+	}
+
+	return defaults, blanked, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadRepoFilesWithDefaults(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"_defaults.yaml": `packageType: docker
+read: [readers]
+team: platform
+`,
+		"team-x/_defaults.yaml": `rclass: local
+write: [team-x]
+deployers: [ci]
+`,
+		"team-x/repos.yaml": `defaults:
+  description: Team X
+  read: [team-x]
+---
+# first repo
+- name: x-docker
+  write: [alice]
+- name: x-maven
+  packageType: maven
+  override: [read, description]
+- name: x-plain
+  inherit: false
+`,
+		"team-y.yaml": `name: y-docker
+team: team-y
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
+	}
+
+	repos := LoadRepoFiles([]string{dir}, false)
+
+	repofile := filepath.Join(dir, "team-x", "repos.yaml")
+	want := []Repo{
+		{
+			Name: "x-docker", Description: "Team X", Rclass: "local", PackageType: "docker",
+			Read:        []string{"readers", "ci", "team-x"},
+			Annotate:    []string{"ci"},
+			Write:       []string{"team-x", "ci", "alice"},
+			ExtraFields: map[string]any{"team": "platform"},
+			SourceFile:  repofile, SourceLine: 6,
+		},
+		{
+			Name: "x-maven", Rclass: "local", PackageType: "maven",
+			Annotate:    []string{"ci"},
+			Write:       []string{"team-x", "ci"},
+			ExtraFields: map[string]any{"team": "platform"},
+			SourceFile:  repofile, SourceLine: 8,
+		},
+		{Name: "x-plain", SourceFile: repofile, SourceLine: 11},
+		{
+			Name: "y-docker", PackageType: "docker",
+			Read:        []string{"readers"},
+			ExtraFields: map[string]any{"team": "team-y"},
+			SourceFile:  filepath.Join(dir, "team-y.yaml"), SourceLine: 1,
+		},
+	}
+
+	if len(repos) != len(want) {
+		t.Fatalf("LoadRepoFilesWithDefaults: got %d repos, want %d: %+v", len(repos), len(want), repos)
+	}
+	for i := range want {
+		got := repos[i]
+		got.SourceOffset = 0
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("LoadRepoFilesWithDefaults (%d/%d): got\n%+v\nwant\n%+v", i+1, len(want), got, want[i])
+		}
+	}
+}
+
+func TestSplitDefaultsDocument(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Content string
+		WantErr bool
+	}{
+		{"No separator", "defaults:\n  rclass: local\n- name: a\n", true},
+		{"Other fields", "defaults:\n  rclass: local\nother: x\n---\n- name: a\n", true},
+		{"Named defaults", "defaults:\n  name: a\n---\n- name: a\n", true},
+		{"No defaults", "- name: a\n", false},
+	}
+
+	for i, test := range tests {
+		_, _, err := splitDefaultsDocument([]byte(test.Content), "repos.yaml")
+		if (err != nil) != test.WantErr {
+			t.Errorf("%s (%d/%d): error = %v, wantErr %v", test.Name, i+1, len(tests), err, test.WantErr)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Exit code of the drift command when drift was found, distinct from errors.
+const driftExitCode = 2
+
+type DriftReport struct {
+	BaseURL              string      `json:"baseUrl"`
+	CheckedAt            time.Time   `json:"checkedAt"`
+	HasDrift             bool        `json:"hasDrift"`
+	Repos                []RepoDrift `json:"repos"`
+	InvalidRepos         []string    `json:"invalidRepos"`
+	UncoveredRepos       []string    `json:"uncoveredRepos"`
+	UncoveredPermissions []string    `json:"uncoveredPermissions"`
+}
+
+type RepoDrift struct {
+	Name       string           `json:"name"`
+	Missing    bool             `json:"missing,omitempty"`
+	Fields     []FieldDrift     `json:"fields,omitempty"`
+	Permission *PermissionDrift `json:"permission,omitempty"`
+	Properties *PropertiesDrift `json:"properties,omitempty"`
+}
+
+type FieldDrift struct {
+	Field    string `json:"field"`
+	Live     any    `json:"live"`
+	Declared any    `json:"declared"`
+}
+
+type PermissionDrift struct {
+	Name           string              `json:"name"`
+	Missing        bool                `json:"missing,omitempty"`
+	LiveUsers      map[string][]string `json:"liveUsers,omitempty"`
+	DeclaredUsers  map[string][]string `json:"declaredUsers,omitempty"`
+	LiveGroups     map[string][]string `json:"liveGroups,omitempty"`
+	DeclaredGroups map[string][]string `json:"declaredGroups,omitempty"`
+}
+
+type PropertiesDrift struct {
+	Live     map[string][]string `json:"live,omitempty"`
+	Declared map[string][]string `json:"declared,omitempty"`
+	Unused   []string            `json:"unused,omitempty"`
+}
+
+// Compares the declared repos with Artifactory, read-only. Also reports declared repos that
+// are rejected by validation, and live repos and permission targets that aren't declared in any repo file.
+func CheckDrift(
+	client *http.Client,
+	baseurl string,
+	token string,
+	reposToCheck []Repo,
+	allrepos []ArtifactoryRepoDetailsResponse,
+	allusers []ArtifactoryUser,
+	allpermissiondetails []ArtifactoryPermissionDetails,
+	allowpatterns bool,
+	propertiesConfig PropertiesConfig) (DriftReport, error) {
+
+	report := DriftReport{
+		BaseURL:              baseurl,
+		CheckedAt:            time.Now().UTC(),
+		Repos:                []RepoDrift{},
+		InvalidRepos:         []string{},
+		UncoveredRepos:       []string{},
+		UncoveredPermissions: []string{},
+	}
+
+	validRepos, err := Validate(slices.Clone(reposToCheck), allrepos, allpermissiondetails)
+	if err != nil {
+		return report, fmt.Errorf("error validating: %w", err)
+	}
+
+	var declaredRepos []string
+	var declaredPermissions []string
+
+	for _, repo := range reposToCheck {
+		declaredRepos = append(declaredRepos, repo.Name)
+		if repo.Rclass != "virtual" {
+			declaredPermissions = append(declaredPermissions, getPermissionName(repo))
+		}
+		if !slices.ContainsFunc(validRepos, func(r Repo) bool { return r.Name == repo.Name }) {
+			report.InvalidRepos = append(report.InvalidRepos, repo.Name)
+		}
+	}
+
+	reposWithDiffs := findReposWithDiffs(validRepos, allrepos, allpermissiondetails, allusers, allowpatterns)
+
+	for _, repo := range validRepos {
+		drift := RepoDrift{Name: repo.Name}
+
+		existingIndex := slices.IndexFunc(allrepos, func(r ArtifactoryRepoDetailsResponse) bool { return r.Key == repo.Name })
+		if existingIndex == -1 {
+			drift.Missing = true
+		} else {
+			drift.Fields = getRepoFieldDrift(repo, allrepos[existingIndex])
+		}
+
+		diffIndex := slices.IndexFunc(reposWithDiffs, func(d repoDiff) bool { return d.repo.Name == repo.Name })
+		if diffIndex != -1 && reposWithDiffs[diffIndex].hasPermDiff {
+			diff := reposWithDiffs[diffIndex]
+			drift.Permission = &PermissionDrift{
+				Name:           getPermissionName(repo),
+				Missing:        diff.existingPermission == nil,
+				DeclaredUsers:  diff.permUsers,
+				DeclaredGroups: diff.permGroups,
+			}
+			if diff.existingPermission != nil {
+				drift.Permission.LiveUsers = diff.existingPermission.Resources.Artifact.Actions.Users
+				drift.Permission.LiveGroups = diff.existingPermission.Resources.Artifact.Actions.Groups
+			}
+		}
+
+		if existingIndex != -1 {
+			propsDiff, err := getRepoPropertiesDiff(client, baseurl, token, repo, propertiesConfig)
+			if err != nil {
+				return report, fmt.Errorf("'%s': error checking properties: %w", repo.Name, err)
+			}
+			if propsDiff != nil && (propsDiff.needsUpdate || len(propsDiff.unused) > 0) {
+				drift.Properties = getPropertiesDrift(propsDiff)
+			}
+		}
+
+		if drift.Missing || len(drift.Fields) > 0 || drift.Permission != nil || drift.Properties != nil {
+			report.Repos = append(report.Repos, drift)
+		}
+	}
+
+	for _, r := range allrepos {
+		if !slices.Contains(declaredRepos, r.Key) {
+			report.UncoveredRepos = append(report.UncoveredRepos, r.Key)
+		}
+	}
+	for _, p := range allpermissiondetails {
+		if !slices.Contains(declaredPermissions, p.Name) {
+			report.UncoveredPermissions = append(report.UncoveredPermissions, p.Name)
+		}
+	}
+	slices.Sort(report.UncoveredRepos)
+	slices.Sort(report.UncoveredPermissions)
+
+	// Invalid repos can't be compared, so they count as drift too.
+	report.HasDrift = len(report.Repos) > 0 || len(report.InvalidRepos) > 0 || len(report.UncoveredRepos) > 0 || len(report.UncoveredPermissions) > 0
+
+	return report, nil
+}
+
+func WriteDriftReport(w io.Writer, report DriftReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error generating json: %w", err)
+	}
+
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+func getPermissionName(repo Repo) string {
+	if repo.PermissionName != "" {
+		return repo.PermissionName
+	}
+	return repo.Name
+}
+
+func getRepoFieldDrift(repo Repo, existingRepo ArtifactoryRepoDetailsResponse) []FieldDrift {
+	declared := getProvisionedRepoDetails(repo, &existingRepo)
+
+	var fields []FieldDrift
+	if existingRepo.Description != declared.Description {
+		fields = append(fields, FieldDrift{"description", existingRepo.Description, declared.Description})
+	}
+	if existingRepo.Rclass != declared.Rclass {
+		fields = append(fields, FieldDrift{"rclass", existingRepo.Rclass, declared.Rclass})
+	}
+	if existingRepo.PackageType != declared.PackageType {
+		fields = append(fields, FieldDrift{"packageType", existingRepo.PackageType, declared.PackageType})
+	}
+	if existingRepo.RepoLayoutRef != declared.RepoLayoutRef {
+		fields = append(fields, FieldDrift{"layout", existingRepo.RepoLayoutRef, declared.RepoLayoutRef})
+	}
+	if existingRepo.Url != declared.Url {
+		fields = append(fields, FieldDrift{"url", existingRepo.Url, declared.Url})
+	}
+	if slices.Compare(existingRepo.Repositories, declared.Repositories) != 0 {
+		fields = append(fields, FieldDrift{"repositories", existingRepo.Repositories, declared.Repositories})
+	}
+
+	return fields
+}
+
+func getPropertiesDrift(diff *propertiesDiff) *PropertiesDrift {
+	drift := &PropertiesDrift{
+		Live:     make(map[string][]string),
+		Declared: make(map[string][]string),
+		Unused:   diff.unused,
+	}
+	slices.Sort(drift.Unused)
+
+	for key, desiredVal := range diff.desired {
+		if currentVal, ok := diff.current[key]; !ok || !equalStringSlices(currentVal, desiredVal) {
+			drift.Declared[key] = desiredVal
+			if ok {
+				drift.Live[key] = currentVal
+			}
+		}
+	}
+
+	return drift
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCheckDrift(t *testing.T) {
+	reposToCheck := []Repo{
+		{Name: "repo1", Description: "declared", Read: []string{"user1"}},
+		{Name: "repo2"},
+		{Name: "repo3", Read: []string{"user1"}},
+		{Name: "invalid-repo", Read: []string{" user1"}},
+	}
+	allrepos := []ArtifactoryRepoDetailsResponse{
+		{Key: "repo1", Description: "changed in ui", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "repo3", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "manual-repo", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "invalid-repo", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+	}
+	allusers := []ArtifactoryUser{{Username: "user1"}, {Username: "user2"}}
+	allpermissiondetails := []ArtifactoryPermissionDetails{
+		{Name: "repo1", Resources: ArtifactoryPermissionDetailsResources{Artifact: ArtifactoryPermissionDetailsArtifact{
+			Actions: ArtifactoryPermissionDetailsActions{Users: map[string][]string{"user1": {"READ"}}},
+			Targets: map[string]ArtifactoryPermissionDetailsTarget{"repo1": {IncludePatterns: []string{"**"}}},
+		}}},
+		{Name: "repo3", Resources: ArtifactoryPermissionDetailsResources{Artifact: ArtifactoryPermissionDetailsArtifact{
+			Actions: ArtifactoryPermissionDetailsActions{Users: map[string][]string{"user1": {"READ"}, "user2": {"READ", "WRITE"}}},
+			Targets: map[string]ArtifactoryPermissionDetailsTarget{"repo3": {IncludePatterns: []string{"**"}}},
+		}}},
+		{Name: "manual-permission"},
+	}
+
+	var requests int
+	client := mockHTTPClient(func(req *http.Request) (*http.Response, error) {
+		requests++
+		if req.Method != "GET" {
+			t.Errorf("CheckDrift: unexpected write request: %s '%s'", req.Method, req.URL)
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"properties":{"p.url":["https://old"]}}`)), Header: make(http.Header)}, nil
+	})
+
+	ClearStats()
+	report, err := CheckDrift(client, "", "", reposToCheck, allrepos, allusers, allpermissiondetails, false, PropertiesConfig{SetProperties: true, Prefix: "p", Url: "https://new"})
+	if err != nil {
+		t.Fatalf("CheckDrift: error = %v", err)
+	}
+
+	if !report.HasDrift {
+		t.Errorf("CheckDrift: expected drift")
+	}
+	if len(report.Repos) != 3 {
+		t.Fatalf("CheckDrift: got %d drifted repos, want 3: %v", len(report.Repos), report.Repos)
+	}
+
+	repo1 := report.Repos[0]
+	if repo1.Name != "repo1" || len(repo1.Fields) != 1 || repo1.Fields[0].Field != "description" || repo1.Permission != nil {
+		t.Errorf("CheckDrift: repo1 = %+v", repo1)
+	}
+	if repo1.Properties == nil || repo1.Properties.Declared["p.url"][0] != "https://new" || repo1.Properties.Live["p.url"][0] != "https://old" {
+		t.Errorf("CheckDrift: repo1 properties = %+v", repo1.Properties)
+	}
+
+	repo2 := report.Repos[1]
+	if repo2.Name != "repo2" || !repo2.Missing || repo2.Permission == nil || !repo2.Permission.Missing {
+		t.Errorf("CheckDrift: repo2 = %+v", repo2)
+	}
+
+	repo3 := report.Repos[2]
+	if repo3.Name != "repo3" || repo3.Permission == nil || len(repo3.Permission.LiveUsers) != 2 || len(repo3.Permission.DeclaredUsers) != 1 {
+		t.Errorf("CheckDrift: repo3 = %+v", repo3)
+	}
+
+	if !slices.Equal(report.InvalidRepos, []string{"invalid-repo"}) {
+		t.Errorf("CheckDrift: invalid repos = %v", report.InvalidRepos)
+	}
+	if !slices.Equal(report.UncoveredRepos, []string{"manual-repo"}) {
+		t.Errorf("CheckDrift: uncovered repos = %v", report.UncoveredRepos)
+	}
+	if !slices.Equal(report.UncoveredPermissions, []string{"manual-permission"}) {
+		t.Errorf("CheckDrift: uncovered permission targets = %v", report.UncoveredPermissions)
+	}
+	if requests != 2 {
+		t.Errorf("CheckDrift: got %d property requests, want 2", requests)
+	}
+}
+
+func TestCheckDriftNone(t *testing.T) {
+	reposToCheck := []Repo{{Name: "repo1"}}
+	allrepos := []ArtifactoryRepoDetailsResponse{{Key: "repo1", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"}}
+	allpermissiondetails := []ArtifactoryPermissionDetails{
+		{Name: "repo1", Resources: ArtifactoryPermissionDetailsResources{Artifact: ArtifactoryPermissionDetailsArtifact{
+			Targets: map[string]ArtifactoryPermissionDetailsTarget{"repo1": {IncludePatterns: []string{"**"}}},
+		}}},
+	}
+
+	report, err := CheckDrift(nil, "", "", reposToCheck, allrepos, nil, allpermissiondetails, false, PropertiesConfig{})
+	if err != nil {
+		t.Fatalf("CheckDriftNone: error = %v", err)
+	}
+	if report.HasDrift {
+		t.Errorf("CheckDriftNone: unexpected drift: %+v", report)
+	}
+}
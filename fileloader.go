@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
 	"slices"
@@ -14,11 +16,26 @@ import (
 	"github.com/goccy/go-yaml/ast"
 )
 
+// File name patterns of repo files, when loading directories.
+var repoFileIncludes = []string{"*.yaml", "*.yml", "*.json"}
+
 func LoadRepoFiles(repofiles []string, provisionEmpty bool) []Repo {
 	var allrepos []Repo
 
+	repofiles, err := expandRepoDirs(repofiles)
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+
+	defaults := newRepoDefaults()
+
 	for _, repofile := range repofiles {
-		repos, err := loadRepoFile(repofile, provisionEmpty)
+		if isDefaultsFile(repofile) {
+			log.Printf("'%s': Skipping defaults file, it's not a repo file.\n", repofile)
+			continue
+		}
+
+		repos, err := loadRepoFile(repofile, provisionEmpty, defaults)
 		if err != nil {
 			fmt.Printf("'%s': Warning: Ignoring invalid repo file: %v\n", repofile, err)
 			stats.IgnoredInvalidRepoFilesCount++
@@ -33,12 +50,63 @@ func LoadRepoFiles(repofiles []string, provisionEmpty bool) []Repo {
 	return allrepos
 }
 
-func loadRepoFile(repofile string, provisionEmpty bool) ([]Repo, error) {
+// Replaces directories with the repo files found recursively in them, in lexical order.
+// Hidden files and folders are skipped.
+func expandRepoDirs(repofiles []string) ([]string, error) {
+	var expanded []string
+	var errs []error
+
+	for _, repofile := range repofiles {
+		info, err := os.Stat(repofile)
+		if err != nil || !info.IsDir() {
+			expanded = append(expanded, repofile)
+			continue
+		}
+
+		err = filepath.WalkDir(repofile, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path != repofile && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.IsDir() && matchesAnyGlob(repoFileIncludes, d.Name()) && !isDefaultsFile(path) {
+				expanded = append(expanded, path)
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error reading folder '%s': %w", repofile, err))
+		}
+	}
+
+	return expanded, errors.Join(errs...)
+}
+
+// Loads the repos of a file, with the defaults of its folders and its defaults document.
+func loadRepoFile(repofile string, provisionEmpty bool, defaults *repoDefaults) ([]Repo, error) {
 	data, err := os.ReadFile(repofile)
 	if err != nil {
 		return nil, fmt.Errorf("error reading file: %w", err)
 	}
 
+	repoDefaults, err := defaults.forFolder(filepath.Dir(repofile))
+	if err != nil {
+		return nil, err
+	}
+
+	fileDefaults, data, err := splitDefaultsDocument(data, repofile)
+	if err != nil {
+		return nil, err
+	}
+	if fileDefaults != nil {
+		applyDefaults(fileDefaults, repoDefaults)
+		repoDefaults = fileDefaults
+	}
+
 	var repos []Repo
 	var errjson, erryaml error
 
@@ -50,6 +118,10 @@ func loadRepoFile(repofile string, provisionEmpty bool) ([]Repo, error) {
 		}
 	}
 
+	for i := range repos {
+		ExpandRoles(&repos[i], repoRoles)
+		applyDefaults(&repos[i], repoDefaults)
+	}
 	repos = expandRepos(repos)
 
 	if len(repos) == 0 {
@@ -59,17 +131,22 @@ func loadRepoFile(repofile string, provisionEmpty bool) ([]Repo, error) {
 	return repos, nil
 }
 
-func extractExtraFields(rawData map[string]any) map[string]any {
-	knownFields := map[string]bool{
-		"name": true, "names": true, "description": true, "rclass": true,
-		"packageType": true, "layout": true, "url": true, "permissionName": true,
-		"read": true, "annotate": true, "write": true, "delete": true,
-		"manage": true, "scan": true, "repositories": true,
-	}
+var knownRepoFields = map[string]bool{
+	"name": true, "names": true, "matrix": true, "description": true, "rclass": true,
+	"packageType": true, "layout": true, "url": true, "permissionName": true,
+	"read": true, "annotate": true, "write": true, "delete": true,
+	"manage": true, "scan": true, "repositories": true,
+	"override": true, "inherit": true,
+}
 
+func isKnownRepoField(name string) bool {
+	return knownRepoFields[name]
+}
+
+func extractExtraFields(rawData map[string]any) map[string]any {
 	extraFields := make(map[string]any)
 	for k, v := range rawData {
-		if !knownFields[k] {
+		if !isKnownRepoField(k) {
 			extraFields[k] = v
 		}
 	}
@@ -208,16 +285,10 @@ func tryParseYamlRepos(data []byte, repofile string, provisionEmpty bool) ([]Rep
 		line   int
 	}
 	positions := []position{}
-	if node != nil {
-		t := node.GetToken()
-		for {
-			if t.Value == "-" && t.Position.IndentLevel == 0 {
-				positions = append(positions, position{offset: t.Position.Offset, line: t.Position.Line})
-			}
-			t = t.Next
-			if t == nil {
-				break
-			}
+	if seq, ok := node.(*ast.SequenceNode); ok {
+		for _, entry := range seq.Entries {
+			t := entry.Start
+			positions = append(positions, position{offset: t.Position.Offset, line: t.Position.Line})
 		}
 	}
 	if len(positions) != len(repos) {
@@ -244,6 +315,8 @@ func tryParseYamlRepos(data []byte, repofile string, provisionEmpty bool) ([]Rep
 func expandRepos(repos []Repo) []Repo {
 	var expandedRepos []Repo
 
+	repos = expandMatrixRepos(repos)
+
 	for i := range repos {
 		if repos[i].Name == "" && len(repos[i].Names) == 0 {
 			b := filepath.Base(repos[i].SourceFile)
@@ -273,9 +346,10 @@ func expandRepos(repos []Repo) []Repo {
 
 func removeDups(repos []Repo) []Repo {
 	type jsonobject struct {
-		Index      int
-		SourceFile string
-		SourceLine int
+		Index         int
+		SourceFile    string
+		SourceLine    int
+		SourceVariant string
 	}
 
 	reposToDelete := make(map[string][]jsonobject)
@@ -286,7 +360,7 @@ func removeDups(repos []Repo) []Repo {
 			if name == repos[j].Name {
 				indices, ok := reposToDelete[name]
 				if !ok {
-					jo := jsonobject{Index: i, SourceFile: repos[i].SourceFile, SourceLine: repos[i].SourceLine}
+					jo := jsonobject{Index: i, SourceFile: repos[i].SourceFile, SourceLine: repos[i].SourceLine, SourceVariant: repos[i].SourceVariant}
 					reposToDelete[name] = []jsonobject{jo}
 				}
 				found := false
@@ -297,7 +371,7 @@ func removeDups(repos []Repo) []Repo {
 					}
 				}
 				if !found {
-					jo := jsonobject{Index: j, SourceFile: repos[j].SourceFile, SourceLine: repos[j].SourceLine}
+					jo := jsonobject{Index: j, SourceFile: repos[j].SourceFile, SourceLine: repos[j].SourceLine, SourceVariant: repos[j].SourceVariant}
 					reposToDelete[name] = append(reposToDelete[name], jo)
 				}
 			}
@@ -314,6 +388,9 @@ func removeDups(repos []Repo) []Repo {
 		positions := make([]string, len(reposToDelete[key]))
 		for i, jo := range reposToDelete[key] {
 			positions[i] = fmt.Sprintf("%s:%d", jo.SourceFile, jo.SourceLine)
+			if jo.SourceVariant != "" {
+				positions[i] += fmt.Sprintf(" (%s)", jo.SourceVariant)
+			}
 		}
 		fmt.Printf("Warning: Ignoring %d repos due to duplicate name. Name: '%s', objects (file:line): %s\n", len(reposToDelete[key]), key, strings.Join(positions, ", "))
 	}
@@ -310,3 +310,29 @@ func TestLoadRepoFile_YAMLArrayWithExtraFields(t *testing.T) {
 		t.Fatalf("repo2: extra field 'enabled' not captured correctly: %v", repos[1].ExtraFields["enabled"])
 	}
 }
+
+func TestLoadRepoFiles_Folder(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"docker/local/b-repo.yaml":  "description: b\n",
+		"docker/remote/a-repo.json": `{"description": "a"}`,
+		"generic/team.yml":          "- name: c-repo\n- name: d-repo\n",
+		"generic/notes.txt":         "not a repo file",
+		".hidden/e-repo.yaml":       "description: e\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
+	}
+
+	repos := LoadRepoFiles([]string{dir}, false)
+
+	var names []string
+	for _, repo := range repos {
+		names = append(names, repo.Name)
+	}
+	if got, want := strings.Join(names, ","), "b-repo,a-repo,c-repo,d-repo"; got != want {
+		t.Errorf("LoadRepoFiles folder: got repos %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var permissionActions = []string{"READ", "ANNOTATE", "WRITE", "DELETE", "MANAGE", "SCAN"}
+
+// Limits which repos are generated. Empty fields don't filter.
+type GenerateFilter struct {
+	Include      []string
+	Exclude      []string
+	IncludeRegex *regexp.Regexp
+	PackageTypes []string
+	Rclasses     []string
+	Principal    *PrincipalFilter
+}
+
+// Matches repos whose permission targets give a user or group a permission.
+type PrincipalFilter struct {
+	Kind       string // "user", "group" or empty for both.
+	Name       string
+	Permission string // Empty for any permission.
+}
+
+// Parses a principal filter like "group:team-x:MANAGE", "user:alice" or "team-x".
+func ParsePrincipalFilter(filter string) (*PrincipalFilter, error) {
+	parts := strings.Split(filter, ":")
+
+	var principalFilter PrincipalFilter
+	if parts[0] == "user" || parts[0] == "group" {
+		principalFilter.Kind = parts[0]
+		parts = parts[1:]
+	}
+	if len(parts) == 0 || len(parts) > 2 || parts[0] == "" {
+		return nil, fmt.Errorf("invalid principal filter '%s', expected [user:|group:]name[:permission]", filter)
+	}
+
+	principalFilter.Name = parts[0]
+	if len(parts) == 2 {
+		principalFilter.Permission = strings.ToUpper(parts[1])
+		if !slices.Contains(permissionActions, principalFilter.Permission) {
+			return nil, fmt.Errorf("invalid permission '%s' in principal filter, expected one of: %s", parts[1], strings.Join(permissionActions, ", "))
+		}
+	}
+
+	return &principalFilter, nil
+}
+
+// Checks that the glob patterns are valid.
+func validateGlobPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func (f GenerateFilter) IsEmpty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0 && f.IncludeRegex == nil &&
+		len(f.PackageTypes) == 0 && len(f.Rclasses) == 0 && f.Principal == nil
+}
+
+func (f GenerateFilter) MatchesRepo(repo ArtifactoryRepoDetailsResponse) bool {
+	if len(f.Include) > 0 && !matchesAnyGlob(f.Include, repo.Key) {
+		return false
+	}
+	if f.IncludeRegex != nil && !f.IncludeRegex.MatchString(repo.Key) {
+		return false
+	}
+	if matchesAnyGlob(f.Exclude, repo.Key) {
+		return false
+	}
+	if len(f.PackageTypes) > 0 && !slices.ContainsFunc(f.PackageTypes, func(p string) bool { return strings.EqualFold(p, repo.PackageType) }) {
+		return false
+	}
+	if len(f.Rclasses) > 0 && !slices.ContainsFunc(f.Rclasses, func(r string) bool { return strings.EqualFold(r, repo.Rclass) }) {
+		return false
+	}
+	return true
+}
+
+func (f GenerateFilter) MatchesPermissions(permissions []ArtifactoryPermissionDetails) bool {
+	if f.Principal == nil {
+		return true
+	}
+
+	for _, permission := range permissions {
+		actions := permission.Resources.Artifact.Actions
+		if f.Principal.Kind != "group" && f.Principal.matches(actions.Users) {
+			return true
+		}
+		if f.Principal.Kind != "user" && f.Principal.matches(actions.Groups) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p PrincipalFilter) matches(actions map[string][]string) bool {
+	permissions, ok := actions[p.Name]
+	if !ok {
+		return false
+	}
+	return p.Permission == "" || slices.Contains(permissions, p.Permission)
+}
+
+func matchesAnyGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestGenerateFilterMatchesRepo(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Filter GenerateFilter
+		Repo   ArtifactoryRepoDetailsResponse
+		Want   bool
+	}{
+		{"No filter", GenerateFilter{}, ArtifactoryRepoDetailsResponse{Key: "any"}, true},
+		{"Include match", GenerateFilter{Include: []string{"team-x-*"}}, ArtifactoryRepoDetailsResponse{Key: "team-x-docker"}, true},
+		{"Include no match", GenerateFilter{Include: []string{"team-x-*"}}, ArtifactoryRepoDetailsResponse{Key: "team-y-docker"}, false},
+		{"Exclude", GenerateFilter{Include: []string{"team-x-*"}, Exclude: []string{"*-tmp"}}, ArtifactoryRepoDetailsResponse{Key: "team-x-tmp"}, false},
+		{"Regex", GenerateFilter{IncludeRegex: regexp.MustCompile(`^team-(x|y)-`)}, ArtifactoryRepoDetailsResponse{Key: "team-y-maven"}, true},
+		{"Regex no match", GenerateFilter{IncludeRegex: regexp.MustCompile(`^team-(x|y)-`)}, ArtifactoryRepoDetailsResponse{Key: "team-z-maven"}, false},
+		{"Package type", GenerateFilter{PackageTypes: []string{"docker"}}, ArtifactoryRepoDetailsResponse{Key: "a", PackageType: "Docker"}, true},
+		{"Package type no match", GenerateFilter{PackageTypes: []string{"docker"}}, ArtifactoryRepoDetailsResponse{Key: "a", PackageType: "maven"}, false},
+		{"Rclass", GenerateFilter{Rclasses: []string{"remote", "virtual"}}, ArtifactoryRepoDetailsResponse{Key: "a", Rclass: "virtual"}, true},
+		{"Rclass no match", GenerateFilter{Rclasses: []string{"remote", "virtual"}}, ArtifactoryRepoDetailsResponse{Key: "a", Rclass: "local"}, false},
+	}
+
+	for i, test := range tests {
+		if got := test.Filter.MatchesRepo(test.Repo); got != test.Want {
+			t.Errorf("%s (%d/%d): got %v, want %v", test.Name, i+1, len(tests), got, test.Want)
+		}
+	}
+}
+
+func TestParsePrincipalFilter(t *testing.T) {
+	tests := []struct {
+		Filter  string
+		Want    PrincipalFilter
+		WantErr bool
+	}{
+		{"group:team-x:MANAGE", PrincipalFilter{Kind: "group", Name: "team-x", Permission: "MANAGE"}, false},
+		{"user:alice", PrincipalFilter{Kind: "user", Name: "alice"}, false},
+		{"team-x:write", PrincipalFilter{Name: "team-x", Permission: "WRITE"}, false},
+		{"team-x", PrincipalFilter{Name: "team-x"}, false},
+		{"group:team-x:ADMIN", PrincipalFilter{}, true},
+		{"group:", PrincipalFilter{}, true},
+		{"a:b:c", PrincipalFilter{}, true},
+	}
+
+	for i, test := range tests {
+		got, err := ParsePrincipalFilter(test.Filter)
+		if test.WantErr {
+			if err == nil {
+				t.Errorf("ParsePrincipalFilter (%d/%d): expected error for '%s'", i+1, len(tests), test.Filter)
+			}
+			continue
+		}
+		if err != nil || *got != test.Want {
+			t.Errorf("ParsePrincipalFilter (%d/%d): got %+v, %v, want %+v", i+1, len(tests), got, err, test.Want)
+		}
+	}
+}
+
+func TestGenerateWithPrincipalFilter(t *testing.T) {
+	repos := []ArtifactoryRepoDetailsResponse{
+		{Key: "team-x-managed", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "team-x-read", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "other", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+	}
+	permissiondetails := []ArtifactoryPermissionDetails{
+		{Name: "team-x-managed", Resources: ArtifactoryPermissionDetailsResources{Artifact: ArtifactoryPermissionDetailsArtifact{
+			Actions: ArtifactoryPermissionDetailsActions{Groups: map[string][]string{"team-x": {"READ", "MANAGE"}}},
+		}}},
+		{Name: "team-x-read", Resources: ArtifactoryPermissionDetailsResources{Artifact: ArtifactoryPermissionDetailsArtifact{
+			Actions: ArtifactoryPermissionDetailsActions{Groups: map[string][]string{"team-x": {"READ"}}},
+		}}},
+		{Name: "other", Resources: ArtifactoryPermissionDetailsResources{Artifact: ArtifactoryPermissionDetailsArtifact{
+			Actions: ArtifactoryPermissionDetailsActions{Users: map[string][]string{"team-x": {"MANAGE"}}},
+		}}},
+	}
+
+	filename := filepath.Join(t.TempDir(), "repos.yaml")
+	filter := GenerateFilter{Principal: &PrincipalFilter{Kind: "group", Name: "team-x", Permission: "MANAGE"}}
+	err := Generate(nil, "", "", repos, permissiondetails, filename, GenerateConfig{Filter: filter})
+	if err != nil {
+		t.Fatalf("GenerateWithPrincipalFilter: error = %v", err)
+	}
+
+	want := `- name: team-x-managed
+  read:
+  - team-x
+  manage:
+  - team-x
+`
+	data, _ := os.ReadFile(filename)
+	if string(data) != want {
+		t.Errorf("GenerateWithPrincipalFilter: output mismatch:\nGot:\n%s\nWant:\n%s", string(data), want)
+	}
+}
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"net/http"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"slices"
 	"sort"
 	"strings"
@@ -11,31 +18,65 @@ import (
 	"github.com/goccy/go-yaml"
 )
 
+const defaultSplitTemplate = "{name}"
+
+var splitTemplatePlaceholder = regexp.MustCompile(`\{[a-zA-Z0-9_.-]+\}`)
+
+type GenerateConfig struct {
+	UseAllPermissionTargetsAsSource bool
+	OnlyMatchingRepos               bool
+	OnlyCleanRepos                  bool
+	AllowRenamedPermissions         bool
+	Combine                         bool
+	Split                           bool
+	Json                            bool
+	Terraform                       bool
+	Merge                           bool
+	Filter                          GenerateFilter
+	SplitTemplate                   string
+
+	// Repo properties with the configured prefix are generated as extra fields.
+	Properties  PropertiesConfig
+	Concurrency int
+
+	// Report redundant user permissions and role matches, and remove the redundant ones.
+	Analyze bool
+	Compact bool
+
+	// Principals whose permissions match a role are generated as the role.
+	EmitRoles bool
+}
+
 func Generate(
+	client *http.Client,
+	baseurl string,
+	token string,
 	repos []ArtifactoryRepoDetailsResponse,
 	permissiondetails []ArtifactoryPermissionDetails,
-	useAllPermissionTargetsAsSource bool,
-	onlyGenerateMatchingRepos bool,
-	onlyGenerateCleanRepos bool,
-	allowRenamedPermissions bool,
-	combineRepos bool,
-	split bool,
 	repofile string,
-	generatejson bool) error {
+	config GenerateConfig) error {
 
 	var reposToSave []Repo
 
+	// Terraform output is built from the Artifactory data of the included repos.
+	var terraformRepos []ArtifactoryRepoDetailsResponse
+	var terraformPermissions []ArtifactoryPermissionDetails
+
 	sort.Slice(repos, func(i, j int) bool {
 		return repos[i].Key < repos[j].Key
 	})
 
 	for _, repo := range repos {
-		if onlyGenerateMatchingRepos && !includeOnlyMatchingRepos(repo.Key, permissiondetails) {
+		if !config.Filter.MatchesRepo(repo) {
+			continue
+		}
+
+		if config.OnlyMatchingRepos && !includeOnlyMatchingRepos(repo.Key, permissiondetails) {
 			continue
 		}
 
 		var permissionName string
-		if allowRenamedPermissions {
+		if config.AllowRenamedPermissions {
 			var filter bool
 			permissionName, filter = includeOnlyMatchingAndRenamedRepos(repo.Key, permissiondetails)
 			if !filter {
@@ -43,7 +84,7 @@ func Generate(
 			}
 		}
 
-		if onlyGenerateCleanRepos && !includeOnlyCleanRepos(repo.Key, permissiondetails, useAllPermissionTargetsAsSource) {
+		if config.OnlyCleanRepos && !includeOnlyCleanRepos(repo.Key, permissiondetails, config.UseAllPermissionTargetsAsSource) {
 			continue
 		}
 
@@ -58,50 +99,27 @@ func Generate(
 			Repositories:   repo.Repositories,
 		}
 
-		if allowRenamedPermissions {
-			if permissionName == "" {
-				for _, permission := range permissiondetails {
-					if permission.Name == repo.Key || (repo.Rclass == "remote" && permission.Name == repo.Key+"-cache") {
-						addPermissionsToRepo(&repoToSave, permission.Resources.Artifact.Actions.Users)
-						addPermissionsToRepo(&repoToSave, permission.Resources.Artifact.Actions.Groups)
-					}
-				}
-			} else {
-				for _, permission := range permissiondetails {
-					if permission.Name == permissionName || (repo.Rclass == "remote" && permission.Name == repo.Key+"-cache") {
-						addPermissionsToRepo(&repoToSave, permission.Resources.Artifact.Actions.Users)
-						addPermissionsToRepo(&repoToSave, permission.Resources.Artifact.Actions.Groups)
-					}
-				}
-			}
-		} else if useAllPermissionTargetsAsSource {
-			for _, permission := range permissiondetails {
-				for reponame := range permission.Resources.Artifact.Targets {
-					if reponame == repo.Key || (repo.Rclass == "remote" && reponame == repo.Key+"-cache") {
-						addPermissionsToRepo(&repoToSave, permission.Resources.Artifact.Actions.Users)
-						addPermissionsToRepo(&repoToSave, permission.Resources.Artifact.Actions.Groups)
-					}
-				}
-			}
-		} else {
-			for _, permission := range permissiondetails {
-				if permission.Name == repo.Key || (repo.Rclass == "remote" && permission.Name == repo.Key+"-cache") {
-					addPermissionsToRepo(&repoToSave, permission.Resources.Artifact.Actions.Users)
-					addPermissionsToRepo(&repoToSave, permission.Resources.Artifact.Actions.Groups)
-				}
-			}
+		sourcePermissions := getSourcePermissions(repo, permissionName, permissiondetails, config)
+		if !config.Filter.MatchesPermissions(sourcePermissions) {
+			continue
 		}
 
-		if strings.EqualFold(repo.Rclass, "local") {
-			repoToSave.Rclass = ""
-		}
-		if strings.EqualFold(repo.PackageType, "generic") {
-			repoToSave.PackageType = ""
+		for _, permission := range sourcePermissions {
+			addPermissionsToRepo(&repoToSave, permission.Resources.Artifact.Actions.Users)
+			addPermissionsToRepo(&repoToSave, permission.Resources.Artifact.Actions.Groups)
+
+			if config.Terraform && !slices.ContainsFunc(terraformPermissions, func(p ArtifactoryPermissionDetails) bool { return p.Name == permission.Name }) {
+				terraformPermissions = append(terraformPermissions, permission)
+			}
 		}
-		if strings.EqualFold(repo.RepoLayoutRef, "simple-default") {
-			repoToSave.Layout = ""
+
+		if config.Terraform {
+			terraformRepos = append(terraformRepos, repo)
+			continue
 		}
 
+		removeRepoDefaults(&repoToSave)
+
 		slices.Sort(repoToSave.Read)
 		slices.Sort(repoToSave.Annotate)
 		slices.Sort(repoToSave.Write)
@@ -109,39 +127,171 @@ func Generate(
 		slices.Sort(repoToSave.Manage)
 		slices.Sort(repoToSave.Scan)
 
-		if combineRepos {
-			found := false
-			for i := range reposToSave {
-				if reposToSave[i].PackageType == repoToSave.PackageType &&
-					reposToSave[i].Description == repoToSave.Description &&
-					reposToSave[i].Rclass == repoToSave.Rclass &&
-					reposToSave[i].Layout == repoToSave.Layout &&
-					reposToSave[i].PermissionName == repoToSave.PermissionName &&
-					equalStringSlices(reposToSave[i].Read, repoToSave.Read) &&
-					equalStringSlices(reposToSave[i].Annotate, repoToSave.Annotate) &&
-					equalStringSlices(reposToSave[i].Write, repoToSave.Write) &&
-					equalStringSlices(reposToSave[i].Delete, repoToSave.Delete) &&
-					equalStringSlices(reposToSave[i].Manage, repoToSave.Manage) &&
-					equalStringSlices(reposToSave[i].Scan, repoToSave.Scan) {
-					found = true
-					if reposToSave[i].Name != "" {
-						reposToSave[i].Names = append(reposToSave[i].Names, reposToSave[i].Name, repoToSave.Name)
-						reposToSave[i].Name = ""
-					} else {
-						reposToSave[i].Names = append(reposToSave[i].Names, repoToSave.Name)
-					}
-					fmt.Printf("'%s': Identical repo already generated (%s), compacting duplicate.\n", repo.Key, reposToSave[i].Names[0])
-					break
-				}
+		reposToSave = append(reposToSave, repoToSave)
+	}
+
+	if config.Terraform {
+		return saveTerraform(terraformRepos, terraformPermissions, repofile)
+	}
+
+	if config.Analyze || config.Compact {
+		// Groups are known from the permission targets, their members from the access api.
+		var permissionGroups []string
+		for _, permission := range permissiondetails {
+			permissionGroups = slices.AppendSeq(permissionGroups, maps.Keys(permission.Resources.Artifact.Actions.Groups))
+		}
+		groupnames := getRepoGroupNames(reposToSave, func(name string) bool { return slices.Contains(permissionGroups, name) })
+
+		groupMembers, err := getGroupMembers(client, baseurl, token, groupnames, config.Concurrency)
+		if err != nil {
+			return err
+		}
+
+		analysis := AnalyzeRepos(reposToSave, groupMembers, repoRoles)
+		analysis.Print()
+		if config.Compact {
+			reposToSave = CompactRepos(reposToSave, analysis)
+		}
+	}
+
+	if config.EmitRoles {
+		for i := range reposToSave {
+			emitRoles(&reposToSave[i], repoRoles)
+		}
+	}
+
+	if config.Properties.SetProperties {
+		err := addPropertiesAsExtraFields(client, baseurl, token, reposToSave, config.Properties, config.Concurrency)
+		if err != nil {
+			return err
+		}
+	}
+
+	// When merging, only the new repos are combined, after comparing each repo with the file.
+	// When splitting, repos are combined within each file.
+	if config.Combine && !config.Merge && !config.Split {
+		reposToSave = combineIdenticalRepos(reposToSave)
+	}
+
+	sortRepos(reposToSave)
+
+	if config.Merge {
+		return mergeRepoFile(reposToSave, repos, repofile, config)
+	}
+	if config.Split {
+		return saveSplitRepos(reposToSave, repofile, config)
+	}
+	return saveCombinedRepos(reposToSave, repofile, config.Json)
+}
+
+// Reads back the properties written by provisioning, so that generating and provisioning
+// again doesn't delete them as unused.
+func addPropertiesAsExtraFields(
+	client *http.Client,
+	baseurl string,
+	token string,
+	reposToSave []Repo,
+	propertiesConfig PropertiesConfig,
+	concurrency int) error {
+
+	prefix := propertiesConfig.Prefix + "."
+	urlProperty := prefix + "url"
+
+	return runConcurrently(concurrency, len(reposToSave), func(i int) error {
+		repo := &reposToSave[i]
+		if repo.Rclass == "virtual" {
+			return nil
+		}
+
+		properties, err := getRepoProperties(client, baseurl, token, getPropertiesRepoName(*repo))
+		if err != nil {
+			return fmt.Errorf("'%s': error getting properties: %w", repo.Name, err)
+		}
+
+		for _, key := range slices.Sorted(maps.Keys(properties)) {
+			if !strings.HasPrefix(key, prefix) || (key == urlProperty && propertiesConfig.Url != "") {
+				continue
 			}
-			if found {
+
+			field := strings.TrimPrefix(key, prefix)
+			if isKnownRepoField(field) || isRoleField(field) {
+				fmt.Printf("'%s': Warning: Ignoring property '%s', it's named as a repo field.\n", repo.Name, key)
 				continue
 			}
+
+			if repo.ExtraFields == nil {
+				repo.ExtraFields = make(map[string]any)
+			}
+			values := properties[key]
+			if len(values) == 1 {
+				repo.ExtraFields[field] = values[0]
+			} else {
+				items := make([]any, len(values))
+				for j, value := range values {
+					items[j] = value
+				}
+				repo.ExtraFields[field] = items
+			}
+		}
+
+		return nil
+	})
+}
+
+// Generated repos leave out the values that are default when provisioning.
+func removeRepoDefaults(repo *Repo) {
+	if strings.EqualFold(repo.Rclass, "local") {
+		repo.Rclass = ""
+	}
+	if strings.EqualFold(repo.PackageType, "generic") {
+		repo.PackageType = ""
+	}
+	if strings.EqualFold(repo.Layout, "simple-default") {
+		repo.Layout = ""
+	}
+}
+
+func combineIdenticalRepos(repos []Repo) []Repo {
+	var reposToSave []Repo
+
+	for _, repoToSave := range repos {
+		found := false
+		for i := range reposToSave {
+			if reposToSave[i].PackageType == repoToSave.PackageType &&
+				reposToSave[i].Description == repoToSave.Description &&
+				reposToSave[i].Rclass == repoToSave.Rclass &&
+				reposToSave[i].Layout == repoToSave.Layout &&
+				reposToSave[i].PermissionName == repoToSave.PermissionName &&
+				equalStringSlices(reposToSave[i].Read, repoToSave.Read) &&
+				equalStringSlices(reposToSave[i].Annotate, repoToSave.Annotate) &&
+				equalStringSlices(reposToSave[i].Write, repoToSave.Write) &&
+				equalStringSlices(reposToSave[i].Delete, repoToSave.Delete) &&
+				equalStringSlices(reposToSave[i].Manage, repoToSave.Manage) &&
+				equalStringSlices(reposToSave[i].Scan, repoToSave.Scan) &&
+				reflect.DeepEqual(reposToSave[i].ExtraFields, repoToSave.ExtraFields) &&
+				reflect.DeepEqual(reposToSave[i].Roles, repoToSave.Roles) {
+				found = true
+				if reposToSave[i].Name != "" {
+					reposToSave[i].Names = append(reposToSave[i].Names, reposToSave[i].Name, repoToSave.Name)
+					reposToSave[i].Name = ""
+				} else {
+					reposToSave[i].Names = append(reposToSave[i].Names, repoToSave.Name)
+				}
+				fmt.Printf("'%s': Identical repo already generated (%s), compacting duplicate.\n", repoToSave.Name, reposToSave[i].Names[0])
+				break
+			}
+		}
+		if found {
+			continue
 		}
 
 		reposToSave = append(reposToSave, repoToSave)
 	}
 
+	return reposToSave
+}
+
+func sortRepos(reposToSave []Repo) {
 	sort.Slice(reposToSave, func(i, j int) bool {
 		if reposToSave[i].Name == "" && reposToSave[j].Name == "" {
 			return reposToSave[i].Names[0] < reposToSave[j].Names[0]
@@ -154,11 +304,33 @@ func Generate(
 		}
 		return reposToSave[i].Name < reposToSave[j].Name
 	})
+}
+
+// Returns the permission targets that the permissions of a repo are generated from.
+func getSourcePermissions(repo ArtifactoryRepoDetailsResponse, permissionName string, permissiondetails []ArtifactoryPermissionDetails, config GenerateConfig) []ArtifactoryPermissionDetails {
+	var permissions []ArtifactoryPermissionDetails
+
+	for _, permission := range permissiondetails {
+		var matches bool
+		switch {
+		case config.AllowRenamedPermissions && permissionName != "":
+			matches = permission.Name == permissionName || (repo.Rclass == "remote" && permission.Name == repo.Key+"-cache")
+		case !config.AllowRenamedPermissions && config.UseAllPermissionTargetsAsSource:
+			for reponame := range permission.Resources.Artifact.Targets {
+				if reponame == repo.Key || (repo.Rclass == "remote" && reponame == repo.Key+"-cache") {
+					matches = true
+				}
+			}
+		default:
+			matches = permission.Name == repo.Key || (repo.Rclass == "remote" && permission.Name == repo.Key+"-cache")
+		}
 
-	if split {
-		return saveSplitRepos(reposToSave, repofile, generatejson)
+		if matches {
+			permissions = append(permissions, permission)
+		}
 	}
-	return saveCombinedRepos(reposToSave, repofile, generatejson)
+
+	return permissions
 }
 
 func includeOnlyMatchingRepos(repokey string, permissiondetails []ArtifactoryPermissionDetails) bool {
@@ -279,7 +451,9 @@ func saveCombinedRepos(reposToSave []Repo, repofile string, generatejson bool) e
 	return nil
 }
 
-func saveSplitRepos(reposToSave []Repo, folder string, generatejson bool) error {
+// Saves the repos into files below folder, with paths from the split template. Repos that
+// end up in the same file are saved as a list, combined if enabled.
+func saveSplitRepos(reposToSave []Repo, folder string, config GenerateConfig) error {
 	if _, err := os.Stat(folder); os.IsNotExist(err) {
 		fmt.Printf("Creating folder: '%s'\n", folder)
 		err = os.Mkdir(folder, 0755)
@@ -288,23 +462,56 @@ func saveSplitRepos(reposToSave []Repo, folder string, generatejson bool) error
 		}
 	}
 
+	ext := ".yaml"
+	if config.Json {
+		ext = ".json"
+	}
+	template := config.SplitTemplate
+	if template == "" {
+		template = defaultSplitTemplate
+	}
+	if filepath.Ext(template) == "" {
+		template += ext
+	}
+
+	reposByFile := make(map[string][]Repo)
 	for _, repo := range reposToSave {
-		var filename, reponame string
+		filename := filepath.Join(folder, getSplitPath(repo, template))
+		reposByFile[filename] = append(reposByFile[filename], repo)
+	}
+
+	for _, filename := range slices.Sorted(maps.Keys(reposByFile)) {
+		repos := reposByFile[filename]
+		if config.Combine {
+			repos = combineIdenticalRepos(repos)
+			sortRepos(repos)
+		}
+
+		var names []string
+		for _, repo := range repos {
+			names = append(names, repo.Name)
+			names = append(names, repo.Names...)
+		}
+
+		// A single repo named as its file is saved without name, like the loader expects.
+		var content any = repos
+		if len(repos) == 1 {
+			repo := repos[0]
+			if repo.Name == strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)) {
+				repo.Name = ""
+			}
+			content = repo
+		}
+
 		var data []byte
 		var err error
-		if generatejson {
-			filename = fmt.Sprintf("%s/%s.json", folder, repo.Name)
-			reponame = repo.Name
-			repo.Name = ""
-			data, err = json.MarshalIndent(repo, "", "  ")
+		if config.Json {
+			data, err = json.MarshalIndent(content, "", "  ")
 			if err != nil {
 				return fmt.Errorf("error generating json: %w", err)
 			}
 		} else {
-			filename = fmt.Sprintf("%s/%s.yaml", folder, repo.Name)
-			reponame = repo.Name
-			repo.Name = ""
-			data, err = yaml.Marshal(repo)
+			data, err = yaml.Marshal(content)
 			if err != nil {
 				return fmt.Errorf("error generating yaml: %w", err)
 			}
@@ -313,14 +520,13 @@ func saveSplitRepos(reposToSave []Repo, folder string, generatejson bool) error
 			}
 		}
 
-		fmt.Printf("Saving repo '%s' to file '%s'\n", reponame, filename)
-		file, err := os.Create(filename)
+		err = os.MkdirAll(filepath.Dir(filename), 0755)
 		if err != nil {
-			return fmt.Errorf("error creating file: %w", err)
+			return fmt.Errorf("error creating folder: '%s' %w", filepath.Dir(filename), err)
 		}
-		defer file.Close()
 
-		_, err = file.Write(data)
+		fmt.Printf("Saving repo '%s' to file '%s'\n", strings.Join(slices.DeleteFunc(names, func(n string) bool { return n == "" }), "', '"), filename)
+		err = os.WriteFile(filename, data, 0644)
 		if err != nil {
 			return fmt.Errorf("error saving file: %w", err)
 		}
@@ -328,6 +534,107 @@ func saveSplitRepos(reposToSave []Repo, folder string, generatejson bool) error
 	return nil
 }
 
+// Returns the path of a repo from a split template like '{packageType}/{rclass}/{name}.yaml'.
+// Placeholders are repo fields, 'prefix' for the name up to the first dash, or extra fields.
+func getSplitPath(repo Repo, template string) string {
+	return splitTemplatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		field := placeholder[1 : len(placeholder)-1]
+
+		var value string
+		switch field {
+		case "name":
+			value = repo.Name
+		case "prefix":
+			value, _, _ = strings.Cut(repo.Name, "-")
+		case "rclass":
+			value = cmp.Or(strings.ToLower(repo.Rclass), "local")
+		case "packageType":
+			value = cmp.Or(strings.ToLower(repo.PackageType), "generic")
+		case "layout":
+			value = cmp.Or(repo.Layout, "simple-default")
+		default:
+			if extra, ok := repo.ExtraFields[field]; ok && extra != nil {
+				value = fmt.Sprint(extra)
+			}
+		}
+
+		// Values must not add or leave folders.
+		value = strings.NewReplacer("/", "_", "\\", "_").Replace(value)
+		if value == "" || value == "." || value == ".." {
+			value = "none"
+		}
+		return value
+	})
+}
+
+// Checks that a split template is a relative path within the output folder.
+func ValidateSplitTemplate(template string) error {
+	if filepath.IsAbs(template) || slices.Contains(strings.Split(filepath.ToSlash(template), "/"), "..") {
+		return fmt.Errorf("split template must be a relative path inside the output folder: '%s'", template)
+	}
+	if !strings.Contains(template, "{") {
+		return fmt.Errorf("split template has no placeholders: '%s'", template)
+	}
+	return nil
+}
+
+// Writes the known fields in declaration order, followed by the extra fields sorted by name.
+func (r Repo) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, item := range getRepoFields(r) {
+		key, err := json.Marshal(item.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.Write(key)
+		buf.WriteString(":")
+		buf.Write(value)
+	}
+	buf.WriteString("}")
+
+	return buf.Bytes(), nil
+}
+
+func (r Repo) MarshalYAML() (any, error) {
+	return getRepoFields(r), nil
+}
+
+func getRepoFields(r Repo) yaml.MapSlice {
+	fields := yaml.MapSlice{}
+
+	value := reflect.ValueOf(r)
+	for i := range value.NumField() {
+		name, options, _ := strings.Cut(value.Type().Field(i).Tag.Get("json"), ",")
+		if name == "-" || name == "" {
+			continue
+		}
+		if options == "omitempty" && value.Field(i).IsZero() {
+			continue
+		}
+		fields = append(fields, yaml.MapItem{Key: name, Value: value.Field(i).Interface()})
+	}
+
+	for _, role := range repoRoles {
+		if principals := r.Roles[role.Name]; len(principals) > 0 {
+			fields = append(fields, yaml.MapItem{Key: role.FieldName(), Value: principals})
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(r.ExtraFields)) {
+		fields = append(fields, yaml.MapItem{Key: key, Value: r.ExtraFields[key]})
+	}
+
+	return fields
+}
+
 func equalStringSlices(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
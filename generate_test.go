@@ -1,8 +1,14 @@
 package main
 
 import (
+	"encoding/json"
+	"io"
+	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -82,7 +88,7 @@ func TestGenerate(t *testing.T) {
 `},
 	}
 	for i, tc := range tests {
-		err := Generate(tc.repos, tc.permissiondetails, false, false, false, false, true, false, tc.filename, false)
+		err := Generate(nil, "", "", tc.repos, tc.permissiondetails, tc.filename, GenerateConfig{Combine: true})
 		if err != nil {
 			if !tc.wantErr {
 				t.Errorf("Generate (%d/%d): error = %v, wantErr %v",
@@ -150,7 +156,7 @@ func TestGenerateSplitEmpty(t *testing.T) {
 			``},
 	}
 	for i, tc := range tests {
-		err := Generate(tc.repos, tc.permissiondetails, false, false, false, false, true, true, tc.folder, tc.generatejson)
+		err := Generate(nil, "", "", tc.repos, tc.permissiondetails, tc.folder, GenerateConfig{Combine: true, Split: true, Json: tc.generatejson})
 		if err != nil {
 			if !tc.wantErr {
 				t.Errorf("Generate (%d/%d): error = %v, wantErr %v",
@@ -174,6 +180,76 @@ func TestGenerateSplitEmpty(t *testing.T) {
 	}
 }
 
+func TestGenerateSplitTemplate(t *testing.T) {
+	repos := []ArtifactoryRepoDetailsResponse{
+		{Key: "team1-docker", Rclass: "local", PackageType: "docker", RepoLayoutRef: "simple-default"},
+		{Key: "team1-generic", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "team1-docker-remote", Rclass: "remote", PackageType: "docker", RepoLayoutRef: "simple-default", Url: "https://registry-1.docker.io"},
+		{Key: "team2-generic", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+	}
+
+	tests := []struct {
+		Name     string
+		Template string
+		Want     map[string]string
+	}{
+		{"Type and class", "{packageType}/{rclass}/{name}", map[string]string{
+			"docker/local/team1-docker.yaml":         "packageType: docker\n",
+			"docker/remote/team1-docker-remote.yaml": "rclass: remote\npackageType: docker\nurl: https://registry-1.docker.io\n",
+			"generic/local/team1-generic.yaml":       "",
+			"generic/local/team2-generic.yaml":       "",
+		}},
+		{"Prefix", "{prefix}.yaml", map[string]string{
+			"team1.yaml": "- name: team1-docker\n  packageType: docker\n- name: team1-docker-remote\n  rclass: remote\n  packageType: docker\n  url: https://registry-1.docker.io\n- name: team1-generic\n",
+			"team2.yaml": "name: team2-generic\n",
+		}},
+	}
+
+	for i, test := range tests {
+		folder := t.TempDir()
+		err := Generate(nil, "", "", repos, nil, folder, GenerateConfig{Combine: true, Split: true, SplitTemplate: test.Template})
+		if err != nil {
+			t.Fatalf("%s (%d/%d): error = %v", test.Name, i+1, len(tests), err)
+		}
+
+		for name, want := range test.Want {
+			data, err := os.ReadFile(filepath.Join(folder, name))
+			if err != nil {
+				t.Errorf("%s (%d/%d): failed to read file %s: %v", test.Name, i+1, len(tests), name, err)
+				continue
+			}
+			if string(data) != want {
+				t.Errorf("%s (%d/%d): output mismatch in %s:\nGot:\n%s\nWant:\n%s", test.Name, i+1, len(tests), name, string(data), want)
+			}
+		}
+
+		// The split files load back as the same repos.
+		loaded := LoadRepoFiles([]string{folder}, true)
+		if len(loaded) != len(repos) {
+			t.Errorf("%s (%d/%d): loaded %d repos, want %d", test.Name, i+1, len(tests), len(loaded), len(repos))
+		}
+	}
+}
+
+func TestValidateSplitTemplate(t *testing.T) {
+	tests := []struct {
+		Template string
+		WantErr  bool
+	}{
+		{"{packageType}/{rclass}/{name}.yaml", false},
+		{"{prefix}", false},
+		{"/abs/{name}", true},
+		{"../{name}", true},
+		{"repos.yaml", true},
+	}
+
+	for i, test := range tests {
+		if err := ValidateSplitTemplate(test.Template); (err != nil) != test.WantErr {
+			t.Errorf("ValidateSplitTemplate (%d/%d): '%s' error = %v, wantErr %v", i+1, len(tests), test.Template, err, test.WantErr)
+		}
+	}
+}
+
 func TestGenerateRenamedPermissions(t *testing.T) {
 	repos := []ArtifactoryRepoDetailsResponse{
 		{
@@ -300,7 +376,7 @@ func TestGenerateRenamedPermissions(t *testing.T) {
 
 	filename := "/tmp/testrepos/test-repo-renamed.yaml"
 
-	err := Generate(repos, permissions, false, false, false, true, true, false, filename, false)
+	err := Generate(nil, "", "", repos, permissions, filename, GenerateConfig{AllowRenamedPermissions: true, Combine: true})
 	if err != nil {
 		t.Errorf("GenerateRenamedPermissions: error = %v", err)
 		return
@@ -471,7 +547,7 @@ func TestGenerateVirtualWithRepositories(t *testing.T) {
 `},
 	}
 	for i, tc := range tests {
-		err := Generate(tc.repos, tc.permissiondetails, false, false, false, false, true, false, tc.filename, false)
+		err := Generate(nil, "", "", tc.repos, tc.permissiondetails, tc.filename, GenerateConfig{Combine: true})
 		if err != nil {
 			if !tc.wantErr {
 				t.Errorf("Generate (%d/%d): error = %v, wantErr %v",
@@ -494,3 +570,76 @@ func TestGenerateVirtualWithRepositories(t *testing.T) {
 		}
 	}
 }
+
+func TestGeneratePropertiesAsExtraFields(t *testing.T) {
+	repos := []ArtifactoryRepoDetailsResponse{
+		{Key: "local1", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "local2", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "remote1", Rclass: "remote", PackageType: "generic", RepoLayoutRef: "simple-default", Url: "https://example.com"},
+		{Key: "virtual1", Rclass: "virtual", PackageType: "generic", RepoLayoutRef: "simple-default"},
+	}
+
+	var requested []string
+	var mutex sync.Mutex
+	client := mockHTTPClient(func(req *http.Request) (*http.Response, error) {
+		mutex.Lock()
+		requested = append(requested, req.URL.Path)
+		mutex.Unlock()
+
+		response := `{"properties":{}}`
+		switch req.URL.Path {
+		case "/artifactory/api/storage/local1":
+			response = `{"properties":{"p.url":["https://artsync"],"p.team":["team1"],"p.owners":["a","b"],"other.x":["y"],"p.name":["z"]}}`
+		case "/artifactory/api/storage/remote1-cache":
+			response = `{"properties":{"p.team":["team2"]}}`
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(response)), Header: make(http.Header)}, nil
+	})
+
+	filename := filepath.Join(t.TempDir(), "repos.yaml")
+	config := GenerateConfig{Combine: true, Properties: PropertiesConfig{SetProperties: true, Prefix: "p", Url: "https://artsync"}, Concurrency: 2}
+	err := Generate(client, "", "", repos, nil, filename, config)
+	if err != nil {
+		t.Fatalf("GeneratePropertiesAsExtraFields: error = %v", err)
+	}
+
+	want := `- name: local1
+  owners:
+  - a
+  - b
+  team: team1
+- name: local2
+- name: remote1
+  rclass: remote
+  url: https://example.com
+  team: team2
+- name: virtual1
+  rclass: virtual
+`
+	data, _ := os.ReadFile(filename)
+	if string(data) != want {
+		t.Errorf("GeneratePropertiesAsExtraFields: output mismatch:\nGot:\n%s\nWant:\n%s", string(data), want)
+	}
+	if slices.Contains(requested, "/artifactory/api/storage/virtual1") {
+		t.Errorf("GeneratePropertiesAsExtraFields: properties requested for virtual repo")
+	}
+
+	loaded := LoadRepoFiles([]string{filename}, false)
+	if !slices.Equal(getPropertyValues(loaded[0].ExtraFields["owners"]), []string{"a", "b"}) || !slices.Equal(getPropertyValues(loaded[0].ExtraFields["team"]), []string{"team1"}) {
+		t.Errorf("GeneratePropertiesAsExtraFields: properties don't round trip: %v", loaded[0].ExtraFields)
+	}
+}
+
+func TestRepoMarshalJSON(t *testing.T) {
+	repo := Repo{Name: "repo1", Read: []string{"user1"}, SourceLine: 3, ExtraFields: map[string]any{"team": "team1", "a": []any{"x"}}}
+
+	data, err := json.Marshal(repo)
+	if err != nil {
+		t.Fatalf("RepoMarshalJSON: error = %v", err)
+	}
+
+	want := `{"name":"repo1","read":["user1"],"a":["x"],"team":"team1"}`
+	if string(data) != want {
+		t.Errorf("RepoMarshalJSON: got '%s', want '%s'", data, want)
+	}
+}
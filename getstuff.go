@@ -2,23 +2,24 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
 )
 
-var pagenumRepos int
-var pagenumPermissions int
+// Returned when the cached details are still current, according to their ETag.
+var errNotModified = errors.New("not modified")
 
 func GetStuff(
 	client *http.Client,
 	baseurl string,
 	token string,
 	retrieveldapsettings bool,
-	usecache bool) (
+	cache *Cache,
+	concurrency int) (
 	[]ArtifactoryRepoDetailsResponse,
 	[]ArtifactoryUser,
 	[]ArtifactoryGroup,
@@ -27,51 +28,42 @@ func GetStuff(
 	[]ArtifactoryLDAPGroupSettings,
 	error) {
 
-	cachefolder := "cache"
-	if _, err := os.Stat(cachefolder); os.IsNotExist(err) {
-		fmt.Printf("Creating folder: '%s'\n", cachefolder)
-		err = os.Mkdir(cachefolder, 0755)
-		if err != nil {
-			return nil, nil, nil, nil, nil, nil, fmt.Errorf("error creating folder '%s': %w", cachefolder, err)
-		}
-	}
-
-	repos, err := getRepos(client, baseurl, token, cachefolder, usecache)
+	repos, err := getRepos(client, baseurl, token, cache)
 	if err != nil {
 		return nil, nil, nil, nil, nil, nil, err
 	}
 
 	fmt.Printf("Repo count: %d\n", len(repos))
 
-	repodetails, err := getRepoDetails(client, baseurl, token, repos, cachefolder, usecache)
+	repodetails, err := getRepoDetails(client, baseurl, token, repos, cache, concurrency)
 	if err != nil {
 		return nil, nil, nil, nil, nil, nil, err
 	}
 
 	fmt.Printf("Repo details count: %d\n", len(repodetails))
 
-	permissions, err := getPermissions(client, baseurl, token, cachefolder, usecache)
+	permissions, err := getPermissions(client, baseurl, token, cache)
 	if err != nil {
 		return nil, nil, nil, nil, nil, nil, err
 	}
 
 	fmt.Printf("Permissions count: %d\n", len(permissions))
 
-	users, err := getUsers(client, baseurl, token, cachefolder, usecache)
+	users, err := getUsers(client, baseurl, token, cache)
 	if err != nil {
 		return nil, nil, nil, nil, nil, nil, err
 	}
 
 	fmt.Printf("User count: %d\n", len(users))
 
-	groups, err := getGroups(client, baseurl, token, cachefolder, usecache)
+	groups, err := getGroups(client, baseurl, token, cache)
 	if err != nil {
 		return nil, nil, nil, nil, nil, nil, err
 	}
 
 	fmt.Printf("Group count: %d\n", len(groups))
 
-	permissiondetails, err := getPermissionDetails(client, baseurl, token, permissions, cachefolder, usecache)
+	permissiondetails, err := getPermissionDetails(client, baseurl, token, permissions, cache, concurrency)
 	if err != nil {
 		return nil, nil, nil, nil, nil, nil, err
 	}
@@ -81,14 +73,14 @@ func GetStuff(
 	var ldapsettings []ArtifactoryLDAPSettings
 	var ldapgroupsettings []ArtifactoryLDAPGroupSettings
 	if retrieveldapsettings {
-		ldapsettings, err = getLDAPSettings(client, baseurl, token, cachefolder, usecache)
+		ldapsettings, err = getLDAPSettings(client, baseurl, token, cache)
 		if err != nil {
 			return nil, nil, nil, nil, nil, nil, err
 		}
 
 		fmt.Printf("LDAP settings count: %d\n", len(ldapsettings))
 
-		ldapgroupsettings, err = getLDAPGroupSettings(client, baseurl, token, cachefolder, usecache)
+		ldapgroupsettings, err = getLDAPGroupSettings(client, baseurl, token, cache)
 		if err != nil {
 			return nil, nil, nil, nil, nil, nil, err
 		}
@@ -99,26 +91,24 @@ func GetStuff(
 	return repodetails, users, groups, permissiondetails, ldapsettings, ldapgroupsettings, nil
 }
 
-func getUsers(client *http.Client, baseurl string, token string, cachefolder string, usecache bool) ([]ArtifactoryUser, error) {
+func getUsers(client *http.Client, baseurl string, token string, cache *Cache) ([]ArtifactoryUser, error) {
 	var allusers []ArtifactoryUser
-	cachefilename := filepath.Join(cachefolder, "allusers.json")
+	cachefilename := cache.Path("allusers.json")
 
-	if usecache {
-		if _, err := os.Stat(cachefilename); err == nil {
-			fmt.Printf("Using cached users from file: '%s'\n", cachefilename)
-
-			data, err := os.ReadFile(cachefilename)
-			if err != nil {
-				return nil, fmt.Errorf("error reading users: %w", err)
-			}
+	if cache.IsValid("users", "allusers.json") {
+		fmt.Printf("Using cached users from file: '%s'\n", cachefilename)
 
-			err = json.Unmarshal(data, &allusers)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing json file: %w", err)
-			}
+		data, err := os.ReadFile(cachefilename)
+		if err != nil {
+			return nil, fmt.Errorf("error reading users: %w", err)
+		}
 
-			return allusers, nil
+		err = json.Unmarshal(data, &allusers)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing json file: %w", err)
 		}
+
+		return allusers, nil
 	}
 
 	var cursor string
@@ -140,7 +130,7 @@ func getUsers(client *http.Client, baseurl string, token string, cachefolder str
 				return nil, fmt.Errorf("error generating json: %w", err)
 			}
 
-			err = os.WriteFile(cachefilename, []byte(json), 0600)
+			err = cache.Save("users", "allusers.json", json)
 			if err != nil {
 				return nil, fmt.Errorf("error saving users: %w", err)
 			}
@@ -165,7 +155,7 @@ func getUsersPage(client *http.Client, baseurl string, token string, cursor stri
 
 	req.Header.Set("Authorization", "Bearer "+token)
 
-	resp, err := client.Do(req)
+	resp, err := doRequest(client, req)
 	if err != nil {
 		return nil, "", fmt.Errorf("error sending request: %w", err)
 	}
@@ -177,9 +167,7 @@ func getUsersPage(client *http.Client, baseurl string, token string, cursor stri
 	}
 
 	if resp.StatusCode != 200 {
-		fmt.Printf("Url: '%s'\n", url)
-		fmt.Printf("Unexpected status: '%s'\n", resp.Status)
-		fmt.Printf("Response body: '%s'\n", body)
+		return nil, "", newHTTPStatusError(req, resp, body)
 	}
 
 	var users ArtifactoryUsers
@@ -191,26 +179,24 @@ func getUsersPage(client *http.Client, baseurl string, token string, cursor stri
 	return users.Users, users.Cursor, nil
 }
 
-func getGroups(client *http.Client, baseurl string, token string, cachefolder string, usecache bool) ([]ArtifactoryGroup, error) {
+func getGroups(client *http.Client, baseurl string, token string, cache *Cache) ([]ArtifactoryGroup, error) {
 	var allgroups []ArtifactoryGroup
-	cachefilename := filepath.Join(cachefolder, "allgroups.json")
-
-	if usecache {
-		if _, err := os.Stat(cachefilename); err == nil {
-			fmt.Printf("Using cached groups from file: '%s'\n", cachefilename)
+	cachefilename := cache.Path("allgroups.json")
 
-			data, err := os.ReadFile(cachefilename)
-			if err != nil {
-				return nil, fmt.Errorf("error reading groups: %w", err)
-			}
+	if cache.IsValid("groups", "allgroups.json") {
+		fmt.Printf("Using cached groups from file: '%s'\n", cachefilename)
 
-			err = json.Unmarshal(data, &allgroups)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing json file: %w", err)
-			}
+		data, err := os.ReadFile(cachefilename)
+		if err != nil {
+			return nil, fmt.Errorf("error reading groups: %w", err)
+		}
 
-			return allgroups, nil
+		err = json.Unmarshal(data, &allgroups)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing json file: %w", err)
 		}
+
+		return allgroups, nil
 	}
 
 	var cursor string
@@ -232,7 +218,7 @@ func getGroups(client *http.Client, baseurl string, token string, cachefolder st
 				return nil, fmt.Errorf("error generating json: %w", err)
 			}
 
-			err = os.WriteFile(cachefilename, []byte(json), 0600)
+			err = cache.Save("groups", "allgroups.json", json)
 			if err != nil {
 				return nil, fmt.Errorf("error saving groups: %w", err)
 			}
@@ -257,7 +243,7 @@ func getGroupsPage(client *http.Client, baseurl string, token string, cursor str
 
 	req.Header.Set("Authorization", "Bearer "+token)
 
-	resp, err := client.Do(req)
+	resp, err := doRequest(client, req)
 	if err != nil {
 		return nil, "", fmt.Errorf("error sending request: %w", err)
 	}
@@ -269,9 +255,7 @@ func getGroupsPage(client *http.Client, baseurl string, token string, cursor str
 	}
 
 	if resp.StatusCode != 200 {
-		fmt.Printf("Url: '%s'\n", url)
-		fmt.Printf("Unexpected status: '%s'\n", resp.Status)
-		fmt.Printf("Response body: '%s'\n", body)
+		return nil, "", newHTTPStatusError(req, resp, body)
 	}
 
 	var groups ArtifactoryGroups
@@ -283,26 +267,24 @@ func getGroupsPage(client *http.Client, baseurl string, token string, cursor str
 	return groups.Groups, groups.Cursor, nil
 }
 
-func getRepos(client *http.Client, baseurl string, token string, cachefolder string, usecache bool) ([]ArtifactoryRepoResponse, error) {
+func getRepos(client *http.Client, baseurl string, token string, cache *Cache) ([]ArtifactoryRepoResponse, error) {
 	var repos []ArtifactoryRepoResponse
-	cachefilename := filepath.Join(cachefolder, "allrepos.json")
+	cachefilename := cache.Path("allrepos.json")
 
-	if usecache {
-		if _, err := os.Stat(cachefilename); err == nil {
-			fmt.Printf("Using cached repos from file: '%s'\n", cachefilename)
+	if cache.IsValid("repos", "allrepos.json") {
+		fmt.Printf("Using cached repos from file: '%s'\n", cachefilename)
 
-			data, err := os.ReadFile(cachefilename)
-			if err != nil {
-				return nil, fmt.Errorf("error reading repos: %w", err)
-			}
-
-			err = json.Unmarshal(data, &repos)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing json file: %w", err)
-			}
+		data, err := os.ReadFile(cachefilename)
+		if err != nil {
+			return nil, fmt.Errorf("error reading repos: %w", err)
+		}
 
-			return repos, nil
+		err = json.Unmarshal(data, &repos)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing json file: %w", err)
 		}
+
+		return repos, nil
 	}
 
 	url := fmt.Sprintf("%s/artifactory/api/repositories", baseurl)
@@ -313,7 +295,7 @@ func getRepos(client *http.Client, baseurl string, token string, cachefolder str
 
 	req.Header.Set("Authorization", "Bearer "+token)
 
-	resp, err := client.Do(req)
+	resp, err := doRequest(client, req)
 	if err != nil {
 		return nil, fmt.Errorf("error sending request: %w", err)
 	}
@@ -325,101 +307,135 @@ func getRepos(client *http.Client, baseurl string, token string, cachefolder str
 	}
 
 	if resp.StatusCode != 200 {
-		fmt.Printf("Url: '%s'\n", url)
-		fmt.Printf("Unexpected status: '%s'\n", resp.Status)
-		fmt.Printf("Response body: '%s'\n", body)
+		return nil, newHTTPStatusError(req, resp, body)
 	}
 
-	err = os.WriteFile(cachefilename, []byte(body), 0600)
+	err = json.Unmarshal(body, &repos)
 	if err != nil {
-		return nil, fmt.Errorf("error saving response body: %w", err)
+		return nil, fmt.Errorf("error parsing response body: %w", err)
 	}
 
-	err = json.Unmarshal(body, &repos)
+	err = cache.Save("repos", "allrepos.json", body)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing response body: %w", err)
+		return nil, fmt.Errorf("error saving response body: %w", err)
 	}
 
 	return repos, nil
 }
 
-func getRepoDetails(client *http.Client, baseurl string, token string, repos []ArtifactoryRepoResponse, cachefolder string, usecache bool) ([]ArtifactoryRepoDetailsResponse, error) {
+func getRepoDetails(client *http.Client, baseurl string, token string, repos []ArtifactoryRepoResponse, cache *Cache, concurrency int) ([]ArtifactoryRepoDetailsResponse, error) {
 	var allrepodetails []ArtifactoryRepoDetailsResponse
-	cachefilename := filepath.Join(cachefolder, "allrepodetails.json")
+	cachefilename := cache.Path("allrepodetails.json")
 
-	if usecache {
-		if _, err := os.Stat(cachefilename); err == nil {
-			fmt.Printf("Using cached repo details from file: '%s'\n", cachefilename)
+	if cache.IsValid("repodetails", "allrepodetails.json") {
+		fmt.Printf("Using cached repo details from file: '%s'\n", cachefilename)
 
-			data, err := os.ReadFile(cachefilename)
-			if err != nil {
-				return nil, fmt.Errorf("error reading repo details: %w", err)
-			}
-
-			err = json.Unmarshal(data, &allrepodetails)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing json file: %w", err)
-			}
+		data, err := os.ReadFile(cachefilename)
+		if err != nil {
+			return nil, fmt.Errorf("error reading repo details: %w", err)
+		}
 
-			return allrepodetails, nil
+		err = json.Unmarshal(data, &allrepodetails)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing json file: %w", err)
 		}
-	}
 
-	fmt.Println("Getting Repo details...")
+		return allrepodetails, nil
+	}
 
-	for _, repo := range repos {
-		fmt.Print(".")
+	var keys []string
+	for _, item := range repos {
+		keys = append(keys, item.Key)
+	}
 
-		url := fmt.Sprintf("%s/artifactory/api/repositories/%s", baseurl, url.PathEscape(repo.Key))
-		req, err := http.NewRequest("GET", url, nil)
+	cached := make(map[string]ArtifactoryRepoDetailsResponse)
+	if cache.IsIncremental("repodetails", "allrepodetails.json") {
+		var cachedlist []ArtifactoryRepoDetailsResponse
+		data, err := os.ReadFile(cachefilename)
+		if err == nil {
+			err = json.Unmarshal(data, &cachedlist)
+		}
 		if err != nil {
-			return nil, fmt.Errorf("error creating request: %w", err)
+			fmt.Printf("Warning: Couldn't read cached repo details, retrieving all: %v\n", err)
+		}
+		for _, repodetails := range cachedlist {
+			cached[repodetails.Key] = repodetails
 		}
+	}
 
-		req.Header.Set("Authorization", "Bearer "+token)
+	cachedKeys := make(map[string]bool)
+	for key := range cached {
+		cachedKeys[key] = true
+	}
+	fetch := cache.GetKeysToFetch("repodetails", keys, cachedKeys)
 
-		resp, err := client.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("error sending request: %w", err)
-		}
-		defer resp.Body.Close()
+	if len(cached) > 0 {
+		fmt.Printf("Getting Repo details incrementally, %d of %d...\n", len(fetch), len(keys))
+	} else {
+		fmt.Println("Getting Repo details...")
+	}
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("error reading response body: %w", err)
+	err := cache.RemovePageFiles("allrepodetails")
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*ArtifactoryRepoDetailsResponse, len(repos))
+
+	err = runConcurrently(concurrency, len(repos), func(i int) error {
+		if !fetch[repos[i].Key] {
+			repodetails := cached[repos[i].Key]
+			results[i] = &repodetails
+			return nil
 		}
 
-		if resp.StatusCode != 200 {
-			fmt.Printf("Url: '%s'\n", url)
-			fmt.Printf("Unexpected status: '%s'\n", resp.Status)
-			fmt.Printf("Response body: '%s'\n", body)
+		fmt.Print(".")
+
+		var etag string
+		if _, ok := cached[repos[i].Key]; ok {
+			item, _ := cache.GetItem("repodetails", repos[i].Key)
+			etag = item.ETag
 		}
 
-		outfile := fmt.Sprintf("%s/allrepodetails_page_%04d.json", cachefolder, pagenumRepos)
-		pagenumRepos++
-		err = os.WriteFile(outfile, []byte(body), 0600)
+		repodetails, err := getRepoDetailsSingle(client, baseurl, token, repos[i].Key, etag, cache, i)
+		if errors.Is(err, errNotModified) {
+			repodetails = cached[repos[i].Key]
+			err = nil
+		}
+		if errors.Is(err, ErrNotFound) {
+			fmt.Printf("\nWarning: Repo '%s' was deleted while retrieving, ignoring it.\n", repos[i].Key)
+			return nil
+		}
 		if err != nil {
-			return nil, fmt.Errorf("error saving response body: %w", err)
+			return fmt.Errorf("error getting repo details for '%s': %w", repos[i].Key, err)
 		}
 
-		var repodetails ArtifactoryRepoDetailsResponse
+		results[i] = &repodetails
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		err = json.Unmarshal(body, &repodetails)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing response body: %w", err)
+	for _, repodetails := range results {
+		if repodetails != nil {
+			allrepodetails = append(allrepodetails, *repodetails)
 		}
-
-		allrepodetails = append(allrepodetails, repodetails)
 	}
 
 	fmt.Println()
 
+	dropped := cache.PruneItems("repodetails", keys)
+	if len(cached) > 0 && dropped > 0 {
+		fmt.Printf("Dropped %d deleted repos from cache.\n", dropped)
+	}
+
 	json, err := json.MarshalIndent(allrepodetails, "", "  ")
 	if err != nil {
 		return nil, fmt.Errorf("error generating json: %w", err)
 	}
 
-	err = os.WriteFile(cachefilename, []byte(string(json)), 0600)
+	err = cache.Save("repodetails", "allrepodetails.json", json)
 	if err != nil {
 		return nil, fmt.Errorf("error saving repo details: %w", err)
 	}
@@ -427,26 +443,80 @@ func getRepoDetails(client *http.Client, baseurl string, token string, repos []A
 	return allrepodetails, nil
 }
 
-func getPermissions(client *http.Client, baseurl string, token string, cachefolder string, usecache bool) ([]ArtifactoryPermission, error) {
-	var allpermissions []ArtifactoryPermission
-	cachefilename := filepath.Join(cachefolder, "allpermissions.json")
+func getRepoDetailsSingle(client *http.Client, baseurl string, token string, repokey string, etag string, cache *Cache, pagenum int) (ArtifactoryRepoDetailsResponse, error) {
+	var repodetails ArtifactoryRepoDetailsResponse
+
+	url := fmt.Sprintf("%s/artifactory/api/repositories/%s", baseurl, url.PathEscape(repokey))
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return repodetails, fmt.Errorf("error creating request: %w", err)
+	}
 
-	if usecache {
-		if _, err := os.Stat(cachefilename); err == nil {
-			fmt.Printf("Using cached permissions from file: '%s'\n", cachefilename)
+	req.Header.Set("Authorization", "Bearer "+token)
 
-			data, err := os.ReadFile(cachefilename)
-			if err != nil {
-				return nil, fmt.Errorf("error reading permissions: %w", err)
-			}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
 
-			err = json.Unmarshal(data, &allpermissions)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing json file: %w", err)
-			}
+	resp, err := doRequest(client, req)
+	if err != nil {
+		return repodetails, fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
 
-			return allpermissions, nil
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return repodetails, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		// A 304 doesn't have to repeat the ETag, the one that was sent is still valid.
+		if responseEtag := resp.Header.Get("ETag"); responseEtag != "" {
+			etag = responseEtag
 		}
+		cache.SetItem("repodetails", repokey, etag)
+		return repodetails, errNotModified
+	}
+
+	if resp.StatusCode != 200 {
+		return repodetails, newHTTPStatusError(req, resp, body)
+	}
+
+	outfile := cache.Path(fmt.Sprintf("allrepodetails_page_%04d.json", pagenum))
+	err = os.WriteFile(outfile, []byte(body), 0600)
+	if err != nil {
+		return repodetails, fmt.Errorf("error saving response body: %w", err)
+	}
+
+	err = json.Unmarshal(body, &repodetails)
+	if err != nil {
+		return repodetails, fmt.Errorf("error parsing response body: %w", err)
+	}
+	repodetails.JsonSource = string(body)
+
+	cache.SetItem("repodetails", repokey, resp.Header.Get("ETag"))
+
+	return repodetails, nil
+}
+
+func getPermissions(client *http.Client, baseurl string, token string, cache *Cache) ([]ArtifactoryPermission, error) {
+	var allpermissions []ArtifactoryPermission
+	cachefilename := cache.Path("allpermissions.json")
+
+	if cache.IsValid("permissions", "allpermissions.json") {
+		fmt.Printf("Using cached permissions from file: '%s'\n", cachefilename)
+
+		data, err := os.ReadFile(cachefilename)
+		if err != nil {
+			return nil, fmt.Errorf("error reading permissions: %w", err)
+		}
+
+		err = json.Unmarshal(data, &allpermissions)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing json file: %w", err)
+		}
+
+		return allpermissions, nil
 	}
 
 	var cursor string
@@ -468,7 +538,7 @@ func getPermissions(client *http.Client, baseurl string, token string, cachefold
 				return nil, fmt.Errorf("error generating json: %w", err)
 			}
 
-			err = os.WriteFile(cachefilename, []byte(json), 0600)
+			err = cache.Save("permissions", "allpermissions.json", json)
 			if err != nil {
 				return nil, fmt.Errorf("error saving permissions: %w", err)
 			}
@@ -493,7 +563,7 @@ func getPermissionsPage(client *http.Client, baseurl string, token string, curso
 
 	req.Header.Set("Authorization", "Bearer "+token)
 
-	resp, err := client.Do(req)
+	resp, err := doRequest(client, req)
 	if err != nil {
 		return nil, "", fmt.Errorf("error sending request: %w", err)
 	}
@@ -505,9 +575,7 @@ func getPermissionsPage(client *http.Client, baseurl string, token string, curso
 	}
 
 	if resp.StatusCode != 200 {
-		fmt.Printf("Url: '%s'\n", url)
-		fmt.Printf("Unexpected status: '%s'\n", resp.Status)
-		fmt.Printf("Response body: '%s'\n", body)
+		return nil, "", newHTTPStatusError(req, resp, body)
 	}
 
 	var permissions ArtifactoryPermissions
@@ -519,84 +587,119 @@ func getPermissionsPage(client *http.Client, baseurl string, token string, curso
 	return permissions.Permissions, permissions.Cursor, nil
 }
 
-func getPermissionDetails(client *http.Client, baseurl string, token string, permissions []ArtifactoryPermission, cachefolder string, usecache bool) ([]ArtifactoryPermissionDetails, error) {
+func getPermissionDetails(client *http.Client, baseurl string, token string, permissions []ArtifactoryPermission, cache *Cache, concurrency int) ([]ArtifactoryPermissionDetails, error) {
 	var allpermissiondetails []ArtifactoryPermissionDetails
-	cachefilename := filepath.Join(cachefolder, "allpermissiondetails.json")
-
-	if usecache {
-		if _, err := os.Stat(cachefilename); err == nil {
-			fmt.Printf("Using cached permission details from file: '%s'\n", cachefilename)
+	cachefilename := cache.Path("allpermissiondetails.json")
 
-			data, err := os.ReadFile(cachefilename)
-			if err != nil {
-				return nil, fmt.Errorf("error reading permission details: %w", err)
-			}
+	if cache.IsValid("permissiondetails", "allpermissiondetails.json") {
+		fmt.Printf("Using cached permission details from file: '%s'\n", cachefilename)
 
-			err = json.Unmarshal(data, &allpermissiondetails)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing json file: %w", err)
-			}
+		data, err := os.ReadFile(cachefilename)
+		if err != nil {
+			return nil, fmt.Errorf("error reading permission details: %w", err)
+		}
 
-			return allpermissiondetails, nil
+		err = json.Unmarshal(data, &allpermissiondetails)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing json file: %w", err)
 		}
-	}
 
-	fmt.Println("Getting Permission details...")
+		return allpermissiondetails, nil
+	}
 
-	for _, permission := range permissions {
-		fmt.Print(".")
+	var keys []string
+	for _, item := range permissions {
+		keys = append(keys, item.Name)
+	}
 
-		url := fmt.Sprintf("%s/access/api/v2/permissions/%s", baseurl, url.PathEscape(permission.Name))
-		req, err := http.NewRequest("GET", url, nil)
+	cached := make(map[string]ArtifactoryPermissionDetails)
+	if cache.IsIncremental("permissiondetails", "allpermissiondetails.json") {
+		var cachedlist []ArtifactoryPermissionDetails
+		data, err := os.ReadFile(cachefilename)
+		if err == nil {
+			err = json.Unmarshal(data, &cachedlist)
+		}
 		if err != nil {
-			return nil, fmt.Errorf("error creating request: %w", err)
+			fmt.Printf("Warning: Couldn't read cached permission details, retrieving all: %v\n", err)
+		}
+		for _, permissiondetails := range cachedlist {
+			cached[permissiondetails.Name] = permissiondetails
 		}
+	}
 
-		req.Header.Set("Authorization", "Bearer "+token)
+	cachedKeys := make(map[string]bool)
+	for key := range cached {
+		cachedKeys[key] = true
+	}
+	fetch := cache.GetKeysToFetch("permissiondetails", keys, cachedKeys)
 
-		resp, err := client.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("error sending request: %w", err)
-		}
-		defer resp.Body.Close()
+	if len(cached) > 0 {
+		fmt.Printf("Getting Permission details incrementally, %d of %d...\n", len(fetch), len(keys))
+	} else {
+		fmt.Println("Getting Permission details...")
+	}
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("error reading response body: %w", err)
+	err := cache.RemovePageFiles("allpermissiondetails")
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*ArtifactoryPermissionDetails, len(permissions))
+
+	err = runConcurrently(concurrency, len(permissions), func(i int) error {
+		if !fetch[permissions[i].Name] {
+			permissiondetails := cached[permissions[i].Name]
+			results[i] = &permissiondetails
+			return nil
 		}
 
-		if resp.StatusCode != 200 {
-			fmt.Printf("Url: '%s'\n", url)
-			fmt.Printf("Unexpected status: '%s'\n", resp.Status)
-			fmt.Printf("Response body: '%s'\n", body)
+		fmt.Print(".")
+
+		var etag string
+		if _, ok := cached[permissions[i].Name]; ok {
+			item, _ := cache.GetItem("permissiondetails", permissions[i].Name)
+			etag = item.ETag
 		}
 
-		outfile := fmt.Sprintf("%s/allpermissiondetails_page_%04d.json", cachefolder, pagenumPermissions)
-		pagenumPermissions++
-		err = os.WriteFile(outfile, []byte(body), 0600)
+		permissiondetails, err := getPermissionDetailsSingle(client, baseurl, token, permissions[i].Name, etag, cache, i)
+		if errors.Is(err, errNotModified) {
+			permissiondetails = cached[permissions[i].Name]
+			err = nil
+		}
+		if errors.Is(err, ErrNotFound) {
+			fmt.Printf("\nWarning: Permission target '%s' was deleted while retrieving, ignoring it.\n", permissions[i].Name)
+			return nil
+		}
 		if err != nil {
-			return nil, fmt.Errorf("error saving response body: %w", err)
+			return fmt.Errorf("error getting permission details for '%s': %w", permissions[i].Name, err)
 		}
 
-		var permissiondetails ArtifactoryPermissionDetails
+		results[i] = &permissiondetails
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		err = json.Unmarshal(body, &permissiondetails)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing response body: %w", err)
+	for _, permissiondetails := range results {
+		if permissiondetails != nil {
+			allpermissiondetails = append(allpermissiondetails, *permissiondetails)
 		}
-		permissiondetails.JsonSource = string(body)
-
-		allpermissiondetails = append(allpermissiondetails, permissiondetails)
 	}
 
 	fmt.Println()
 
+	dropped := cache.PruneItems("permissiondetails", keys)
+	if len(cached) > 0 && dropped > 0 {
+		fmt.Printf("Dropped %d deleted permission targets from cache.\n", dropped)
+	}
+
 	json, err := json.MarshalIndent(allpermissiondetails, "", "  ")
 	if err != nil {
 		return nil, fmt.Errorf("error generating json: %w", err)
 	}
 
-	err = os.WriteFile(cachefilename, []byte(string(json)), 0600)
+	err = cache.Save("permissiondetails", "allpermissiondetails.json", json)
 	if err != nil {
 		return nil, fmt.Errorf("error saving permission details: %w", err)
 	}
@@ -604,26 +707,80 @@ func getPermissionDetails(client *http.Client, baseurl string, token string, per
 	return allpermissiondetails, nil
 }
 
-func getLDAPSettings(client *http.Client, baseurl string, token string, cachefolder string, usecache bool) ([]ArtifactoryLDAPSettings, error) {
-	var settings []ArtifactoryLDAPSettings
-	cachefilename := filepath.Join(cachefolder, "ldap_settings.json")
+func getPermissionDetailsSingle(client *http.Client, baseurl string, token string, permissionname string, etag string, cache *Cache, pagenum int) (ArtifactoryPermissionDetails, error) {
+	var permissiondetails ArtifactoryPermissionDetails
 
-	if usecache {
-		if _, err := os.Stat(cachefilename); err == nil {
-			fmt.Printf("Using cached ldap settings from file: '%s'\n", cachefilename)
+	url := fmt.Sprintf("%s/access/api/v2/permissions/%s", baseurl, url.PathEscape(permissionname))
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return permissiondetails, fmt.Errorf("error creating request: %w", err)
+	}
 
-			data, err := os.ReadFile(cachefilename)
-			if err != nil {
-				return nil, fmt.Errorf("error reading ldap settings: %w", err)
-			}
+	req.Header.Set("Authorization", "Bearer "+token)
 
-			err = json.Unmarshal(data, &settings)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing json file: %w", err)
-			}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := doRequest(client, req)
+	if err != nil {
+		return permissiondetails, fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return permissiondetails, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		// A 304 doesn't have to repeat the ETag, the one that was sent is still valid.
+		if responseEtag := resp.Header.Get("ETag"); responseEtag != "" {
+			etag = responseEtag
+		}
+		cache.SetItem("permissiondetails", permissionname, etag)
+		return permissiondetails, errNotModified
+	}
+
+	if resp.StatusCode != 200 {
+		return permissiondetails, newHTTPStatusError(req, resp, body)
+	}
+
+	outfile := cache.Path(fmt.Sprintf("allpermissiondetails_page_%04d.json", pagenum))
+	err = os.WriteFile(outfile, []byte(body), 0600)
+	if err != nil {
+		return permissiondetails, fmt.Errorf("error saving response body: %w", err)
+	}
 
-			return settings, nil
+	err = json.Unmarshal(body, &permissiondetails)
+	if err != nil {
+		return permissiondetails, fmt.Errorf("error parsing response body: %w", err)
+	}
+	permissiondetails.JsonSource = string(body)
+
+	cache.SetItem("permissiondetails", permissionname, resp.Header.Get("ETag"))
+
+	return permissiondetails, nil
+}
+
+func getLDAPSettings(client *http.Client, baseurl string, token string, cache *Cache) ([]ArtifactoryLDAPSettings, error) {
+	var settings []ArtifactoryLDAPSettings
+	cachefilename := cache.Path("ldap_settings.json")
+
+	if cache.IsValid("ldapsettings", "ldap_settings.json") {
+		fmt.Printf("Using cached ldap settings from file: '%s'\n", cachefilename)
+
+		data, err := os.ReadFile(cachefilename)
+		if err != nil {
+			return nil, fmt.Errorf("error reading ldap settings: %w", err)
 		}
+
+		err = json.Unmarshal(data, &settings)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing json file: %w", err)
+		}
+
+		return settings, nil
 	}
 
 	url := fmt.Sprintf("%s/access/api/v1/ldap/settings", baseurl)
@@ -635,7 +792,7 @@ func getLDAPSettings(client *http.Client, baseurl string, token string, cachefol
 
 	req.Header.Set("Authorization", "Bearer "+token)
 
-	resp, err := client.Do(req)
+	resp, err := doRequest(client, req)
 	if err != nil {
 		return nil, fmt.Errorf("error sending request: %w", err)
 	}
@@ -647,9 +804,7 @@ func getLDAPSettings(client *http.Client, baseurl string, token string, cachefol
 	}
 
 	if resp.StatusCode != 200 {
-		fmt.Printf("Url: '%s'\n", url)
-		fmt.Printf("Unexpected status: '%s'\n", resp.Status)
-		fmt.Printf("Response body: '%s'\n", body)
+		return nil, newHTTPStatusError(req, resp, body)
 	}
 
 	err = json.Unmarshal(body, &settings)
@@ -662,7 +817,7 @@ func getLDAPSettings(client *http.Client, baseurl string, token string, cachefol
 		return nil, fmt.Errorf("error generating json: %w", err)
 	}
 
-	err = os.WriteFile(cachefilename, []byte(json), 0600)
+	err = cache.Save("ldapsettings", "ldap_settings.json", json)
 	if err != nil {
 		return nil, fmt.Errorf("error saving ldap settings: %w", err)
 	}
@@ -670,26 +825,24 @@ func getLDAPSettings(client *http.Client, baseurl string, token string, cachefol
 	return settings, nil
 }
 
-func getLDAPGroupSettings(client *http.Client, baseurl string, token string, cachefolder string, usecache bool) ([]ArtifactoryLDAPGroupSettings, error) {
+func getLDAPGroupSettings(client *http.Client, baseurl string, token string, cache *Cache) ([]ArtifactoryLDAPGroupSettings, error) {
 	var groups []ArtifactoryLDAPGroupSettings
-	cachefilename := filepath.Join(cachefolder, "ldap_groups.json")
+	cachefilename := cache.Path("ldap_groups.json")
 
-	if usecache {
-		if _, err := os.Stat(cachefilename); err == nil {
-			fmt.Printf("Using cached ldap groups from file: '%s'\n", cachefilename)
-
-			data, err := os.ReadFile(cachefilename)
-			if err != nil {
-				return nil, fmt.Errorf("error reading ldap groups: %w", err)
-			}
+	if cache.IsValid("ldapgroups", "ldap_groups.json") {
+		fmt.Printf("Using cached ldap groups from file: '%s'\n", cachefilename)
 
-			err = json.Unmarshal(data, &groups)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing json file: %w", err)
-			}
+		data, err := os.ReadFile(cachefilename)
+		if err != nil {
+			return nil, fmt.Errorf("error reading ldap groups: %w", err)
+		}
 
-			return groups, nil
+		err = json.Unmarshal(data, &groups)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing json file: %w", err)
 		}
+
+		return groups, nil
 	}
 
 	url := fmt.Sprintf("%s/access/api/v1/ldap/groups", baseurl)
@@ -701,7 +854,7 @@ func getLDAPGroupSettings(client *http.Client, baseurl string, token string, cac
 
 	req.Header.Set("Authorization", "Bearer "+token)
 
-	resp, err := client.Do(req)
+	resp, err := doRequest(client, req)
 	if err != nil {
 		return nil, fmt.Errorf("error sending request: %w", err)
 	}
@@ -713,9 +866,7 @@ func getLDAPGroupSettings(client *http.Client, baseurl string, token string, cac
 	}
 
 	if resp.StatusCode != 200 {
-		fmt.Printf("Url: '%s'\n", url)
-		fmt.Printf("Unexpected status: '%s'\n", resp.Status)
-		fmt.Printf("Response body: '%s'\n", body)
+		return nil, newHTTPStatusError(req, resp, body)
 	}
 
 	err = json.Unmarshal(body, &groups)
@@ -728,7 +879,7 @@ func getLDAPGroupSettings(client *http.Client, baseurl string, token string, cac
 		return nil, fmt.Errorf("error generating json: %w", err)
 	}
 
-	err = os.WriteFile(cachefilename, []byte(json), 0600)
+	err = cache.Save("ldapgroups", "ldap_groups.json", json)
 	if err != nil {
 		return nil, fmt.Errorf("error saving ldap groups: %w", err)
 	}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGetRepoDetailsConcurrent(t *testing.T) {
+	var repos []ArtifactoryRepoResponse
+	for i := range 50 {
+		repos = append(repos, ArtifactoryRepoResponse{Key: fmt.Sprintf("repo%02d", i)})
+	}
+
+	client := mockHTTPClient(func(req *http.Request) (*http.Response, error) {
+		key := strings.TrimPrefix(req.URL.Path, "/artifactory/api/repositories/")
+		body := fmt.Sprintf(`{"key":"%s","rclass":"local","packageType":"generic"}`, key)
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+	})
+
+	repodetails, err := getRepoDetails(client, "", "", repos, newTestCache(t), 8)
+	if err != nil {
+		t.Fatalf("GetRepoDetailsConcurrent: error = %v", err)
+	}
+	if len(repodetails) != len(repos) {
+		t.Fatalf("GetRepoDetailsConcurrent: got %d repo details, want %d", len(repodetails), len(repos))
+	}
+	for i := range repos {
+		if repodetails[i].Key != repos[i].Key {
+			t.Errorf("GetRepoDetailsConcurrent: order not preserved at %d: got '%s', want '%s'", i, repodetails[i].Key, repos[i].Key)
+		}
+	}
+}
+
+func TestGetReposErrorStatus(t *testing.T) {
+	client := mockHTTPClient(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 401, Status: "401 Unauthorized", Body: io.NopCloser(strings.NewReader(`{"errors":[{"status":401}]}`)), Header: make(http.Header)}, nil
+	})
+
+	cache := newTestCache(t)
+
+	_, err := getRepos(client, "", "", cache)
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("GetReposErrorStatus: error = %v, want ErrUnauthorized", err)
+	}
+
+	files, _ := os.ReadDir(cache.Folder)
+	if len(files) != 0 {
+		t.Errorf("GetReposErrorStatus: failed response was cached: %d files", len(files))
+	}
+}
+
+func TestGetRepoDetailsDeleted(t *testing.T) {
+	repos := []ArtifactoryRepoResponse{{Key: "repo1"}, {Key: "deleted"}, {Key: "repo2"}}
+
+	client := mockHTTPClient(func(req *http.Request) (*http.Response, error) {
+		key := strings.TrimPrefix(req.URL.Path, "/artifactory/api/repositories/")
+		if key == "deleted" {
+			return &http.Response{StatusCode: 404, Status: "404 Not Found", Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+		}
+		body := fmt.Sprintf(`{"key":"%s","rclass":"local","packageType":"generic"}`, key)
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+	})
+
+	repodetails, err := getRepoDetails(client, "", "", repos, newTestCache(t), 2)
+	if err != nil {
+		t.Fatalf("GetRepoDetailsDeleted: error = %v", err)
+	}
+	if len(repodetails) != 2 || repodetails[0].Key != "repo1" || repodetails[1].Key != "repo2" {
+		t.Errorf("GetRepoDetailsDeleted: got %v, want repo1 and repo2", repodetails)
+	}
+}
+
+func TestGetRepoDetailsIncremental(t *testing.T) {
+	cache := newTestCache(t)
+	cache.Incremental = true
+	cache.RevalidateSample = 1
+
+	var requested []string
+	var mutex sync.Mutex
+	client := mockHTTPClient(func(req *http.Request) (*http.Response, error) {
+		key := strings.TrimPrefix(req.URL.Path, "/artifactory/api/repositories/")
+
+		mutex.Lock()
+		requested = append(requested, key)
+		mutex.Unlock()
+
+		header := make(http.Header)
+		header.Set("ETag", `"`+key+`"`)
+		if req.Header.Get("If-None-Match") == `"`+key+`"` {
+			// Without repeating the ETag.
+			return &http.Response{StatusCode: 304, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+		}
+		body := fmt.Sprintf(`{"key":"%s","rclass":"local","packageType":"generic"}`, key)
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body)), Header: header}, nil
+	})
+
+	_, err := getRepoDetails(client, "", "", []ArtifactoryRepoResponse{{Key: "repo1"}, {Key: "repo2"}, {Key: "deleted"}}, cache, 1)
+	if err != nil {
+		t.Fatalf("GetRepoDetailsIncremental: error = %v", err)
+	}
+	if len(requested) != 3 {
+		t.Errorf("GetRepoDetailsIncremental: first run requested %v, want all repos", requested)
+	}
+
+	requested = nil
+	repodetails, err := getRepoDetails(client, "", "", []ArtifactoryRepoResponse{{Key: "repo1"}, {Key: "repo2"}, {Key: "new"}}, cache, 1)
+	if err != nil {
+		t.Fatalf("GetRepoDetailsIncremental: error = %v", err)
+	}
+
+	// The new repo, and the oldest cached repo as revalidation sample.
+	if !slices.Equal(requested, []string{"repo1", "new"}) {
+		t.Errorf("GetRepoDetailsIncremental: second run requested %v, want [repo1 new]", requested)
+	}
+
+	var keys []string
+	for _, r := range repodetails {
+		keys = append(keys, r.Key)
+	}
+	if !slices.Equal(keys, []string{"repo1", "repo2", "new"}) {
+		t.Errorf("GetRepoDetailsIncremental: got %v, want [repo1 repo2 new]", keys)
+	}
+
+	if _, ok := cache.GetItem("repodetails", "deleted"); ok {
+		t.Errorf("GetRepoDetailsIncremental: deleted repo still in cache")
+	}
+	if item, _ := cache.GetItem("repodetails", "repo1"); item.ETag != `"repo1"` {
+		t.Errorf("GetRepoDetailsIncremental: revalidated repo has ETag %q, want %q", item.ETag, `"repo1"`)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type RetryConfig struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+var retryConfig = RetryConfig{
+	MaxRetries: 3,
+	BaseDelay:  500 * time.Millisecond,
+	MaxDelay:   30 * time.Second,
+}
+
+// For mock testing.
+var sleepFn = time.Sleep
+
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrNotFound     = errors.New("not found")
+	ErrServer       = errors.New("server error")
+)
+
+type HTTPStatusError struct {
+	Method     string
+	Url        string
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *HTTPStatusError) Error() string {
+	body := e.Body
+	if len(body) > 500 {
+		body = body[:500] + "..."
+	}
+	return fmt.Sprintf("unexpected status from %s '%s': '%s', response body: '%s'", e.Method, e.Url, e.Status, body)
+}
+
+// Makes the error match ErrUnauthorized, ErrNotFound or ErrServer with errors.Is.
+func (e *HTTPStatusError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode >= 500:
+		return ErrServer
+	}
+	return nil
+}
+
+func newHTTPStatusError(req *http.Request, resp *http.Response, body []byte) error {
+	return &HTTPStatusError{
+		Method:     req.Method,
+		Url:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       string(body),
+	}
+}
+
+// All Artifactory calls go through here. Idempotent requests are retried on network errors
+// and transient statuses, other requests only when Artifactory rejected them with 429.
+func doRequest(client *http.Client, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("error rewinding request body: %w", err)
+			}
+			req.Body = body
+		}
+
+		requestLimiter.Wait()
+
+		resp, err := client.Do(req)
+
+		if attempt >= retryConfig.MaxRetries || !isRetryable(req, resp, err) {
+			return resp, err
+		}
+
+		delay := getRetryDelay(attempt, resp)
+
+		if err != nil {
+			log.Printf("Retrying %s '%s' (attempt %d/%d) in %s: %v\n", req.Method, req.URL, attempt+1, retryConfig.MaxRetries, delay, err)
+		} else {
+			log.Printf("Retrying %s '%s' (attempt %d/%d) in %s: %s\n", req.Method, req.URL, attempt+1, retryConfig.MaxRetries, delay, resp.Status)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		incStat(&stats.RetriedRequestCount)
+		sleepFn(delay)
+	}
+}
+
+func isRetryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	idempotent := req.Method == "GET" || req.Method == "HEAD" || req.Method == "PUT" || req.Method == "DELETE" || req.Method == "OPTIONS"
+
+	if err != nil {
+		return idempotent
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent
+	}
+
+	return false
+}
+
+func getRetryDelay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return min(retryAfter, retryConfig.MaxDelay)
+		}
+	}
+
+	delay := retryConfig.BaseDelay << attempt
+	if delay <= 0 || delay > retryConfig.MaxDelay {
+		delay = retryConfig.MaxDelay
+	}
+
+	// Equal jitter, to spread out clients retrying against the same load balancer.
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + rand.N(half)
+}
+
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0), true
+	}
+
+	return 0, false
+}
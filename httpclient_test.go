@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDoRequestRetries(t *testing.T) {
+	tests := []struct {
+		method        string
+		statuses      []int
+		wantStatus    int
+		wantCalls     int
+		wantRetries   int
+		wantSleepTime time.Duration
+	}{
+		// No retry needed
+		{"GET", []int{200}, 200, 1, 0, 0},
+		// Transient errors on idempotent request
+		{"GET", []int{503, 502, 200}, 200, 3, 2, 0},
+		// Retries exhausted
+		{"PUT", []int{503, 503, 503, 503, 200}, 503, 4, 3, 0},
+		// Non-idempotent request isn't retried on server error
+		{"POST", []int{503, 201}, 503, 1, 0, 0},
+		// Non-idempotent request is retried when rejected
+		{"POST", []int{429, 201}, 201, 2, 1, 2 * time.Second},
+		// Client errors aren't retried
+		{"GET", []int{404, 200}, 404, 1, 0, 0},
+	}
+
+	origSleep := sleepFn
+	origRetryConfig := retryConfig
+	defer func() {
+		sleepFn = origSleep
+		retryConfig = origRetryConfig
+	}()
+
+	retryConfig = RetryConfig{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Second}
+
+	for i, tc := range tests {
+		var slept time.Duration
+		sleepFn = func(d time.Duration) { slept += d }
+
+		calls := 0
+		client := mockHTTPClient(func(req *http.Request) (*http.Response, error) {
+			body, _ := io.ReadAll(req.Body)
+			if string(body) != "payload" {
+				t.Errorf("DoRequestRetries (%d/%d): request body not replayed: '%s'", i+1, len(tests), body)
+			}
+
+			status := tc.statuses[calls]
+			calls++
+
+			resp := &http.Response{StatusCode: status, Status: http.StatusText(status), Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}
+			if status == 429 {
+				resp.Header.Set("Retry-After", "2")
+			}
+			return resp, nil
+		})
+
+		req, _ := http.NewRequest(tc.method, "http://artifactory.example.com/api", strings.NewReader("payload"))
+
+		ClearStats()
+		resp, err := doRequest(client, req)
+		if err != nil {
+			t.Errorf("DoRequestRetries (%d/%d): error = %v", i+1, len(tests), err)
+			continue
+		}
+		if resp.StatusCode != tc.wantStatus {
+			t.Errorf("DoRequestRetries (%d/%d): got status %d, want %d", i+1, len(tests), resp.StatusCode, tc.wantStatus)
+		}
+		if calls != tc.wantCalls {
+			t.Errorf("DoRequestRetries (%d/%d): got %d calls, want %d", i+1, len(tests), calls, tc.wantCalls)
+		}
+		if stats.RetriedRequestCount != tc.wantRetries {
+			t.Errorf("DoRequestRetries (%d/%d): got %d retries, want %d", i+1, len(tests), stats.RetriedRequestCount, tc.wantRetries)
+		}
+		if tc.wantSleepTime != 0 && slept != tc.wantSleepTime {
+			t.Errorf("DoRequestRetries (%d/%d): got sleep time %s, want %s", i+1, len(tests), slept, tc.wantSleepTime)
+		}
+	}
+}
+
+func TestGetRetryDelay(t *testing.T) {
+	origRetryConfig := retryConfig
+	defer func() { retryConfig = origRetryConfig }()
+
+	retryConfig = RetryConfig{MaxRetries: 5, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	for attempt := range 5 {
+		delay := getRetryDelay(attempt, nil)
+		ceiling := min(time.Second<<attempt, 5*time.Second)
+		if delay < ceiling/2 || delay > ceiling {
+			t.Errorf("GetRetryDelay (attempt %d): got %s, want between %s and %s", attempt, delay, ceiling/2, ceiling)
+		}
+	}
+
+	resp := &http.Response{Header: make(http.Header)}
+	resp.Header.Set("Retry-After", "60")
+	if delay := getRetryDelay(0, resp); delay != 5*time.Second {
+		t.Errorf("GetRetryDelay: Retry-After not capped: got %s", delay)
+	}
+
+	if _, ok := parseRetryAfter("invalid"); ok {
+		t.Errorf("GetRetryDelay: invalid Retry-After accepted")
+	}
+	if d, ok := parseRetryAfter(time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)); !ok || d != 0 {
+		t.Errorf("GetRetryDelay: Retry-After date in the past: got %s, %t", d, ok)
+	}
+}
+
+func TestHTTPStatusError(t *testing.T) {
+	tests := []struct {
+		statusCode       int
+		wantUnauthorized bool
+		wantNotFound     bool
+		wantServer       bool
+	}{
+		{401, true, false, false},
+		{403, true, false, false},
+		{404, false, true, false},
+		{500, false, false, true},
+		{503, false, false, true},
+		{400, false, false, false},
+	}
+
+	for i, tc := range tests {
+		req, _ := http.NewRequest("GET", "http://artifactory/api/repositories", nil)
+		resp := &http.Response{StatusCode: tc.statusCode, Status: http.StatusText(tc.statusCode)}
+
+		err := newHTTPStatusError(req, resp, []byte(strings.Repeat("x", 1000)))
+
+		if errors.Is(err, ErrUnauthorized) != tc.wantUnauthorized {
+			t.Errorf("HTTPStatusError (%d/%d): errors.Is(ErrUnauthorized) = %v, want %v", i+1, len(tests), !tc.wantUnauthorized, tc.wantUnauthorized)
+		}
+		if errors.Is(err, ErrNotFound) != tc.wantNotFound {
+			t.Errorf("HTTPStatusError (%d/%d): errors.Is(ErrNotFound) = %v, want %v", i+1, len(tests), !tc.wantNotFound, tc.wantNotFound)
+		}
+		if errors.Is(err, ErrServer) != tc.wantServer {
+			t.Errorf("HTTPStatusError (%d/%d): errors.Is(ErrServer) = %v, want %v", i+1, len(tests), !tc.wantServer, tc.wantServer)
+		}
+
+		var statusErr *HTTPStatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != tc.statusCode {
+			t.Errorf("HTTPStatusError (%d/%d): status code not kept", i+1, len(tests))
+		}
+		if len(err.Error()) > 700 {
+			t.Errorf("HTTPStatusError (%d/%d): body not truncated, message length %d", i+1, len(tests), len(err.Error()))
+		}
+	}
+}
@@ -7,11 +7,22 @@ import (
 	"log"
 	"net/http"
 	"strings"
+
+	"github.com/go-ldap/ldap/v3"
 )
 
 // For mock testing.
 var queryldapImportGroupFn = queryldap
 
+// Authentication of group imports. With UseToken, groups are imported with the access token,
+// and the UI login tokens are only a fallback if they are set.
+type GroupImportAuth struct {
+	Token        string
+	UseToken     bool
+	AccessToken  string
+	RefreshToken string
+}
+
 func ImportGroup(
 	client *http.Client,
 	baseurl string,
@@ -20,8 +31,7 @@ func ImportGroup(
 	groupname string,
 	ldapSettings []ArtifactoryLDAPSettings,
 	ldapGroupSettings []ArtifactoryLDAPGroupSettings,
-	accessToken string,
-	refreshToken string,
+	auth GroupImportAuth,
 	dryRun bool) (bool, error) {
 
 	if len(ldapSettings) == 0 {
@@ -47,23 +57,13 @@ func ImportGroup(
 		}
 		ldapSettingsSingle := ldapSettings[settingsIndex]
 
-		var basedn string
-		if strings.Count(ldapSettingsSingle.LdapUrl, "/") >= 3 {
-			parts := strings.SplitN(ldapSettingsSingle.LdapUrl, "/", 4)
-			if ldapGroupSettingsSingle.GroupBaseDn != "" {
-				basedn = fmt.Sprintf("%s,%s", ldapGroupSettingsSingle.GroupBaseDn, parts[3])
-			} else {
-				basedn = parts[3]
-			}
-		} else {
-			basedn = ldapGroupSettingsSingle.GroupBaseDn
-		}
+		basedn := getLdapBaseDn(ldapSettingsSingle.LdapUrl, ldapGroupSettingsSingle.GroupBaseDn)
 
 		var filter string
 		if ldapGroupSettingsSingle.Filter != "" {
-			filter = fmt.Sprintf("(&%s(%s=%s))", ldapGroupSettingsSingle.Filter, ldapGroupSettingsSingle.GroupNameAttribute, groupname)
+			filter = fmt.Sprintf("(&%s(%s=%s))", ldapGroupSettingsSingle.Filter, ldapGroupSettingsSingle.GroupNameAttribute, ldap.EscapeFilter(groupname))
 		} else {
-			filter = fmt.Sprintf("(%s=%s)", ldapGroupSettingsSingle.GroupNameAttribute, groupname)
+			filter = fmt.Sprintf("(%s=%s)", ldapGroupSettingsSingle.GroupNameAttribute, ldap.EscapeFilter(groupname))
 		}
 
 		entries, err := queryldapImportGroupFn(
@@ -109,7 +109,18 @@ func ImportGroup(
 			LdapGroupSettings: ldapGroupSettingsSingle,
 		}
 
-		err = importSingleGroup(client, baseurl, accessToken, refreshToken, groupname, importGroup, dryRun)
+		if auth.UseToken {
+			err = importSingleGroupWithToken(client, baseurl, auth.Token, groupname, importGroup, dryRun)
+			if err == nil {
+				return true, nil
+			}
+			if auth.AccessToken == "" || auth.RefreshToken == "" {
+				return false, fmt.Errorf("import failed: %w", err)
+			}
+			fmt.Printf("Importing group '%s' using access token failed, falling back to UI login: %v\n", groupname, err)
+		}
+
+		err = importSingleGroup(client, baseurl, auth.AccessToken, auth.RefreshToken, groupname, importGroup, dryRun)
 		if err != nil {
 			return false, fmt.Errorf("import failed: %w", err)
 		}
@@ -121,6 +132,77 @@ func ImportGroup(
 	return false, nil
 }
 
+func getLdapBaseDn(ldapUrl string, base string) string {
+	if strings.Count(ldapUrl, "/") >= 3 {
+		parts := strings.SplitN(ldapUrl, "/", 4)
+		if base != "" {
+			return fmt.Sprintf("%s,%s", base, parts[3])
+		}
+		return parts[3]
+	}
+	return base
+}
+
+// Creates the group as an ldap realm group through the access api, which accepts the bearer token.
+func importSingleGroupWithToken(
+	client *http.Client,
+	baseurl string,
+	token string,
+	groupname string,
+	groupimport ArtifactoryGroupImport,
+	dryRun bool) error {
+
+	fmt.Printf("Importing group: '%s' (access token)\n", groupname)
+
+	strategy := strings.ToUpper(groupimport.LdapGroupSettings.Strategy)
+	if strategy == "" {
+		strategy = "STATIC"
+	}
+
+	importGroup := groupimport.ImportGroups[0]
+	group := ArtifactoryGroupRequest{
+		Name:            groupname,
+		Description:     importGroup.Description,
+		AutoJoin:        false,
+		AdminPrivileges: false,
+		Realm:           "ldap",
+		RealmAttributes: fmt.Sprintf("ldapGroupName=%s;groupsStrategy=%s;groupDn=%s", groupname, strategy, importGroup.GroupDn),
+	}
+
+	payload, err := json.Marshal(group)
+	if err != nil {
+		return fmt.Errorf("error marshalling group: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/access/api/v2/groups", baseurl)
+
+	req, err := http.NewRequest("POST", url, strings.NewReader(string(payload)))
+	if err != nil {
+		return fmt.Errorf("error creating request for '%s': %w", url, err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	if !dryRun {
+		resp, err := doRequest(client, req)
+		if err != nil {
+			return fmt.Errorf("error sending request to '%s': %w", url, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			body, _ := io.ReadAll(resp.Body)
+
+			return fmt.Errorf("unexpected response from '%s': %s - %s", url, resp.Status, string(body))
+		}
+
+		log.Printf("Imported group '%s' via '%s' (status '%s', code %d)\n", groupname, url, resp.Status, resp.StatusCode)
+	}
+
+	return nil
+}
+
 func importSingleGroup(
 	client *http.Client,
 	baseurl string,
@@ -151,7 +233,7 @@ func importSingleGroup(
 	req.AddCookie(&http.Cookie{Name: "REFRESHTOKEN", Value: refreshToken})
 
 	if !dryRun {
-		resp, err := client.Do(req)
+		resp, err := doRequest(client, req)
 		if err != nil {
 			return fmt.Errorf("error sending request to '%s': %w", url, err)
 		}
@@ -190,7 +272,7 @@ func getUITokens(client *http.Client, baseurl string, username string, password
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Requested-With", "XMLHttpRequest")
 
-	resp, err := client.Do(req)
+	resp, err := doRequest(client, req)
 	if err != nil {
 		return "", "", fmt.Errorf("error sending request to '%s': %w", url, err)
 	}
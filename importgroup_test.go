@@ -83,9 +83,124 @@ func TestImportGroup(t *testing.T) {
 			return []*ldap.Entry{entry}, nil
 		}
 
-		_, err := ImportGroup(client, "", "", "", tc.groupName, tc.ldapSettings, tc.ldapGroupSettings, "", "", tc.dryRun)
+		_, err := ImportGroup(client, "", "", "", tc.groupName, tc.ldapSettings, tc.ldapGroupSettings, GroupImportAuth{AccessToken: "access-token", RefreshToken: "refresh-token"}, tc.dryRun)
 		if (tc.shouldErr && err == nil) || (!tc.shouldErr && err != nil) {
 			t.Errorf("ImportGroup (%d/%d): error = %v", i+1, len(tests), err)
 		}
 	}
 }
+
+func TestImportGroupEscapedFilter(t *testing.T) {
+	tests := []struct {
+		groupName  string
+		filter     string
+		wantFilter string
+	}{
+		{"team-x", "", "(cn=team-x)"},
+		{"*", "", `(cn=\2a)`},
+		{"team)(cn=*", "(objectClass=group)", `(&(objectClass=group)(cn=team\29\28cn=\2a))`},
+		{`back\slash`, "", `(cn=back\5cslash)`},
+	}
+
+	origQuery := queryldapImportGroupFn
+	defer func() { queryldapImportGroupFn = origQuery }()
+
+	for i, tc := range tests {
+		ldapSettings := []ArtifactoryLDAPSettings{{Key: "test-ldapsettings", LdapUrl: "ldap://ldap.example.com"}}
+		ldapGroupSettings := []ArtifactoryLDAPGroupSettings{
+			{
+				Name:                 "test-ldapgroupsettings",
+				EnabledLdap:          "test-ldapsettings",
+				GroupNameAttribute:   "cn",
+				Filter:               tc.filter,
+				DescriptionAttribute: "description",
+			},
+		}
+
+		var gotFilter string
+		queryldapImportGroupFn = func(server, baseDN, filter, bindDN, bindPW string, attrs []string) ([]*ldap.Entry, error) {
+			gotFilter = filter
+			return []*ldap.Entry{}, nil
+		}
+
+		_, err := ImportGroup(nil, "", "", "", tc.groupName, ldapSettings, ldapGroupSettings, GroupImportAuth{UseToken: true}, true)
+		if err != nil {
+			t.Errorf("ImportGroupEscapedFilter (%d/%d): error = %v", i+1, len(tests), err)
+		}
+		if gotFilter != tc.wantFilter {
+			t.Errorf("ImportGroupEscapedFilter (%d/%d): got filter '%s', want '%s'", i+1, len(tests), gotFilter, tc.wantFilter)
+		}
+	}
+}
+
+func TestImportGroupWithToken(t *testing.T) {
+	tests := []struct {
+		accessToken      string
+		refreshToken     string
+		tokenStatus      int
+		wantCookieImport bool
+		shouldErr        bool
+	}{
+		// Token import only
+		{"", "", 201, false, false},
+		// Token import fails, no UI tokens to fall back to
+		{"", "", 403, false, true},
+		// Token import fails, fall back to UI api
+		{"access-token", "refresh-token", 403, true, false},
+	}
+
+	ldapSettings := []ArtifactoryLDAPSettings{{Key: "test-ldapsettings", LdapUrl: "ldap://ldap.example.com"}}
+	ldapGroupSettings := []ArtifactoryLDAPGroupSettings{
+		{
+			Name:                 "test-ldapgroupsettings",
+			EnabledLdap:          "test-ldapsettings",
+			GroupNameAttribute:   "cn",
+			DescriptionAttribute: "description",
+			Strategy:             "static",
+		},
+	}
+
+	origQuery := queryldapImportGroupFn
+	defer func() { queryldapImportGroupFn = origQuery }()
+
+	queryldapImportGroupFn = func(server, baseDN, filter, bindDN, bindPW string, attrs []string) ([]*ldap.Entry, error) {
+		entry := &ldap.Entry{DN: "cn=test-group,dc=example,dc=org"}
+		entry.Attributes = []*ldap.EntryAttribute{{Name: attrs[0], Values: []string{"Test description"}}}
+		return []*ldap.Entry{entry}, nil
+	}
+
+	for i, tc := range tests {
+		cookieImport := false
+
+		client := mockHTTPClient(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == "/access/api/v2/groups" && req.Method == "POST" {
+				if req.Header.Get("Authorization") != "Bearer token" {
+					t.Errorf("ImportGroupWithToken (%d/%d): missing bearer token", i+1, len(tests))
+				}
+				body, _ := io.ReadAll(req.Body)
+				wantAttributes := `"realm_attributes":"ldapGroupName=test-group;groupsStrategy=STATIC;groupDn=cn=test-group,dc=example,dc=org"`
+				if !strings.Contains(string(body), wantAttributes) || !strings.Contains(string(body), `"realm":"ldap"`) {
+					t.Errorf("ImportGroupWithToken (%d/%d): unexpected body: '%s'", i+1, len(tests), body)
+				}
+				return &http.Response{StatusCode: tc.tokenStatus, Status: http.StatusText(tc.tokenStatus), Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+			}
+			if req.URL.Path == "/ui/api/v1/access/api/ui/ldap/groups/import" && req.Method == "POST" {
+				cookieImport = true
+				return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+			}
+			t.Errorf("ImportGroupWithToken (%d/%d): unexpected request: %s %s", i+1, len(tests), req.Method, req.URL.Path)
+			return &http.Response{StatusCode: 400, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+		})
+
+		imported, err := ImportGroup(client, "", "", "", "test-group", ldapSettings, ldapGroupSettings, GroupImportAuth{Token: "token", UseToken: true, AccessToken: tc.accessToken, RefreshToken: tc.refreshToken}, false)
+		if (tc.shouldErr && err == nil) || (!tc.shouldErr && err != nil) {
+			t.Errorf("ImportGroupWithToken (%d/%d): shouldErr: %t, error = %v", i+1, len(tests), tc.shouldErr, err)
+		}
+		if !tc.shouldErr && !imported {
+			t.Errorf("ImportGroupWithToken (%d/%d): group not imported", i+1, len(tests))
+		}
+		if cookieImport != tc.wantCookieImport {
+			t.Errorf("ImportGroupWithToken (%d/%d): got cookie import %t, want %t", i+1, len(tests), cookieImport, tc.wantCookieImport)
+		}
+	}
+}
@@ -3,28 +3,141 @@ package main
 import (
 	"fmt"
 	"log"
+	"sync"
 
 	"github.com/go-ldap/ldap/v3"
 )
 
-func queryldap(server, baseDN, filter, bindDN, bindPW string, attrs []string) ([]*ldap.Entry, error) {
-	log.Printf("server: '%s', baseDN: '%s', filter: '%s', bindDN: '%s'\n", server, baseDN, filter, bindDN)
+const ldapPageSize = 500
+
+type ldapConnectionPool struct {
+	mutex       sync.Mutex
+	connections map[string]*ldap.Conn
+	paging      map[string]bool
+}
+
+// Connections are reused for the whole run, and closed at the end of Provision.
+var ldapConnections = newLdapConnectionPool()
+
+func newLdapConnectionPool() *ldapConnectionPool {
+	return &ldapConnectionPool{
+		connections: make(map[string]*ldap.Conn),
+		paging:      make(map[string]bool),
+	}
+}
+
+func (p *ldapConnectionPool) SetPagingSupport(ldapSettings []ArtifactoryLDAPSettings) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, settings := range ldapSettings {
+		p.paging[settings.LdapUrl] = settings.PagingSupportEnabled
+	}
+}
+
+func (p *ldapConnectionPool) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 
-	ldapconn, err := ldap.DialURL(server)
+	for key, conn := range p.connections {
+		conn.Close()
+		delete(p.connections, key)
+	}
+
+	log.Printf("Closed all ldap connections.\n")
+}
+
+func (p *ldapConnectionPool) get(server, bindDN, bindPW string) (*ldap.Conn, error) {
+	key := server + "\x00" + bindDN
+
+	if conn, ok := p.connections[key]; ok && !conn.IsClosing() {
+		return conn, nil
+	}
+
+	log.Printf("Connecting to ldap server: '%s', bindDN: '%s'\n", server, bindDN)
+
+	conn, err := ldap.DialURL(server)
 	if err != nil {
 		return nil, fmt.Errorf("failed to dial LDAP: %w", err)
 	}
-	defer ldapconn.Close()
 
 	if bindDN != "" {
-		if err = ldapconn.Bind(bindDN, bindPW); err != nil {
+		if err = conn.Bind(bindDN, bindPW); err != nil {
+			conn.Close()
 			return nil, fmt.Errorf("bind failed: %w", err)
 		}
 	}
 
+	p.connections[key] = conn
+
+	return conn, nil
+}
+
+func (p *ldapConnectionPool) drop(server, bindDN string) {
+	key := server + "\x00" + bindDN
+
+	if conn, ok := p.connections[key]; ok {
+		conn.Close()
+		delete(p.connections, key)
+	}
+}
+
+func (p *ldapConnectionPool) search(server, bindDN, bindPW string, searchReq *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var lastErr error
+
+	for attempt := range 2 {
+		conn, err := p.get(server, bindDN, bindPW)
+		if err != nil {
+			return nil, err
+		}
+
+		var sr *ldap.SearchResult
+		if p.paging[server] {
+			sr, err = conn.SearchWithPaging(searchReq, ldapPageSize)
+		} else {
+			sr, err = conn.Search(searchReq)
+		}
+		if err == nil {
+			return sr, nil
+		}
+
+		lastErr = err
+		if !ldap.IsErrorWithCode(err, ldap.ErrorNetwork) && !conn.IsClosing() {
+			break
+		}
+
+		log.Printf("Ldap connection failed (attempt %d), reconnecting: %v\n", attempt+1, err)
+		p.drop(server, bindDN)
+	}
+
+	return nil, fmt.Errorf("search failed: %w", lastErr)
+}
+
+func queryldap(server, baseDN, filter, bindDN, bindPW string, attrs []string) ([]*ldap.Entry, error) {
+	return searchldap(server, baseDN, ldap.ScopeWholeSubtree, filter, bindDN, bindPW, attrs)
+}
+
+// Reads the single entry of a DN, or no entry if it doesn't exist.
+func queryldapEntry(server, dn, bindDN, bindPW string, attrs []string) (*ldap.Entry, error) {
+	entries, err := searchldap(server, dn, ldap.ScopeBaseObject, "(objectClass=*)", bindDN, bindPW, attrs)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, nil
+	}
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	return entries[0], nil
+}
+
+func searchldap(server, baseDN string, scope int, filter, bindDN, bindPW string, attrs []string) ([]*ldap.Entry, error) {
+	log.Printf("server: '%s', baseDN: '%s', filter: '%s', bindDN: '%s'\n", server, baseDN, filter, bindDN)
+
 	searchReq := ldap.NewSearchRequest(
 		baseDN,
-		ldap.ScopeWholeSubtree,
+		scope,
 		ldap.NeverDerefAliases,
 		0,
 		0,
@@ -34,9 +147,9 @@ func queryldap(server, baseDN, filter, bindDN, bindPW string, attrs []string) ([
 		nil,
 	)
 
-	sr, err := ldapconn.Search(searchReq)
+	sr, err := ldapConnections.search(server, bindDN, bindPW, searchReq)
 	if err != nil {
-		return nil, fmt.Errorf("search failed: %w", err)
+		return nil, err
 	}
 
 	log.Printf("Got %d entries, %d controls, %d referrals\n", len(sr.Entries), len(sr.Controls), len(sr.Referrals))
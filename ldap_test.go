@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLdapConnectionPoolPaging(t *testing.T) {
+	pool := newLdapConnectionPool()
+	pool.SetPagingSupport([]ArtifactoryLDAPSettings{
+		{Key: "paged", LdapUrl: "ldap://paged.example.com/dc=example,dc=com", PagingSupportEnabled: true},
+		{Key: "unpaged", LdapUrl: "ldap://unpaged.example.com/dc=example,dc=com", PagingSupportEnabled: false},
+	})
+
+	if !pool.paging["ldap://paged.example.com/dc=example,dc=com"] {
+		t.Errorf("LdapConnectionPoolPaging: paging not enabled for paged server")
+	}
+	if pool.paging["ldap://unpaged.example.com/dc=example,dc=com"] {
+		t.Errorf("LdapConnectionPoolPaging: paging enabled for unpaged server")
+	}
+
+	pool.Close()
+	if len(pool.connections) != 0 {
+		t.Errorf("LdapConnectionPoolPaging: got %d connections after close, want 0", len(pool.connections))
+	}
+}
+
+func TestLdapConnectionPoolDialFailure(t *testing.T) {
+	pool := newLdapConnectionPool()
+	defer pool.Close()
+
+	_, err := pool.get("ldap://127.0.0.1:1", "", "")
+	if err == nil {
+		t.Errorf("LdapConnectionPoolDialFailure: expected error when dialing closed port")
+	}
+	if len(pool.connections) != 0 {
+		t.Errorf("LdapConnectionPoolDialFailure: got %d cached connections, want 0", len(pool.connections))
+	}
+}
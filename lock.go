@@ -0,0 +1,350 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const lockItemName = "artsync.lock"
+
+var ErrLocked = errors.New("locked by another run")
+var ErrLockLost = errors.New("lock is no longer held by this run")
+
+// Time between writing and re-reading the lock when acquiring it. A concurrent run that read
+// the lock before it was written must write its own lock within this time, and then the last
+// writer holds the lock and the others back off.
+var lockSettleDelay = 2 * time.Second
+
+// Contents of the lock item in the lock repo.
+type LockInfo struct {
+	Owner     string    `json:"owner"`
+	RunID     string    `json:"runId"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (l LockInfo) IsExpired() bool {
+	return time.Now().After(l.ExpiresAt)
+}
+
+func (l LockInfo) String() string {
+	return fmt.Sprintf("owner '%s', run id '%s', created %s, expires %s",
+		l.Owner, l.RunID, l.CreatedAt.Local().Format(time.DateTime), l.ExpiresAt.Local().Format(time.DateTime))
+}
+
+// Advisory lock held by this run. Stored as an item in a generic repo in Artifactory,
+// so that runs on different machines see each other.
+type Lock struct {
+	Info LockInfo
+
+	client  *http.Client
+	baseurl string
+	token   string
+	repo    string
+	ttl     time.Duration
+
+	mutex sync.Mutex
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// Acquires the lock, taking over an expired lock. Fails with ErrLocked if another run holds it.
+func AcquireLock(client *http.Client, baseurl string, token string, lockRepo string, ttl time.Duration) (*Lock, error) {
+	existing, err := GetLock(client, baseurl, token, lockRepo)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		if !existing.IsExpired() {
+			return nil, fmt.Errorf("%w: %s", ErrLocked, existing)
+		}
+		fmt.Printf("Warning: Taking over expired lock: %s\n", existing)
+	}
+
+	lock, err := writeLock(client, baseurl, token, lockRepo, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	lock.stop = make(chan struct{})
+	lock.done = make(chan struct{})
+	go lock.keepAlive(ttl / 3)
+
+	return lock, nil
+}
+
+// Removes a stale lock, so that the next run can acquire it. The lock must be expired unless
+// force is set. No lock is written, it would block other runs without a run releasing it.
+func TakeoverLock(client *http.Client, baseurl string, token string, lockRepo string, force bool) error {
+	return removeLock(client, baseurl, token, lockRepo, force)
+}
+
+func writeLock(client *http.Client, baseurl string, token string, lockRepo string, ttl time.Duration) (*Lock, error) {
+	now := time.Now().UTC()
+	lock := &Lock{
+		Info: LockInfo{
+			Owner:     getLockOwner(),
+			RunID:     newRunID(),
+			CreatedAt: now,
+			ExpiresAt: now.Add(ttl),
+		},
+		client:  client,
+		baseurl: baseurl,
+		token:   token,
+		repo:    lockRepo,
+		ttl:     ttl,
+	}
+
+	data, err := json.MarshalIndent(lock.Info, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error generating json: %w", err)
+	}
+
+	err = lockRequest(client, token, "PUT", getLockUrl(baseurl, lockRepo), string(data))
+	if err != nil {
+		return nil, fmt.Errorf("error writing lock: %w", err)
+	}
+
+	// Artifactory has no conditional deploy, so check that no concurrent run wrote its lock
+	// after this one.
+	sleepFn(lockSettleDelay)
+
+	err = lock.Check()
+	if errors.Is(err, ErrLockLost) {
+		return nil, fmt.Errorf("%w, while acquiring: %w", ErrLocked, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// Verifies that this run still holds the lock, before changing Artifactory.
+func (l *Lock) Check() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	runID := l.Info.RunID
+	l.mutex.Unlock()
+
+	current, err := GetLock(l.client, l.baseurl, l.token, l.repo)
+	if err != nil {
+		return fmt.Errorf("error checking lock: %w", err)
+	}
+	if current == nil {
+		return fmt.Errorf("%w, it was removed", ErrLockLost)
+	}
+	if current.RunID != runID {
+		return fmt.Errorf("%w, it was taken over: %s", ErrLockLost, current)
+	}
+
+	return nil
+}
+
+// Extends the expiry of the lock, so that long runs aren't taken over.
+func (l *Lock) refresh() error {
+	err := l.Check()
+	if err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	info := l.Info
+	l.mutex.Unlock()
+	info.ExpiresAt = time.Now().UTC().Add(l.ttl)
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error generating json: %w", err)
+	}
+
+	err = lockRequest(l.client, l.token, "PUT", getLockUrl(l.baseurl, l.repo), string(data))
+	if err != nil {
+		return fmt.Errorf("error writing lock: %w", err)
+	}
+
+	l.mutex.Lock()
+	l.Info = info
+	l.mutex.Unlock()
+
+	return nil
+}
+
+func (l *Lock) keepAlive(interval time.Duration) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			err := l.refresh()
+			if errors.Is(err, ErrLockLost) {
+				fmt.Printf("Warning: Not refreshing lock: %v\n", err)
+				return
+			}
+			if err != nil {
+				fmt.Printf("Warning: Couldn't refresh lock: %v\n", err)
+			}
+		}
+	}
+}
+
+// Releases the lock, unless another run has taken it over in the meantime.
+func (l *Lock) Release() {
+	if l == nil {
+		return
+	}
+
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+		l.stop = nil
+	}
+
+	current, err := GetLock(l.client, l.baseurl, l.token, l.repo)
+	if err != nil {
+		fmt.Printf("Warning: Couldn't release lock: %v\n", err)
+		return
+	}
+	if current == nil {
+		fmt.Println("Warning: Lock was removed by someone else while running.")
+		return
+	}
+	if current.RunID != l.Info.RunID {
+		fmt.Printf("Warning: Lock was taken over while running, not releasing it: %s\n", current)
+		return
+	}
+
+	err = lockRequest(l.client, l.token, "DELETE", getLockUrl(l.baseurl, l.repo), "")
+	if err != nil {
+		fmt.Printf("Warning: Couldn't release lock: %v\n", err)
+	}
+}
+
+// Returns the current lock, or nil if there is none.
+func GetLock(client *http.Client, baseurl string, token string, lockRepo string) (*LockInfo, error) {
+	req, err := http.NewRequest("GET", getLockUrl(baseurl, lockRepo), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := doRequest(client, req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != 200 {
+		return nil, newHTTPStatusError(req, resp, body)
+	}
+
+	var info LockInfo
+	err = json.Unmarshal(body, &info)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing lock: %w", err)
+	}
+
+	return &info, nil
+}
+
+// Removes the lock regardless of its owner.
+func ForceUnlock(client *http.Client, baseurl string, token string, lockRepo string) error {
+	return removeLock(client, baseurl, token, lockRepo, true)
+}
+
+func removeLock(client *http.Client, baseurl string, token string, lockRepo string, force bool) error {
+	existing, err := GetLock(client, baseurl, token, lockRepo)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		fmt.Println("Not locked.")
+		return nil
+	}
+	if !existing.IsExpired() && !force {
+		return fmt.Errorf("%w, not expired: %s", ErrLocked, existing)
+	}
+
+	err = lockRequest(client, token, "DELETE", getLockUrl(baseurl, lockRepo), "")
+	if err != nil {
+		return fmt.Errorf("error removing lock: %w", err)
+	}
+
+	fmt.Printf("Removed lock: %s\n", existing)
+
+	return nil
+}
+
+func lockRequest(client *http.Client, token string, method string, url string, body string) error {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := doRequest(client, req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newHTTPStatusError(req, resp, respBody)
+	}
+
+	return nil
+}
+
+func getLockUrl(baseurl string, lockRepo string) string {
+	return fmt.Sprintf("%s/artifactory/%s/%s", baseurl, url.PathEscape(lockRepo), lockItemName)
+}
+
+func getLockOwner() string {
+	if owner := os.Getenv("ARTSYNC_LOCK_OWNER"); owner != "" {
+		return owner
+	}
+
+	username := os.Getenv("USER")
+	if username == "" {
+		username = os.Getenv("USERNAME")
+	}
+	hostname, _ := os.Hostname()
+
+	return fmt.Sprintf("%s@%s", username, hostname)
+}
+
+func newRunID() string {
+	data := make([]byte, 8)
+	rand.Read(data)
+	return hex.EncodeToString(data)
+}
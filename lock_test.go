@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Mock client keeping the lock item in memory.
+func mockLockClient(item *string) *http.Client {
+	var mutex sync.Mutex
+	return mockHTTPClient(func(req *http.Request) (*http.Response, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		status := 200
+		body := ""
+		switch req.Method {
+		case "GET":
+			if *item == "" {
+				status = 404
+			}
+			body = *item
+		case "PUT":
+			data, _ := io.ReadAll(req.Body)
+			*item = string(data)
+			status = 201
+		case "DELETE":
+			*item = ""
+			status = 204
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+	})
+}
+
+func lockItem(expiresAt time.Time) string {
+	data, _ := json.Marshal(LockInfo{Owner: "other@host", RunID: "other", CreatedAt: expiresAt.Add(-time.Hour), ExpiresAt: expiresAt})
+	return string(data)
+}
+
+func TestAcquireLock(t *testing.T) {
+	origSleep := sleepFn
+	defer func() { sleepFn = origSleep }()
+	sleepFn = func(time.Duration) {}
+
+	tests := []struct {
+		Name      string
+		Existing  string
+		ExpectErr bool
+	}{
+		{"Not locked", "", false},
+		{"Locked", lockItem(time.Now().Add(time.Hour)), true},
+		{"Expired lock", lockItem(time.Now().Add(-time.Minute)), false},
+	}
+
+	for i, test := range tests {
+		item := test.Existing
+		client := mockLockClient(&item)
+
+		lock, err := AcquireLock(client, "", "", "locks", time.Hour)
+		if test.ExpectErr {
+			if !errors.Is(err, ErrLocked) {
+				t.Errorf("%s (%d/%d): expected ErrLocked, got %v", test.Name, i+1, len(tests), err)
+			}
+			if item != test.Existing {
+				t.Errorf("%s (%d/%d): lock was overwritten", test.Name, i+1, len(tests))
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s (%d/%d): error = %v", test.Name, i+1, len(tests), err)
+		}
+
+		info, _ := GetLock(client, "", "", "locks")
+		if info == nil || info.RunID != lock.Info.RunID {
+			t.Errorf("%s (%d/%d): lock not written, got %v", test.Name, i+1, len(tests), info)
+		}
+
+		lock.Release()
+		if item != "" {
+			t.Errorf("%s (%d/%d): lock not released", test.Name, i+1, len(tests))
+		}
+	}
+}
+
+func TestReleaseTakenOverLock(t *testing.T) {
+	origSleep := sleepFn
+	defer func() { sleepFn = origSleep }()
+	sleepFn = func(time.Duration) {}
+
+	item := ""
+	client := mockLockClient(&item)
+
+	lock, err := AcquireLock(client, "", "", "locks", time.Hour)
+	if err != nil {
+		t.Fatalf("ReleaseTakenOverLock: error = %v", err)
+	}
+
+	if err := TakeoverLock(client, "", "", "locks", false); !errors.Is(err, ErrLocked) {
+		t.Errorf("ReleaseTakenOverLock: takeover of lock that isn't expired, got %v", err)
+	}
+	if err := TakeoverLock(client, "", "", "locks", true); err != nil {
+		t.Fatalf("ReleaseTakenOverLock: forced takeover error = %v", err)
+	}
+	if item != "" {
+		t.Errorf("ReleaseTakenOverLock: forced takeover didn't remove the lock, got %s", item)
+	}
+
+	// The next run acquires the lock.
+	takenOver, err := AcquireLock(client, "", "", "locks", time.Hour)
+	if err != nil {
+		t.Fatalf("ReleaseTakenOverLock: acquire after takeover error = %v", err)
+	}
+	defer takenOver.Release()
+
+	lock.Release()
+
+	info, _ := GetLock(client, "", "", "locks")
+	if info == nil || info.RunID != takenOver.Info.RunID {
+		t.Errorf("ReleaseTakenOverLock: lock of other run released, got %v", info)
+	}
+}
+
+func TestTakeoverExpiredLock(t *testing.T) {
+	item := lockItem(time.Now().Add(-time.Minute))
+	client := mockLockClient(&item)
+
+	err := TakeoverLock(client, "", "", "locks", false)
+	if err != nil {
+		t.Fatalf("TakeoverExpiredLock: error = %v", err)
+	}
+	if item != "" {
+		t.Errorf("TakeoverExpiredLock: expired lock not removed, got %s", item)
+	}
+}
+
+func TestAcquireLockConcurrentWrite(t *testing.T) {
+	origSleep := sleepFn
+	defer func() { sleepFn = origSleep }()
+
+	item := ""
+	client := mockLockClient(&item)
+
+	// Another run writes its lock after this one, while waiting to check it.
+	other := lockItem(time.Now().Add(time.Hour))
+	sleepFn = func(time.Duration) { item = other }
+
+	lock, err := AcquireLock(client, "", "", "locks", time.Hour)
+	if !errors.Is(err, ErrLocked) || !errors.Is(err, ErrLockLost) {
+		t.Errorf("AcquireLockConcurrentWrite: expected ErrLocked, got %v", err)
+	}
+	if lock != nil {
+		lock.Release()
+	}
+	if item != other {
+		t.Errorf("AcquireLockConcurrentWrite: lock of other run was changed, got %s", item)
+	}
+}
+
+func TestRefreshLock(t *testing.T) {
+	origSleep := sleepFn
+	defer func() { sleepFn = origSleep }()
+	sleepFn = func(time.Duration) {}
+
+	item := ""
+	client := mockLockClient(&item)
+
+	lock, err := writeLock(client, "", "", "locks", time.Hour)
+	if err != nil {
+		t.Fatalf("RefreshLock: error = %v", err)
+	}
+	lock.Info.ExpiresAt = time.Now().Add(time.Minute)
+
+	err = lock.refresh()
+	if err != nil {
+		t.Fatalf("RefreshLock: refresh error = %v", err)
+	}
+	info, _ := GetLock(client, "", "", "locks")
+	if info == nil || time.Until(info.ExpiresAt) < 59*time.Minute || info.RunID != lock.Info.RunID {
+		t.Errorf("RefreshLock: expiry not extended, got %v", info)
+	}
+
+	item = lockItem(time.Now().Add(time.Hour))
+	if err := lock.refresh(); !errors.Is(err, ErrLockLost) {
+		t.Errorf("RefreshLock: expected ErrLockLost after takeover, got %v", err)
+	}
+	if err := lock.Check(); !errors.Is(err, ErrLockLost) {
+		t.Errorf("RefreshLock: expected ErrLockLost from Check after takeover, got %v", err)
+	}
+}
+
+func TestProvisionLostLock(t *testing.T) {
+	origSleep := sleepFn
+	defer func() { sleepFn = origSleep }()
+	sleepFn = func(time.Duration) {}
+
+	item := ""
+	client := mockLockClient(&item)
+
+	lock, err := writeLock(client, "", "", "locks", time.Hour)
+	if err != nil {
+		t.Fatalf("ProvisionLostLock: error = %v", err)
+	}
+	item = lockItem(time.Now().Add(time.Hour))
+
+	err = Provision(client, "", "", []Repo{{Name: "test-repo"}}, nil, nil, nil, nil, false, false, LdapConfig{}, PropertiesConfig{}, nil, nil, lock, 1, false)
+	if !errors.Is(err, ErrLockLost) {
+		t.Errorf("ProvisionLostLock: expected ErrLockLost, got %v", err)
+	}
+}
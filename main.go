@@ -3,13 +3,17 @@ package main
 import (
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -24,6 +28,22 @@ func main() {
 
 	log.SetOutput(f)
 
+	if len(os.Args) > 1 && os.Args[1] == "cache" {
+		os.Exit(runCacheCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "snapshot" {
+		os.Exit(runSnapshotCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "drift" {
+		os.Exit(runDriftCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rollback" {
+		os.Exit(runRollbackCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "lock" {
+		os.Exit(runLockCommand(os.Args[2:]))
+	}
+
 	useAllPermissionTargetsAsSourceFlag := flag.Bool("a", false, "Use all permission targets as source, when generating.")
 	combineReposFlag := flag.Bool("c", false, "Combine identical repos, when generating.")
 	dryRunFlag := flag.Bool("d", false, "Enable dry run mode (read-only, no changes will be made).")
@@ -31,7 +51,7 @@ func main() {
 	showDiffFlag := flag.Bool("f", false, "Show json diff, when applying permission targets.")
 	generateFlag := flag.Bool("g", false, "Generate repo file.")
 	useCacheFlag := flag.Bool("h", false, "Use local cache folder instead of Artifactory api, when retrieving.")
-	propertiesConfigFilenameString := flag.String("i", "", "Write properties to Artifactory, configuration file.")
+	propertiesConfigFilenameString := flag.String("i", "", "Write properties to Artifactory, configuration file. When generating, the properties are read back as extra fields.")
 	generatejsonFlag := flag.Bool("j", false, "Generate output in json format.")
 	ignoreCertFlag := flag.Bool("k", false, "Ignore https cert validation errors.")
 	importUsersAndGroupsFilenameString := flag.String("l", "", "Import missing users and groups from ldap, configuration file.")
@@ -39,8 +59,36 @@ func main() {
 	allowpatternsFlag := flag.Bool("p", false, "Allow permission targets include/exclude patterns, when provisioning. This will delete all custom filters.")
 	onlyGenerateCleanReposFlag := flag.Bool("q", false, "Only generate repos whose permission targets are default, i.e. without any include/exclude patterns.")
 	allowRenamedPermissionsFlag := flag.Bool("r", false, "Allow non-conventional permission target names, when generating.")
-	splitFlag := flag.Bool("s", false, "Split into one file for each repo, when generating. Uses specified repofile as subfolder. Ignores combine flag.")
+	splitFlag := flag.Bool("s", false, "Split into one file for each repo, when generating. Uses specified repofile as subfolder. Repos are combined within each file.")
+	terraformFlag := flag.Bool("t", false, "Generate output in terraform format, resources and import blocks for the JFrog artifactory and platform providers.")
 	overwriteFlag := flag.Bool("w", false, "Allow overwriting of existing repo file, when generating.")
+	includeString := flag.String("include", "", "Comma separated glob patterns of repo names to generate, e.g. 'team-x-*'.")
+	excludeString := flag.String("exclude", "", "Comma separated glob patterns of repo names not to generate.")
+	includeRegexString := flag.String("include-regex", "", "Regular expression that repo names must match, when generating.")
+	packageTypeString := flag.String("package-type", "", "Comma separated package types to generate, e.g. docker,maven.")
+	rclassString := flag.String("rclass", "", "Comma separated repo classes to generate: local, remote, virtual, federated.")
+	principalFilterString := flag.String("principal-filter", "", "Only generate repos where a user or group has a permission: [user:|group:]name[:permission], e.g. group:team-x:MANAGE.")
+	splitTemplateString := flag.String("split-template", "", "Path of split files, when using -s. Placeholders: {name}, {prefix}, {rclass}, {packageType}, {layout} and extra fields, e.g. '{packageType}/{rclass}/{name}.yaml'.")
+	repoIncludeString := flag.String("repo-include", strings.Join(repoFileIncludes, ","), "Comma separated file name patterns of repo files, when repo files are folders.")
+	principalsFileString := flag.String("principals-file", "", "File with users, groups and group members. Generated when using -g, otherwise provisioned before the repos. Passwords are not included.")
+	analyzeFlag := flag.Bool("analyze", false, "Report user permissions also given by group membership, and permission sets matching a standard role. Without -g, the repo files are analyzed instead of provisioned.")
+	compactFlag := flag.Bool("compact", false, "Remove user permissions also given by group membership, when generating.")
+	rolesString := flag.String("roles", "", "Role definitions, json file like [{\"name\": \"deployer\", \"actions\": [\"read\", \"annotate\", \"write\"]}]. Replaces or adds to the standard roles reader, deployer, maintainer and admin.")
+	emitRolesFlag := flag.Bool("emit-roles", false, "Generate users and groups whose permissions match a role as role fields, e.g. 'deployers: [team-x]'.")
+	mergeFlag := flag.Bool("merge", false, "Merge into existing yaml repo file, when generating. Keeps comments, ordering and extra fields, appends new repos and marks removed repos.")
+	retriesInt := flag.Int("retries", retryConfig.MaxRetries, "Max number of retries for failed Artifactory requests.")
+	concurrencyInt := flag.Int("concurrency", 4, "Number of concurrent requests, when retrieving repo and permission target details.")
+	provisionConcurrencyInt := flag.Int("provision-concurrency", 1, "Number of repos to provision concurrently. Virtual repos are provisioned after their members.")
+	requestsPerSecondFloat := flag.Float64("rps", 0, "Max number of Artifactory requests per second, 0 means unlimited.")
+	backupDirString := flag.String("backup-dir", "", "Folder for backups of changed repos and permission targets, for rollback. Default is backups/<timestamp>.")
+	lockRepoString := flag.String("lock-repo", "", "Generic repo for a lock item preventing concurrent runs against the same instance, when provisioning.")
+	lockTTLString := flag.String("lock-ttl", "1h", "Time after which a lock is considered stale and can be taken over.")
+	fromSnapshotString := flag.String("from-snapshot", "", "Use snapshot file instead of Artifactory api, fully offline. Implies dry run, only repo files are given as arguments.")
+	cacheDirString := flag.String("cache-dir", "cache", "Folder for cached data, with one subfolder for each Artifactory instance.")
+	cacheMaxAgeString := flag.String("cache-max-age", "", "Max age of cached data, e.g. 30m or 12h, when using -h. Older data is retrieved again.")
+	incrementalFlag := flag.Bool("incremental", false, "Only retrieve details of new repos and permission targets, and revalidate a sample of the cached ones.")
+	revalidateSampleInt := flag.Int("revalidate-sample", 10, "Number of cached repos and permission targets to revalidate, when using -incremental. Oldest first.")
+	cacheInvalidateString := flag.String("cache-invalidate", "", "Comma separated resource types to retrieve again, when using -h: all, repos, permissions, users, groups, ldap.")
 	flag.Parse()
 
 	visitedFlags := make(map[string]bool)
@@ -64,17 +112,164 @@ func main() {
 	onlyGenerateCleanRepos := getFlagEnv(*onlyGenerateCleanReposFlag, "ARTSYNC_ONLY_GENERATE_CLEAN_REPOS", visitedFlags["q"])
 	allowRenamedPermissions := getFlagEnv(*allowRenamedPermissionsFlag, "ARTSYNC_ALLOW_RENAMED_PERMISSIONS", visitedFlags["r"])
 	split := getFlagEnv(*splitFlag, "ARTSYNC_SPLIT", visitedFlags["s"])
+	terraform := getFlagEnv(*terraformFlag, "ARTSYNC_GENERATE_TERRAFORM", visitedFlags["t"])
 	overwrite := getFlagEnv(*overwriteFlag, "ARTSYNC_OVERWRITE", visitedFlags["w"])
+	splitTemplate := getStringEnv(*splitTemplateString, "ARTSYNC_SPLIT_TEMPLATE", visitedFlags["split-template"])
+	if splitTemplate != "" {
+		if err := ValidateSplitTemplate(splitTemplate); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	repoFileIncludes = splitList(getStringEnv(*repoIncludeString, "ARTSYNC_REPO_INCLUDE", visitedFlags["repo-include"]))
+	if err := validateGlobPatterns(repoFileIncludes); err != nil {
+		fmt.Printf("Error: Invalid value for -repo-include: %v\n", err)
+		os.Exit(1)
+	}
+	principalsFile := getStringEnv(*principalsFileString, "ARTSYNC_PRINCIPALS_FILE", visitedFlags["principals-file"])
+	analyze := getFlagEnv(*analyzeFlag, "ARTSYNC_ANALYZE", visitedFlags["analyze"])
+	compact := getFlagEnv(*compactFlag, "ARTSYNC_COMPACT", visitedFlags["compact"])
+	if rolesFilename := getStringEnv(*rolesString, "ARTSYNC_ROLES", visitedFlags["roles"]); rolesFilename != "" {
+		repoRoles, err = LoadRoles(rolesFilename)
+		if err != nil {
+			fmt.Printf("Error reading roles: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	emitRoles := getFlagEnv(*emitRolesFlag, "ARTSYNC_EMIT_ROLES", visitedFlags["emit-roles"])
+	merge := getFlagEnv(*mergeFlag, "ARTSYNC_MERGE", visitedFlags["merge"])
+	retries := getIntEnv(*retriesInt, "ARTSYNC_RETRIES", visitedFlags["retries"])
+
+	if retries < 0 {
+		fmt.Println("Error: -retries must not be negative.")
+		os.Exit(1)
+	}
+	retryConfig.MaxRetries = retries
+
+	concurrency := getIntEnv(*concurrencyInt, "ARTSYNC_CONCURRENCY", visitedFlags["concurrency"])
+	if concurrency < 1 {
+		fmt.Println("Error: -concurrency must be at least 1.")
+		os.Exit(1)
+	}
+
+	provisionConcurrency := getIntEnv(*provisionConcurrencyInt, "ARTSYNC_PROVISION_CONCURRENCY", visitedFlags["provision-concurrency"])
+	if provisionConcurrency < 1 {
+		fmt.Println("Error: -provision-concurrency must be at least 1.")
+		os.Exit(1)
+	}
+
+	requestsPerSecond := getFloatEnv(*requestsPerSecondFloat, "ARTSYNC_RPS", visitedFlags["rps"])
+	if requestsPerSecond < 0 {
+		fmt.Println("Error: -rps must not be negative.")
+		os.Exit(1)
+	}
+	requestLimiter = newRateLimiter(requestsPerSecond)
+
+	cacheDir := getStringEnv(*cacheDirString, "ARTSYNC_CACHE_DIR", visitedFlags["cache-dir"])
+
+	var cacheMaxAge time.Duration
+	cacheMaxAgeStr := getStringEnv(*cacheMaxAgeString, "ARTSYNC_CACHE_MAX_AGE", visitedFlags["cache-max-age"])
+	if cacheMaxAgeStr != "" {
+		cacheMaxAge, err = time.ParseDuration(cacheMaxAgeStr)
+		if err != nil || cacheMaxAge < 0 {
+			fmt.Printf("Error: Invalid value for -cache-max-age: '%s'\n", cacheMaxAgeStr)
+			os.Exit(1)
+		}
+	}
+
+	incremental := getFlagEnv(*incrementalFlag, "ARTSYNC_INCREMENTAL", visitedFlags["incremental"])
+	revalidateSample := getIntEnv(*revalidateSampleInt, "ARTSYNC_REVALIDATE_SAMPLE", visitedFlags["revalidate-sample"])
+	if revalidateSample < 0 {
+		fmt.Println("Error: -revalidate-sample must not be negative.")
+		os.Exit(1)
+	}
+
+	cacheInvalidate := splitList(getStringEnv(*cacheInvalidateString, "ARTSYNC_CACHE_INVALIDATE", visitedFlags["cache-invalidate"]))
+
+	generateFilter := GenerateFilter{
+		Include:      splitList(getStringEnv(*includeString, "ARTSYNC_INCLUDE", visitedFlags["include"])),
+		Exclude:      splitList(getStringEnv(*excludeString, "ARTSYNC_EXCLUDE", visitedFlags["exclude"])),
+		PackageTypes: splitList(getStringEnv(*packageTypeString, "ARTSYNC_PACKAGE_TYPE", visitedFlags["package-type"])),
+		Rclasses:     splitList(getStringEnv(*rclassString, "ARTSYNC_RCLASS", visitedFlags["rclass"])),
+	}
+	if err := validateGlobPatterns(slices.Concat(generateFilter.Include, generateFilter.Exclude)); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, rclass := range generateFilter.Rclasses {
+		if !slices.Contains([]string{"local", "remote", "virtual", "federated"}, strings.ToLower(rclass)) {
+			fmt.Printf("Error: Invalid value for -rclass: '%s'\n", rclass)
+			os.Exit(1)
+		}
+	}
+	if includeRegex := getStringEnv(*includeRegexString, "ARTSYNC_INCLUDE_REGEX", visitedFlags["include-regex"]); includeRegex != "" {
+		generateFilter.IncludeRegex, err = regexp.Compile(includeRegex)
+		if err != nil {
+			fmt.Printf("Error: Invalid value for -include-regex: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if principalFilter := getStringEnv(*principalFilterString, "ARTSYNC_PRINCIPAL_FILTER", visitedFlags["principal-filter"]); principalFilter != "" {
+		generateFilter.Principal, err = ParsePrincipalFilter(principalFilter)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	backupDir := getStringEnv(*backupDirString, "ARTSYNC_BACKUP_DIR", visitedFlags["backup-dir"])
+	if backupDir == "" {
+		backupDir = filepath.Join("backups", time.Now().Format("20060102_150405"))
+	}
+
+	lockRepo := getStringEnv(*lockRepoString, "ARTSYNC_LOCK_REPO", visitedFlags["lock-repo"])
+	lockTTLStr := getStringEnv(*lockTTLString, "ARTSYNC_LOCK_TTL", visitedFlags["lock-ttl"])
+	lockTTL, err := time.ParseDuration(lockTTLStr)
+	if err != nil || lockTTL <= 0 {
+		fmt.Printf("Error: Invalid value for -lock-ttl: '%s'\n", lockTTLStr)
+		os.Exit(1)
+	}
+
+	fromSnapshot := getStringEnv(*fromSnapshotString, "ARTSYNC_FROM_SNAPSHOT", visitedFlags["from-snapshot"])
+
+	minArgs := 3
+	if fromSnapshot != "" {
+		minArgs = 1
+	}
 
 	args := flag.Args()
-	if len(args) < 3 || slices.ContainsFunc(args, func(arg string) bool { return arg == "" }) {
+	if len(args) < minArgs || slices.ContainsFunc(args, func(arg string) bool { return arg == "" }) {
 		usage()
 		os.Exit(1)
 	}
 
-	baseurl := getBaseURL(args[0])
-	token := getToken(args[1])
-	repofiles := getRepoFiles(args[2:])
+	var baseurl, token string
+	var repofiles []string
+	if fromSnapshot != "" {
+		repofiles = getRepoFiles(args)
+
+		if importUsersAndGroupsFilename != "" {
+			fmt.Println("Error: -l flag cannot be used together with -from-snapshot flag.")
+			os.Exit(1)
+		}
+		if propertiesConfigFilename != "" {
+			fmt.Println("Error: -i flag cannot be used together with -from-snapshot flag.")
+			os.Exit(1)
+		}
+		if principalsFile != "" {
+			fmt.Println("Error: -principals-file flag cannot be used together with -from-snapshot flag.")
+			os.Exit(1)
+		}
+		if analyze || compact {
+			fmt.Println("Error: -analyze and -compact flags cannot be used together with -from-snapshot flag, group members are retrieved from Artifactory.")
+			os.Exit(1)
+		}
+		dryRun = true
+	} else {
+		baseurl = getBaseURL(args[0])
+		token = getToken(args[1])
+		repofiles = getRepoFiles(args[2:])
+	}
 
 	if generate {
 		if len(repofiles) > 1 {
@@ -82,12 +277,43 @@ func main() {
 			os.Exit(1)
 		}
 
-		if !overwrite {
+		if splitTemplate != "" && !split {
+			fmt.Println("Error: -split-template flag can only be used together with -s flag.")
+			os.Exit(1)
+		}
+
+		if terraform && (generatejson || split || combineRepos) {
+			fmt.Println("Error: -t flag cannot be used together with -j, -s or -c flags.")
+			os.Exit(1)
+		}
+
+		if terraform && (analyze || compact) {
+			fmt.Println("Error: -t flag cannot be used together with -analyze or -compact flags.")
+			os.Exit(1)
+		}
+
+		if emitRoles && (terraform || merge) {
+			fmt.Println("Error: -emit-roles flag cannot be used together with -t or -merge flags.")
+			os.Exit(1)
+		}
+
+		if merge && (generatejson || split || terraform || overwrite) {
+			fmt.Println("Error: -merge flag cannot be used together with -j, -s, -t or -w flags.")
+			os.Exit(1)
+		}
+
+		if !overwrite && !merge {
 			if _, err := os.Stat(repofiles[0]); err == nil {
 				fmt.Printf("Error: File already exists, will not overwrite: '%s'\n", repofiles[0])
 				os.Exit(1)
 			}
 		}
+		if principalsFile != "" && !overwrite {
+			if _, err := os.Stat(principalsFile); err == nil {
+				fmt.Printf("Error: File already exists, will not overwrite: '%s'\n", principalsFile)
+				os.Exit(1)
+			}
+		}
 	} else {
 		if useAllPermissionTargetsAsSource {
 			fmt.Println("Error: -a flag can only be used together with -g flag.")
@@ -121,6 +347,30 @@ func main() {
 			fmt.Println("Error: -s flag can only be used together with -g flag.")
 			os.Exit(1)
 		}
+		if splitTemplate != "" {
+			fmt.Println("Error: -split-template flag can only be used together with -g flag.")
+			os.Exit(1)
+		}
+		if terraform {
+			fmt.Println("Error: -t flag can only be used together with -g flag.")
+			os.Exit(1)
+		}
+		if merge {
+			fmt.Println("Error: -merge flag can only be used together with -g flag.")
+			os.Exit(1)
+		}
+		if compact {
+			fmt.Println("Error: -compact flag can only be used together with -g flag.")
+			os.Exit(1)
+		}
+		if emitRoles {
+			fmt.Println("Error: -emit-roles flag can only be used together with -g flag.")
+			os.Exit(1)
+		}
+		if !generateFilter.IsEmpty() {
+			fmt.Println("Error: -include, -exclude, -include-regex, -package-type, -rclass and -principal-filter flags can only be used together with -g flag.")
+			os.Exit(1)
+		}
 
 		success := true
 		for _, repofile := range repofiles {
@@ -146,6 +396,7 @@ func main() {
 	}
 
 	var reposToProvision []Repo
+	var principals Principals
 
 	if !generate {
 		reposToProvision = LoadRepoFiles(repofiles, provisionEmpty)
@@ -153,46 +404,158 @@ func main() {
 			fmt.Println("Error: No valid repos to provision found in the provided repo files.")
 			os.Exit(1)
 		}
+
+		if principalsFile != "" {
+			principals, err = LoadPrincipalsFile(principalsFile)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
 	}
 
-	repos, users, groups, permissiondetails, ldapsettings, ldapgroupsettings, err := GetStuff(client, baseurl, token, importUsersAndGroupsFilename != "", useCache)
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+	var cache *Cache
+	var lock *Lock
+	var repos []ArtifactoryRepoDetailsResponse
+	var users []ArtifactoryUser
+	var groups []ArtifactoryGroup
+	var permissiondetails []ArtifactoryPermissionDetails
+	var ldapsettings []ArtifactoryLDAPSettings
+	var ldapgroupsettings []ArtifactoryLDAPGroupSettings
+
+	if fromSnapshot != "" {
+		snapshot, err := LoadSnapshot(fromSnapshot)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		baseurl = snapshot.BaseURL
+		repos, users, groups = snapshot.Repos, snapshot.Users, snapshot.Groups
+		permissiondetails, err = snapshot.GetPermissionDetails()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		cache, err = NewCache(GetCacheFolder(cacheDir, baseurl), baseurl, token, useCache, cacheMaxAge, cacheInvalidate)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		cache.Incremental = incremental
+		cache.RevalidateSample = revalidateSample
+
+		// Locking before retrieving, so that the retrieved data isn't stale when provisioning.
+		if lockRepo != "" && !generate && !dryRun && !analyze {
+			lock, err = AcquireLock(client, baseurl, token, lockRepo, lockTTL)
+			if err != nil {
+				fmt.Printf("Error acquiring lock: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		repos, users, groups, permissiondetails, ldapsettings, ldapgroupsettings, err = GetStuff(client, baseurl, token, importUsersAndGroupsFilename != "", cache, concurrency)
+		if err != nil {
+			printRetrieveError(err)
+			lock.Release()
+			os.Exit(1)
+		}
+	}
+
+	var propertiesConfig PropertiesConfig
+	if propertiesConfigFilename != "" {
+		propertiesConfig, err = loadPropertiesConfig(propertiesConfigFilename)
+		if err != nil {
+			fmt.Printf("Error reading properties config: %v\n", err)
+			lock.Release()
+			os.Exit(1)
+		}
 	}
 
 	if generate {
-		err = Generate(repos, permissiondetails, useAllPermissionTargetsAsSource, onlyGenerateMatchingRepos, onlyGenerateCleanRepos, allowRenamedPermissions, combineRepos, split, repofiles[0], generatejson)
+		generateConfig := GenerateConfig{
+			UseAllPermissionTargetsAsSource: useAllPermissionTargetsAsSource,
+			OnlyMatchingRepos:               onlyGenerateMatchingRepos,
+			OnlyCleanRepos:                  onlyGenerateCleanRepos,
+			AllowRenamedPermissions:         allowRenamedPermissions,
+			Combine:                         combineRepos,
+			Split:                           split,
+			Json:                            generatejson,
+			Terraform:                       terraform,
+			Merge:                           merge,
+			Filter:                          generateFilter,
+			SplitTemplate:                   splitTemplate,
+			Properties:                      propertiesConfig,
+			Concurrency:                     concurrency,
+			Analyze:                         analyze,
+			Compact:                         compact,
+			EmitRoles:                       emitRoles,
+		}
+		err = Generate(client, baseurl, token, repos, permissiondetails, repofiles[0], generateConfig)
 		if err != nil {
 			fmt.Printf("Error generating: %v\n", err)
 			os.Exit(1)
 		}
+
+		if principalsFile != "" {
+			err = GeneratePrincipals(client, baseurl, token, users, groups, principalsFile, generatejson, concurrency)
+			if err != nil {
+				fmt.Printf("Error generating principals: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	} else if analyze {
+		groupnames := getRepoGroupNames(reposToProvision, func(name string) bool {
+			return slices.ContainsFunc(groups, func(g ArtifactoryGroup) bool { return g.GroupName == name })
+		})
+		groupMembers, err := getGroupMembers(client, baseurl, token, groupnames, concurrency)
+		if err != nil {
+			fmt.Printf("Error analyzing: %v\n", err)
+			os.Exit(1)
+		}
+		AnalyzeRepos(reposToProvision, groupMembers, repoRoles).Print()
 	} else {
 		var ldapConfig LdapConfig
 		if importUsersAndGroupsFilename != "" {
 			ldapConfig, err = loadLdapConfig(importUsersAndGroupsFilename, ldapsettings, ldapgroupsettings)
 			if err != nil {
 				fmt.Printf("Error reading ldap config: %v\n", err)
+				lock.Release()
 				os.Exit(1)
 			}
 		}
 
-		var propertiesConfig PropertiesConfig
-		if propertiesConfigFilename != "" {
-			propertiesConfig, err = loadPropertiesConfig(propertiesConfigFilename)
-			if err != nil {
-				fmt.Printf("Error reading properties config: %v\n", err)
-				os.Exit(1)
-			}
+		var backup *Backup
+		if !dryRun {
+			backup = NewBackup(backupDir)
 		}
 
 		reposToProvision, err = Validate(reposToProvision, repos, permissiondetails)
 		if err != nil {
 			fmt.Printf("Error validating: %v\n", err)
+			lock.Release()
 			os.Exit(1)
 		}
 
-		err = Provision(client, baseurl, token, reposToProvision, repos, users, groups, permissiondetails, showDiff, allowpatterns, ldapConfig, propertiesConfig, dryRun)
+		// Groups and users must exist before the permission targets referring to them.
+		if principalsFile != "" {
+			err = lock.Check()
+			if err != nil {
+				fmt.Printf("Error provisioning principals: %v\n", err)
+				lock.Release()
+				os.Exit(1)
+			}
+			users, groups, err = ProvisionPrincipals(client, baseurl, token, principals, users, groups, dryRun)
+			if err != nil {
+				fmt.Printf("Error provisioning principals: %v\n", err)
+				lock.Release()
+				os.Exit(1)
+			}
+		}
+
+		err = Provision(client, baseurl, token, reposToProvision, repos, users, groups, permissiondetails, showDiff, allowpatterns, ldapConfig, propertiesConfig, cache, backup, lock, provisionConcurrency, dryRun)
+		lock.Release()
 		if err != nil {
 			fmt.Printf("Error provisioning: %v\n", err)
 			os.Exit(1)
@@ -200,6 +563,324 @@ func main() {
 	}
 }
 
+func runCacheCommand(args []string) int {
+	flags := flag.NewFlagSet("cache", flag.ExitOnError)
+	cacheDirString := flags.String("cache-dir", "cache", "Folder for cached data.")
+	flags.Parse(args)
+
+	visitedFlags := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		visitedFlags[f.Name] = true
+	})
+
+	cacheDir := getStringEnv(*cacheDirString, "ARTSYNC_CACHE_DIR", visitedFlags["cache-dir"])
+
+	var err error
+	switch flags.Arg(0) {
+	case "list":
+		err = ListCaches(cacheDir)
+	case "clear":
+		var baseurl string
+		if flags.Arg(1) != "" {
+			baseurl = getBaseURL(flags.Arg(1))
+		}
+		err = ClearCaches(cacheDir, baseurl)
+	default:
+		fmt.Println("Usage: artsync cache [-cache-dir folder] list")
+		fmt.Println("       artsync cache [-cache-dir folder] clear [baseurl]")
+		return 1
+	}
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func runSnapshotCommand(args []string) int {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	ignoreCertFlag := flags.Bool("k", false, "Ignore https cert validation errors.")
+	concurrencyInt := flags.Int("concurrency", 4, "Number of concurrent requests, when retrieving repo and permission target details.")
+	cacheDirString := flags.String("cache-dir", "cache", "Folder for cached data.")
+	flags.Parse(args)
+
+	if flags.Arg(0) != "export" || flags.NArg() != 4 || *concurrencyInt < 1 {
+		fmt.Println("Usage: artsync snapshot [-k] [-concurrency n] [-cache-dir folder] export <baseurl> <tokenfile> <file>")
+		return 1
+	}
+
+	visitedFlags := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		visitedFlags[f.Name] = true
+	})
+
+	cacheDir := getStringEnv(*cacheDirString, "ARTSYNC_CACHE_DIR", visitedFlags["cache-dir"])
+
+	baseurl := getBaseURL(flags.Arg(1))
+	token := getToken(flags.Arg(2))
+	filename := flags.Arg(3)
+
+	client := &http.Client{}
+	if *ignoreCertFlag {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	cache, err := NewCache(GetCacheFolder(cacheDir, baseurl), baseurl, token, false, 0, nil)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	repos, users, groups, permissiondetails, ldapsettings, ldapgroupsettings, err := GetStuff(client, baseurl, token, true, cache, *concurrencyInt)
+	if err != nil {
+		printRetrieveError(err)
+		return 1
+	}
+
+	err = ExportSnapshot(filename, baseurl, repos, users, groups, permissiondetails, ldapsettings, ldapgroupsettings)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func runDriftCommand(args []string) int {
+	flags := flag.NewFlagSet("drift", flag.ExitOnError)
+	propertiesConfigFilenameString := flags.String("i", "", "Also check properties, configuration file.")
+	ignoreCertFlag := flags.Bool("k", false, "Ignore https cert validation errors.")
+	outputFilenameString := flags.String("o", "", "File to write the drift report to.")
+	allowpatternsFlag := flags.Bool("p", false, "Allow permission targets include/exclude patterns.")
+	concurrencyInt := flags.Int("concurrency", 4, "Number of concurrent requests, when retrieving repo and permission target details.")
+	rolesString := flags.String("roles", "", "Role definitions, json file.")
+	cacheDirString := flags.String("cache-dir", "cache", "Folder for cached data.")
+	flags.Parse(args)
+
+	if flags.NArg() < 3 || *concurrencyInt < 1 || *outputFilenameString == "" {
+		fmt.Println("Usage: artsync drift [-i configfile] [-k] -o file [-p] [-roles file] [-concurrency n] [-cache-dir folder] <baseurl> <tokenfile> <repofile1> [repofile2] ...")
+		return 1
+	}
+
+	visitedFlags := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		visitedFlags[f.Name] = true
+	})
+
+	cacheDir := getStringEnv(*cacheDirString, "ARTSYNC_CACHE_DIR", visitedFlags["cache-dir"])
+
+	if *rolesString != "" {
+		var err error
+		repoRoles, err = LoadRoles(*rolesString)
+		if err != nil {
+			fmt.Printf("Error reading roles: %v\n", err)
+			return 1
+		}
+	}
+
+	baseurl := getBaseURL(flags.Arg(0))
+	token := getToken(flags.Arg(1))
+	repofiles := getRepoFiles(flags.Args()[2:])
+
+	client := &http.Client{}
+	if *ignoreCertFlag {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	var propertiesConfig PropertiesConfig
+	if *propertiesConfigFilenameString != "" {
+		var err error
+		propertiesConfig, err = loadPropertiesConfig(*propertiesConfigFilenameString)
+		if err != nil {
+			fmt.Printf("Error reading properties config: %v\n", err)
+			return 1
+		}
+	}
+
+	reposToCheck := LoadRepoFiles(repofiles, false)
+	if len(reposToCheck) == 0 {
+		fmt.Println("Error: No valid repos found in the provided repo files.")
+		return 1
+	}
+
+	cache, err := NewCache(GetCacheFolder(cacheDir, baseurl), baseurl, token, false, 0, nil)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	repos, users, _, permissiondetails, _, _, err := GetStuff(client, baseurl, token, false, cache, *concurrencyInt)
+	if err != nil {
+		printRetrieveError(err)
+		return 1
+	}
+
+	report, err := CheckDrift(client, baseurl, token, reposToCheck, repos, users, permissiondetails, *allowpatternsFlag, propertiesConfig)
+	if err != nil {
+		fmt.Printf("Error checking drift: %v\n", err)
+		return 1
+	}
+
+	file, err := os.Create(*outputFilenameString)
+	if err == nil {
+		err = WriteDriftReport(file, report)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error writing drift report: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Drifted repos: %d, invalid repos: %d, uncovered repos: %d, uncovered permission targets: %d\n", len(report.Repos), len(report.InvalidRepos), len(report.UncoveredRepos), len(report.UncoveredPermissions))
+
+	if report.HasDrift {
+		return driftExitCode
+	}
+	return 0
+}
+
+func runRollbackCommand(args []string) int {
+	flags := flag.NewFlagSet("rollback", flag.ExitOnError)
+	dryRunFlag := flags.Bool("d", false, "Enable dry run mode (read-only, no changes will be made).")
+	showDiffFlag := flags.Bool("f", false, "Show json diff.")
+	ignoreCertFlag := flags.Bool("k", false, "Ignore https cert validation errors.")
+	lockRepoString := flags.String("lock-repo", "", "Generic repo for a lock item preventing concurrent runs against the same instance.")
+	lockTTLString := flags.String("lock-ttl", "1h", "Time after which a lock is considered stale and can be taken over.")
+	flags.Parse(args)
+
+	visitedFlags := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		visitedFlags[f.Name] = true
+	})
+
+	lockRepo := getStringEnv(*lockRepoString, "ARTSYNC_LOCK_REPO", visitedFlags["lock-repo"])
+	lockTTL, err := time.ParseDuration(getStringEnv(*lockTTLString, "ARTSYNC_LOCK_TTL", visitedFlags["lock-ttl"]))
+	if flags.NArg() != 3 || err != nil || lockTTL <= 0 {
+		fmt.Println("Usage: artsync rollback [-d] [-f] [-k] [-lock-repo repo] [-lock-ttl duration] <baseurl> <tokenfile> <run-dir>")
+		return 1
+	}
+
+	baseurl := getBaseURL(flags.Arg(0))
+	token := getToken(flags.Arg(1))
+	runDir := flags.Arg(2)
+
+	client := &http.Client{}
+	if *ignoreCertFlag {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	if *dryRunFlag {
+		fmt.Println("Dry run...")
+	}
+
+	var lock *Lock
+	if lockRepo != "" && !*dryRunFlag {
+		lock, err = AcquireLock(client, baseurl, token, lockRepo, lockTTL)
+		if err != nil {
+			fmt.Printf("Error acquiring lock: %v\n", err)
+			return 1
+		}
+	}
+
+	err = Rollback(client, baseurl, token, runDir, lock, *showDiffFlag, *dryRunFlag)
+	lock.Release()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func runLockCommand(args []string) int {
+	flags := flag.NewFlagSet("lock", flag.ExitOnError)
+	forceFlag := flags.Bool("force", false, "Take over the lock even if it isn't expired, removing it like force-unlock.")
+	ignoreCertFlag := flags.Bool("k", false, "Ignore https cert validation errors.")
+	flags.Parse(args)
+
+	if flags.NArg() != 4 {
+		fmt.Println("Usage: artsync lock [-k] status|force-unlock <baseurl> <tokenfile> <lock-repo>")
+		fmt.Println("       artsync lock [-k] [-force] takeover <baseurl> <tokenfile> <lock-repo>")
+		return 1
+	}
+
+	baseurl := getBaseURL(flags.Arg(1))
+	token := getToken(flags.Arg(2))
+	lockRepo := flags.Arg(3)
+
+	client := &http.Client{}
+	if *ignoreCertFlag {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	var err error
+	switch flags.Arg(0) {
+	case "status":
+		var info *LockInfo
+		info, err = GetLock(client, baseurl, token, lockRepo)
+		if err == nil {
+			switch {
+			case info == nil:
+				fmt.Println("Not locked.")
+			case info.IsExpired():
+				fmt.Printf("Locked, expired: %s\n", info)
+			default:
+				fmt.Printf("Locked: %s\n", info)
+			}
+		}
+	case "force-unlock":
+		err = ForceUnlock(client, baseurl, token, lockRepo)
+	case "takeover":
+		err = TakeoverLock(client, baseurl, token, lockRepo, *forceFlag)
+	default:
+		fmt.Printf("Error: Unknown lock command: '%s'\n", flags.Arg(0))
+		return 1
+	}
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func printRetrieveError(err error) {
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		fmt.Printf("Error: Not authorized by Artifactory, check the token and its permissions: %v\n", err)
+	case errors.Is(err, ErrNotFound):
+		fmt.Printf("Error: Artifactory api not found, check the base URL: %v\n", err)
+	case errors.Is(err, ErrServer):
+		fmt.Printf("Error: Artifactory server error, try again later: %v\n", err)
+	default:
+		fmt.Printf("Error: %v\n", err)
+	}
+}
+
+// Splits a comma separated flag value, ignoring empty items.
+func splitList(value string) []string {
+	var items []string
+	for item := range strings.SplitSeq(value, ",") {
+		if strings.TrimSpace(item) != "" {
+			items = append(items, strings.TrimSpace(item))
+		}
+	}
+	return items
+}
+
 func getFlagEnv(flagValue bool, envName string, flagWasVisited bool) bool {
 	if flagWasVisited {
 		return flagValue
@@ -229,6 +910,42 @@ func getStringEnv(flagValue string, envName string, flagWasVisited bool) string
 	return flagValue
 }
 
+func getIntEnv(flagValue int, envName string, flagWasVisited bool) int {
+	if flagWasVisited {
+		return flagValue
+	}
+
+	envValue := strings.TrimSpace(os.Getenv(envName))
+	if envValue != "" {
+		value, err := strconv.Atoi(envValue)
+		if err != nil {
+			fmt.Printf("Error: Invalid value for %s: '%s'\n", envName, envValue)
+			os.Exit(1)
+		}
+		return value
+	}
+
+	return flagValue
+}
+
+func getFloatEnv(flagValue float64, envName string, flagWasVisited bool) float64 {
+	if flagWasVisited {
+		return flagValue
+	}
+
+	envValue := strings.TrimSpace(os.Getenv(envName))
+	if envValue != "" {
+		value, err := strconv.ParseFloat(envValue, 64)
+		if err != nil {
+			fmt.Printf("Error: Invalid value for %s: '%s'\n", envName, envValue)
+			os.Exit(1)
+		}
+		return value
+	}
+
+	return flagValue
+}
+
 func loadLdapConfig(configFile string, ldapsettings []ArtifactoryLDAPSettings, ldapgroupsettings []ArtifactoryLDAPGroupSettings) (LdapConfig, error) {
 	empty := LdapConfig{}
 
@@ -243,8 +960,10 @@ func loadLdapConfig(configFile string, ldapsettings []ArtifactoryLDAPSettings, l
 	envArtifactoryPassword := os.Getenv("ARTSYNC_ARTIFACTORY_PASSWORD")
 	envExcludeServersStr := os.Getenv("ARTSYNC_EXCLUDE_SETTINGS")
 	envExcludeSettings := strings.Split(strings.TrimSpace(envExcludeServersStr), ",")
+	envResolveNestedGroups := getFlagEnv(false, "ARTSYNC_RESOLVE_NESTED_GROUPS", false)
+	envUseTokenImport := getFlagEnv(false, "ARTSYNC_USE_TOKEN_IMPORT", false)
 
-	if envLdapUsername != "" && envLdapPassword != "" && envArtifactoryUsername != "" && envArtifactoryPassword != "" {
+	if envLdapUsername != "" && envLdapPassword != "" && ((envArtifactoryUsername != "" && envArtifactoryPassword != "") || envUseTokenImport) {
 		return LdapConfig{
 			ImportUsersAndGroups: true,
 			LdapUsername:         envLdapUsername,
@@ -254,6 +973,8 @@ func loadLdapConfig(configFile string, ldapsettings []ArtifactoryLDAPSettings, l
 			Ldapsettings:         filterLdapSettings(ldapsettings, envExcludeSettings),
 			Ldapgroupsettings:    filterLdapGroupSettings(ldapgroupsettings, envExcludeSettings),
 			ExcludeSettings:      envExcludeSettings,
+			ResolveNestedGroups:  envResolveNestedGroups,
+			UseTokenImport:       envUseTokenImport,
 		}, nil
 	}
 	var ldapConfig LdapConfig
@@ -284,6 +1005,12 @@ func loadLdapConfig(configFile string, ldapsettings []ArtifactoryLDAPSettings, l
 	if len(envExcludeSettings) > 0 {
 		ldapConfig.ExcludeSettings = envExcludeSettings
 	}
+	if envResolveNestedGroups {
+		ldapConfig.ResolveNestedGroups = true
+	}
+	if envUseTokenImport {
+		ldapConfig.UseTokenImport = true
+	}
 
 	ldapConfig.ImportUsersAndGroups = true
 	ldapConfig.Ldapsettings = filterLdapSettings(ldapsettings, ldapConfig.ExcludeSettings)
@@ -412,14 +1139,22 @@ func getRepoFiles(args []string) []string {
 	} else {
 		repofiles = args
 	}
+	var expanded []string
 	for _, repofile := range repofiles {
 		if repofile == "" {
 			fmt.Println("Error: Repo file name empty.")
 			os.Exit(1)
 		}
+		// Keep patterns without matches, so missing files are reported as before.
+		matches, err := filepath.Glob(repofile)
+		if err != nil || len(matches) == 0 {
+			expanded = append(expanded, repofile)
+			continue
+		}
+		expanded = append(expanded, matches...)
 	}
 
-	return repofiles
+	return expanded
 }
 
 func usage() {
@@ -428,21 +1163,30 @@ func usage() {
 	fmt.Println("This tool is used to provision Artifactory repositories and matching permission targets.")
 	fmt.Println("It can also generate a declarative file based on existing repos and permission targets.")
 	fmt.Println()
-	fmt.Println("Usage: artsync [-a] [-c] [-d] [-e] [-f] [-g] [-i configfile] [-j] [-k] [-l configfile] [-m] [-p] [-q] [-r] [-s] [-w] <baseurl> <tokenfile> <repofile1> [repofile2] ...")
+	fmt.Println("Usage: artsync [-a] [-c] [-d] [-e] [-f] [-g] [-i configfile] [-j] [-k] [-l configfile] [-m] [-p] [-q] [-r] [-s] [-t] [-w] [-merge] [-analyze] [-compact] [-roles file] [-emit-roles] [-principals-file file] [-split-template template] [-repo-include patterns] [-include patterns] [-exclude patterns] [-include-regex regex] [-package-type types] [-rclass classes] [-principal-filter filter] [-retries n] [-concurrency n] [-provision-concurrency n] [-rps n] [-backup-dir folder] [-lock-repo repo] [-lock-ttl duration] [-cache-dir folder] [-cache-max-age duration] [-cache-invalidate types] [-incremental] [-revalidate-sample n] <baseurl> <tokenfile> <repofile1> [repofile2] ...")
+	fmt.Println("       artsync [flags] -from-snapshot <file> <repofile1> [repofile2] ...")
+	fmt.Println("       artsync cache [-cache-dir folder] list|clear [baseurl]")
+	fmt.Println("       artsync snapshot [-k] [-concurrency n] [-cache-dir folder] export <baseurl> <tokenfile> <file>")
+	fmt.Println("       artsync rollback [-d] [-f] [-k] [-lock-repo repo] [-lock-ttl duration] <baseurl> <tokenfile> <run-dir>")
+	fmt.Println("       artsync lock [-k] [-force] status|force-unlock|takeover <baseurl> <tokenfile> <lock-repo>")
+	fmt.Println("       artsync drift [-i configfile] [-k] -o file [-p] [-roles file] [-concurrency n] [-cache-dir folder] <baseurl> <tokenfile> <repofile1> [repofile2] ...")
 	fmt.Println()
 	fmt.Println("baseurl:    Base URL of Artifactory instance, like https://artifactory.example.com")
 	fmt.Println("tokenfile:  File with access token (aka bearer token).")
-	fmt.Println("repofile:   Input file with repo definitions (output file when using -g flag).")
+	fmt.Println("repofile:   Input file with repo definitions (output file when using -g flag). Folders are read recursively, glob patterns are expanded.")
 	fmt.Println()
 	flag.PrintDefaults()
 	fmt.Println()
 	fmt.Println("ARTSYNC_BASEURL: Environment variable that overrides the base URL value.")
 	fmt.Println("ARTSYNC_TOKEN: Environment variable that overrides the token value.")
 	fmt.Println("ARTSYNC_REPOFILES: Environment variable that overrides the repo files value. Comma separated list of repo files.")
+	fmt.Println("ARTSYNC_LOCK_OWNER: Environment variable that overrides the lock owner, default is user@hostname.")
 	fmt.Println("")
 	fmt.Println("Environment variables for overriding values in ldap.config:")
 	fmt.Println("ARTSYNC_LDAP_USERNAME: Credentials for connecting to the LDAP server.")
 	fmt.Println("ARTSYNC_LDAP_PASSWORD: -")
-	fmt.Println("ARTSYNC_ARTIFACTORY_USERNAME: Credentials for connecting to the Artifactory server.")
+	fmt.Println("ARTSYNC_ARTIFACTORY_USERNAME: Credentials for connecting to the Artifactory server, for importing groups through the UI api.")
 	fmt.Println("ARTSYNC_ARTIFACTORY_PASSWORD: -")
+	fmt.Println("ARTSYNC_USE_TOKEN_IMPORT: Import ldap groups using the access token, with the UI api as fallback. Default if no Artifactory credentials are set.")
+	fmt.Println("ARTSYNC_RESOLVE_NESTED_GROUPS: Resolve nested ldap group members, and report users gaining write/manage access through nesting.")
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Placeholders of matrix variables in repo fields, like '{stage}'.
+var matrixPlaceholderPattern = regexp.MustCompile(`\{([a-zA-Z0-9_]+)\}`)
+
+// Replaces the strings of the templated fields of a repo: name, names, description, url and
+// the principal lists.
+func replaceMatrixFields(repo *Repo, replace func(string) string) {
+	repo.Name = replace(repo.Name)
+	repo.Description = replace(repo.Description)
+	repo.Url = replace(repo.Url)
+
+	lists := []*[]string{&repo.Names}
+	for _, list := range getRepoActionLists(repo) {
+		lists = append(lists, list.principals)
+	}
+	for _, list := range lists {
+		if *list == nil {
+			continue
+		}
+		values := make([]string, len(*list))
+		for i, value := range *list {
+			values[i] = replace(value)
+		}
+		*list = values
+	}
+}
+
+// Returns the variables of the placeholders in s.
+func getMatrixPlaceholders(s string) []string {
+	var variables []string
+	for _, match := range matrixPlaceholderPattern.FindAllStringSubmatch(s, -1) {
+		variables = append(variables, match[1])
+	}
+	return variables
+}
+
+// Expands a repo with a matrix into a repo per combination of the variable values. The
+// combinations are ordered by variable name, then by the order of the values.
+func expandMatrix(repo Repo) ([]Repo, error) {
+	if len(repo.Matrix) == 0 {
+		return []Repo{repo}, nil
+	}
+
+	nameVariables := getMatrixPlaceholders(repo.Name)
+	for _, name := range repo.Names {
+		nameVariables = append(nameVariables, getMatrixPlaceholders(name)...)
+	}
+
+	variables := slices.Sorted(maps.Keys(repo.Matrix))
+	for _, variable := range variables {
+		if len(repo.Matrix[variable]) == 0 {
+			return nil, fmt.Errorf("matrix variable '%s' has no values", variable)
+		}
+		if !slices.Contains(nameVariables, variable) {
+			return nil, fmt.Errorf("matrix variable '%s' is not used in the name", variable)
+		}
+	}
+
+	var unknown []string
+	replaceMatrixFields(&repo, func(s string) string {
+		for _, variable := range getMatrixPlaceholders(s) {
+			if _, ok := repo.Matrix[variable]; !ok && !slices.Contains(unknown, variable) {
+				unknown = append(unknown, variable)
+			}
+		}
+		return s
+	})
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown matrix variables: %s", strings.Join(unknown, ", "))
+	}
+
+	combinations := [][]string{{}}
+	for _, variable := range variables {
+		var next [][]string
+		for _, combination := range combinations {
+			for _, value := range repo.Matrix[variable] {
+				next = append(next, append(slices.Clone(combination), value))
+			}
+		}
+		combinations = next
+	}
+
+	var expanded []Repo
+	for _, combination := range combinations {
+		values := make(map[string]string)
+		variant := make([]string, len(variables))
+		for i, variable := range variables {
+			values[variable] = combination[i]
+			variant[i] = variable + "=" + combination[i]
+		}
+
+		expandedRepo := repo
+		expandedRepo.Matrix = nil
+		expandedRepo.SourceVariant = strings.Join(variant, ", ")
+		replaceMatrixFields(&expandedRepo, func(s string) string {
+			return matrixPlaceholderPattern.ReplaceAllStringFunc(s, func(placeholder string) string {
+				return values[placeholder[1:len(placeholder)-1]]
+			})
+		})
+		expanded = append(expanded, expandedRepo)
+	}
+
+	return expanded, nil
+}
+
+// Expands the repos with a matrix, and ignores invalid ones.
+func expandMatrixRepos(repos []Repo) []Repo {
+	var expandedRepos []Repo
+
+	for _, repo := range repos {
+		expanded, err := expandMatrix(repo)
+		if err != nil {
+			fmt.Printf("'%s': Warning: Ignoring repo at line %d: %v\n", repo.SourceFile, repo.SourceLine, err)
+			stats.IgnoredInvalidRepoCount++
+			continue
+		}
+
+		if len(repo.Matrix) > 0 {
+			template := repo.Name
+			if template == "" {
+				template = strings.Join(repo.Names, "', '")
+			}
+			for _, expandedRepo := range expanded {
+				name := expandedRepo.Name
+				if name == "" {
+					name = strings.Join(expandedRepo.Names, "', '")
+				}
+				fmt.Printf("Expanding matrix: '%s' -> '%s' (%s)\n", template, name, expandedRepo.SourceVariant)
+			}
+		}
+
+		expandedRepos = append(expandedRepos, expanded...)
+	}
+
+	return expandedRepos
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadRepoFileWithMatrix(t *testing.T) {
+	path := writeTempFile(t, "matrix-*.yaml", `- name: "{team}-{pkg}-{stage}"
+  matrix:
+    team: [alpha]
+    pkg: [npm]
+    stage: [dev, prod]
+  description: "{pkg} repo of {team} for {stage}"
+  read: [all]
+  write: ["{team}-{stage}-deployers"]
+`)
+	defer os.Remove(path)
+
+	repos := LoadRepoFiles([]string{path}, false)
+
+	want := []Repo{
+		{
+			Name:          "alpha-npm-dev",
+			Description:   "npm repo of alpha for dev",
+			Read:          []string{"all"},
+			Write:         []string{"alpha-dev-deployers"},
+			SourceVariant: "pkg=npm, stage=dev, team=alpha",
+		},
+		{
+			Name:          "alpha-npm-prod",
+			Description:   "npm repo of alpha for prod",
+			Read:          []string{"all"},
+			Write:         []string{"alpha-prod-deployers"},
+			SourceVariant: "pkg=npm, stage=prod, team=alpha",
+		},
+	}
+	if len(repos) != len(want) {
+		t.Fatalf("LoadRepoFileWithMatrix: got %d repos, want %d", len(repos), len(want))
+	}
+	for i := range repos {
+		if repos[i].SourceFile != path || repos[i].SourceLine != 1 {
+			t.Errorf("LoadRepoFileWithMatrix (%d/%d): got source %s:%d, want %s:1", i+1, len(want), repos[i].SourceFile, repos[i].SourceLine, path)
+		}
+		repos[i].SourceFile, repos[i].SourceOffset, repos[i].SourceLine = "", 0, 0
+		if !reflect.DeepEqual(repos[i], want[i]) {
+			t.Errorf("LoadRepoFileWithMatrix (%d/%d): got %+v, want %+v", i+1, len(want), repos[i], want[i])
+		}
+	}
+}
+
+func TestExpandMatrix(t *testing.T) {
+	tests := []struct {
+		Name      string
+		Repo      Repo
+		WantNames []string
+		WantErr   bool
+	}{
+		{"No matrix", Repo{Name: "repo1"}, []string{"repo1"}, false},
+		{"Ordered by variable", Repo{Name: "{b}-{a}", Matrix: map[string][]string{"b": {"y", "x"}, "a": {"2", "1"}}},
+			[]string{"y-2", "x-2", "y-1", "x-1"}, false},
+		{"Names", Repo{Names: []string{"{a}-local", "{a}-remote"}, Matrix: map[string][]string{"a": {"x", "y"}}},
+			[]string{"x-local, x-remote", "y-local, y-remote"}, false},
+		{"Unknown variable", Repo{Name: "{a}", Url: "https://{b}", Matrix: map[string][]string{"a": {"x"}}}, nil, true},
+		{"Unused variable", Repo{Name: "{a}", Read: []string{"{b}"}, Matrix: map[string][]string{"a": {"x"}, "b": {"y"}}}, nil, true},
+		{"No values", Repo{Name: "{a}", Matrix: map[string][]string{"a": {}}}, nil, true},
+	}
+
+	for i, test := range tests {
+		got, err := expandMatrix(test.Repo)
+		if (err != nil) != test.WantErr {
+			t.Errorf("%s (%d/%d): error = %v, wantErr %v", test.Name, i+1, len(tests), err, test.WantErr)
+			continue
+		}
+
+		var names []string
+		for _, repo := range got {
+			if repo.Matrix != nil {
+				t.Errorf("%s (%d/%d): matrix not cleared: %+v", test.Name, i+1, len(tests), repo)
+			}
+			name := repo.Name
+			if name == "" {
+				name = strings.Join(repo.Names, ", ")
+			}
+			names = append(names, name)
+		}
+		if !reflect.DeepEqual(names, test.WantNames) {
+			t.Errorf("%s (%d/%d): got %v, want %v", test.Name, i+1, len(tests), names, test.WantNames)
+		}
+	}
+}
+
+func TestLoadRepoFileWithMatrixDuplicates(t *testing.T) {
+	path := writeTempFile(t, "matrix-*.yaml", `- name: "app-{stage}"
+  matrix:
+    stage: [dev, prod]
+- name: app-prod
+`)
+	defer os.Remove(path)
+
+	oldStdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+
+	ClearStats()
+	repos := LoadRepoFiles([]string{path}, false)
+
+	w.Close()
+	os.Stdout = oldStdout
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+
+	if len(repos) != 1 || repos[0].Name != "app-dev" {
+		t.Errorf("LoadRepoFileWithMatrixDuplicates: got %+v, want only app-dev", repos)
+	}
+	if stats.IgnoredDuplicatedRepoCount != 2 {
+		t.Errorf("LoadRepoFileWithMatrixDuplicates: got %d ignored duplicates, want 2", stats.IgnoredDuplicatedRepoCount)
+	}
+	want := path + ":1 (stage=prod), " + path + ":4"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("LoadRepoFileWithMatrixDuplicates: output doesn't contain %q:\n%s", want, buf.String())
+	}
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/goccy/go-yaml/token"
+)
+
+const removedRepoComment = " artsync: removed from Artifactory"
+
+// A repo file field that is updated when merging, with its value in a generated repo.
+// Values are compared with get, and written with set if it's given.
+type mergeField struct {
+	key     string
+	get     func(Repo) any
+	ordered bool
+	set     func(Repo) any
+}
+
+var mergeFields = []mergeField{
+	{"description", func(r Repo) any { return r.Description }, false, nil},
+	{"rclass", func(r Repo) any { return r.Rclass }, false, nil},
+	{"packageType", func(r Repo) any { return r.PackageType }, false, nil},
+	{"layout", func(r Repo) any { return r.Layout }, false, nil},
+	{"url", func(r Repo) any { return r.Url }, false, nil},
+	{"read", func(r Repo) any { return r.Read }, false, nil},
+	{"annotate", func(r Repo) any { return r.Annotate }, false, nil},
+	{"write", func(r Repo) any { return r.Write }, false, nil},
+	{"delete", func(r Repo) any { return r.Delete }, false, nil},
+	{"manage", func(r Repo) any { return r.Manage }, false, nil},
+	{"scan", func(r Repo) any { return r.Scan }, false, nil},
+	// The order of virtual repo members is their resolution order.
+	{"repositories", func(r Repo) any { return r.Repositories }, true, nil},
+}
+
+var mergePermissionNameField = mergeField{"permissionName", func(r Repo) any { return r.PermissionName }, false, nil}
+
+// Merges generated repos into an existing yaml repo file, keeping comments, ordering, names
+// groups and extra fields. Only changed fields are updated, new repos are appended, and repos
+// that don't exist in Artifactory anymore are marked with a comment. Repos are compared with
+// the defaults of their folder and file applied, and only values differing from the defaults
+// are written.
+func mergeRepoFile(reposToSave []Repo, allrepos []ArtifactoryRepoDetailsResponse, repofile string, config GenerateConfig) error {
+	data, err := os.ReadFile(repofile)
+	if os.IsNotExist(err) {
+		fmt.Printf("File doesn't exist, nothing to merge into: '%s'\n", repofile)
+		if config.Combine {
+			reposToSave = combineIdenticalRepos(reposToSave)
+			sortRepos(reposToSave)
+		}
+		return saveCombinedRepos(reposToSave, repofile, false)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading file: %w", err)
+	}
+
+	defaults, err := newRepoDefaults().forFolder(filepath.Dir(repofile))
+	if err != nil {
+		return err
+	}
+
+	fileDefaults, body, err := splitDefaultsDocument(data, repofile)
+	if err != nil {
+		return err
+	}
+
+	// The defaults document is kept as it is, only the repos after it are merged.
+	var header []byte
+	if fileDefaults != nil {
+		applyDefaults(fileDefaults, defaults)
+		defaults = fileDefaults
+
+		headerLength := len(body) - len(bytes.TrimLeft(body, " \n"))
+		headerLength = bytes.LastIndexByte(body[:headerLength], '\n') + 1
+		header = data[:headerLength]
+	}
+
+	var mergeDefaults *Repo
+	if defaults != nil {
+		// Compared with generated repos, which leave out the values that are default when provisioning.
+		normalized := *defaults
+		removeRepoDefaults(&normalized)
+		mergeDefaults = &normalized
+	}
+
+	file, err := parser.ParseBytes(body, parser.ParseComments)
+	if err != nil {
+		return fmt.Errorf("error parsing file as yaml: %w", err)
+	}
+	if len(file.Docs) != 1 {
+		return fmt.Errorf("merge requires a single yaml document, found %d", len(file.Docs))
+	}
+
+	seq, ok := file.Docs[0].Body.(*ast.SequenceNode)
+	if !ok {
+		return fmt.Errorf("merge requires a yaml list of repos")
+	}
+	if len(seq.ValueHeadComments) != len(seq.Values) {
+		seq.ValueHeadComments = make([]*ast.CommentGroupNode, len(seq.Values))
+	}
+
+	fields := mergeFields
+	if config.AllowRenamedPermissions {
+		fields = append(slices.Clone(fields), mergePermissionNameField)
+	}
+
+	var updatedCount, removedCount int
+	var mergedNames []string
+
+	for i, value := range seq.Values {
+		mapping, ok := value.(*ast.MappingNode)
+		if !ok {
+			fmt.Printf("'%s': Warning: Ignoring non-mapping list entry at line %d, when merging.\n", repofile, value.GetToken().Position.Line)
+			continue
+		}
+
+		var existing Repo
+		var rawRepo map[string]any
+		err := yaml.NodeToValue(mapping, &existing)
+		if err == nil {
+			err = yaml.NodeToValue(mapping, &rawRepo)
+		}
+		if err != nil {
+			fmt.Printf("'%s': Warning: Ignoring unparsable repo at line %d, when merging: %v\n", repofile, value.GetToken().Position.Line, err)
+			continue
+		}
+		existing.ExtraFields = extractExtraFields(rawRepo)
+		roleFields := takeRoleFields(&existing)
+
+		// Templated repos are kept as they are, their generated repos aren't added again.
+		if len(existing.Matrix) > 0 {
+			existing.Roles = roleFields
+			ExpandRoles(&existing, repoRoles)
+			expanded, err := expandMatrix(existing)
+			if err != nil {
+				fmt.Printf("'%s': Warning: Ignoring invalid matrix repo at line %d, when merging: %v\n", repofile, value.GetToken().Position.Line, err)
+				continue
+			}
+			for _, repo := range expanded {
+				if repo.Name != "" {
+					mergedNames = append(mergedNames, repo.Name)
+				}
+				mergedNames = append(mergedNames, repo.Names...)
+			}
+			fmt.Printf("'%s': Warning: Not merging matrix repo at line %d, update it manually.\n", repofile, value.GetToken().Position.Line)
+			continue
+		}
+
+		names := existing.Names
+		if existing.Name != "" {
+			names = []string{existing.Name}
+		}
+		mergedNames = append(mergedNames, names...)
+
+		var generated []Repo
+		var removed []string
+		for _, name := range names {
+			index := slices.IndexFunc(reposToSave, func(r Repo) bool { return r.Name == name })
+			if index != -1 {
+				generated = append(generated, getExpandedMergeRepo(reposToSave[index]))
+			} else if !slices.ContainsFunc(allrepos, func(r ArtifactoryRepoDetailsResponse) bool { return r.Key == name }) {
+				removed = append(removed, name)
+			}
+		}
+
+		if len(removed) > 0 && markRemovedRepo(seq, i, names, removed) {
+			fmt.Printf("'%s': Marked as removed from Artifactory.\n", strings.Join(removed, "', '"))
+			removedCount++
+		}
+
+		if len(generated) == 0 {
+			continue
+		}
+
+		// Role fields keep the principals that still have all actions of the role, the
+		// actions of the others are written to the action lists.
+		var updatedFields []string
+		roles := getMergeRoles(roleFields, generated)
+		rolePrincipals := make(map[string][]string)
+		for _, role := range repoRoles {
+			principals, ok := roleFields[role.Name]
+			if !ok {
+				continue
+			}
+			for _, action := range role.Actions {
+				rolePrincipals[strings.ToLower(action)] = append(rolePrincipals[strings.ToLower(action)], roles[role.Name]...)
+			}
+			if equalStringSlices(principals, roles[role.Name]) {
+				continue
+			}
+			err := setMappingValue(mapping, role.FieldName(), roles[role.Name])
+			if err != nil {
+				return fmt.Errorf("error merging field '%s' of repo '%s': %w", role.FieldName(), names[0], err)
+			}
+			updatedFields = append(updatedFields, role.FieldName())
+		}
+		existing.Roles = roles
+		ExpandRoles(&existing, repoRoles)
+
+		override := existing.Override
+		repoDefaults := mergeDefaults
+		if existing.Inherit != nil && !*existing.Inherit {
+			repoDefaults = nil
+		}
+		applyDefaults(&existing, defaults)
+		removeRepoDefaults(&existing)
+
+		repoFields := fields
+		if config.Properties.SetProperties {
+			repoFields = append(slices.Clone(fields), getExtraMergeFields(existing, generated)...)
+		}
+
+		for _, field := range repoFields {
+			desired, ok := getMergeFieldValue(field, generated)
+			if !ok {
+				fmt.Printf("'%s': Warning: Repos in names group differ in field '%s', not merging it.\n", strings.Join(names, "', '"), field.key)
+				continue
+			}
+			if equalMergeFieldValues(field, field.get(existing), desired) {
+				continue
+			}
+
+			if principals := rolePrincipals[field.key]; len(principals) > 0 {
+				desired = slices.DeleteFunc(slices.Clone(desired.([]string)), func(p string) bool { return slices.Contains(principals, p) })
+			}
+			if repoDefaults != nil && !slices.Contains(override, field.key) {
+				var overridden bool
+				desired, overridden = getMergeValueWithoutDefault(desired, field.get(*repoDefaults), field.ordered, field.set == nil)
+				if overridden {
+					override = append(slices.Clone(override), field.key)
+				}
+			}
+			if field.set != nil && !isEmptyMergeFieldValue(desired) {
+				desired = field.set(generated[0])
+			}
+			err := setMappingValue(mapping, field.key, desired)
+			if err != nil {
+				return fmt.Errorf("error merging field '%s' of repo '%s': %w", field.key, names[0], err)
+			}
+			updatedFields = append(updatedFields, field.key)
+		}
+
+		if len(override) != len(existing.Override) {
+			err := setMappingValue(mapping, "override", override)
+			if err != nil {
+				return fmt.Errorf("error merging field 'override' of repo '%s': %w", names[0], err)
+			}
+			updatedFields = append(updatedFields, "override")
+		}
+
+		if len(updatedFields) > 0 {
+			fmt.Printf("'%s': Updated fields: %s\n", strings.Join(names, "', '"), strings.Join(updatedFields, ", "))
+			updatedCount++
+		}
+	}
+
+	var newRepos []Repo
+	for _, repo := range reposToSave {
+		if !slices.Contains(mergedNames, repo.Name) {
+			newRepos = append(newRepos, repo)
+		}
+	}
+	if config.Combine {
+		newRepos = combineIdenticalRepos(newRepos)
+		sortRepos(newRepos)
+	}
+	for _, repo := range newRepos {
+		if mergeDefaults != nil {
+			removeMergeDefaults(&repo, mergeDefaults, config.Properties.SetProperties)
+		}
+		node, err := yaml.ValueToNode(repo)
+		if err != nil {
+			return fmt.Errorf("error generating yaml: %w", err)
+		}
+		seq.Values = append(seq.Values, node)
+		seq.ValueHeadComments = append(seq.ValueHeadComments, nil)
+
+		name := repo.Name
+		if name == "" {
+			name = strings.Join(repo.Names, "', '")
+		}
+		fmt.Printf("'%s': Added new repo.\n", name)
+	}
+
+	output := string(header) + strings.TrimRight(file.String(), "\n") + "\n"
+	err = os.WriteFile(repofile, []byte(output), 0644)
+	if err != nil {
+		return fmt.Errorf("error saving file: %w", err)
+	}
+
+	fmt.Printf("Merged into '%s': %d updated, %d added, %d marked as removed\n", repofile, updatedCount, len(newRepos), removedCount)
+
+	return nil
+}
+
+// Removes the role fields from the extra fields of a repo, and returns their principals by role name.
+func takeRoleFields(repo *Repo) map[string][]string {
+	roleFields := make(map[string][]string)
+	for _, role := range repoRoles {
+		if value, ok := repo.ExtraFields[role.FieldName()]; ok {
+			roleFields[role.Name] = getPropertyValues(value)
+			delete(repo.ExtraFields, role.FieldName())
+		}
+	}
+	if len(repo.ExtraFields) == 0 {
+		repo.ExtraFields = nil
+	}
+	return roleFields
+}
+
+// Returns the principals of role fields that have all actions of their role in the generated repos.
+func getMergeRoles(roleFields map[string][]string, generated []Repo) map[string][]string {
+	roles := make(map[string][]string)
+	for _, role := range repoRoles {
+		for _, principal := range roleFields[role.Name] {
+			hasRole := true
+			for _, repo := range generated {
+				for _, list := range getRepoActionLists(&repo) {
+					if slices.Contains(role.Actions, list.action) && !slices.Contains(*list.principals, principal) {
+						hasRole = false
+					}
+				}
+			}
+			if hasRole {
+				roles[role.Name] = append(roles[role.Name], principal)
+			}
+		}
+	}
+	return roles
+}
+
+// Returns a generated repo with its emitted roles expanded into the action lists, to compare
+// it with the expanded repos of the file.
+func getExpandedMergeRepo(repo Repo) Repo {
+	repo.Roles = maps.Clone(repo.Roles)
+	repo.ExtraFields = maps.Clone(repo.ExtraFields)
+	for _, list := range getRepoActionLists(&repo) {
+		*list.principals = slices.Clone(*list.principals)
+	}
+	ExpandRoles(&repo, repoRoles)
+	return repo
+}
+
+// Returns the value to write for a field of a repo with defaults, so that applying the defaults
+// gives the desired value. Appended fields are lists the repo values are appended to, the
+// others replace their default. Returns true if the field must be overridden, because the
+// desired value can't be reached with the default.
+func getMergeValueWithoutDefault(desired any, defaultValue any, ordered bool, appended bool) (any, bool) {
+	switch desired := desired.(type) {
+	case string:
+		if desired == defaultValue.(string) {
+			return "", false
+		}
+		return desired, desired == ""
+	case []string:
+		defaultValues := defaultValue.([]string)
+		if !appended {
+			if equalStringSlices(desired, defaultValues) {
+				return []string(nil), false
+			}
+			return desired, len(desired) == 0
+		}
+		if ordered {
+			if len(desired) >= len(defaultValues) && slices.Equal(desired[:len(defaultValues)], defaultValues) {
+				return desired[len(defaultValues):], false
+			}
+			return desired, true
+		}
+		if slices.ContainsFunc(defaultValues, func(v string) bool { return !slices.Contains(desired, v) }) {
+			return desired, true
+		}
+		return slices.DeleteFunc(slices.Clone(desired), func(v string) bool { return slices.Contains(defaultValues, v) }), false
+	}
+	return desired, false
+}
+
+// Leaves out the values of a new repo that come from the defaults, and overrides the fields
+// the defaults would change. Properties are only compared when they were generated.
+func removeMergeDefaults(repo *Repo, defaults *Repo, properties bool) {
+	var override []string
+
+	defaultScalars := getRepoScalarFields(defaults)
+	for i, field := range getRepoScalarFields(repo) {
+		value, overridden := getMergeValueWithoutDefault(*field.value, *defaultScalars[i].value, false, false)
+		*field.value = value.(string)
+		if overridden {
+			override = append(override, field.key)
+		}
+	}
+
+	defaultLists := getRepoListFields(defaults)
+	for i, field := range getRepoListFields(repo) {
+		value, overridden := getMergeValueWithoutDefault(*field.values, *defaultLists[i].values, field.key == "repositories", true)
+		*field.values = value.([]string)
+		if len(*field.values) == 0 {
+			*field.values = nil
+		}
+		if overridden {
+			override = append(override, field.key)
+		}
+	}
+
+	if properties {
+		repo.ExtraFields = maps.Clone(repo.ExtraFields)
+		keys := slices.Collect(maps.Keys(defaults.ExtraFields))
+		slices.Sort(keys)
+		for _, key := range keys {
+			value, overridden := getMergeValueWithoutDefault(getPropertyValues(repo.ExtraFields[key]), getPropertyValues(defaults.ExtraFields[key]), false, false)
+			if isEmptyMergeFieldValue(value) {
+				delete(repo.ExtraFields, key)
+			}
+			if overridden {
+				override = append(override, key)
+			}
+		}
+	}
+
+	repo.Override = override
+}
+
+// Extra fields are the generated properties, compared by their property values.
+func getExtraMergeFields(existing Repo, generated []Repo) []mergeField {
+	keys := slices.Collect(maps.Keys(existing.ExtraFields))
+	for _, repo := range generated {
+		keys = append(keys, slices.Collect(maps.Keys(repo.ExtraFields))...)
+	}
+	slices.Sort(keys)
+
+	var fields []mergeField
+	for _, key := range slices.Compact(keys) {
+		fields = append(fields, mergeField{
+			key: key,
+			get: func(r Repo) any { return getPropertyValues(r.ExtraFields[key]) },
+			set: func(r Repo) any { return r.ExtraFields[key] },
+		})
+	}
+
+	return fields
+}
+
+// Returns the value of a field in the generated repos of a names group, if they all agree.
+func getMergeFieldValue(field mergeField, generated []Repo) (any, bool) {
+	value := field.get(generated[0])
+	for _, repo := range generated[1:] {
+		if !equalMergeFieldValues(field, value, field.get(repo)) {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func equalMergeFieldValues(field mergeField, a any, b any) bool {
+	switch a := a.(type) {
+	case string:
+		return a == b.(string)
+	case []string:
+		if field.ordered {
+			return slices.Equal(a, b.([]string))
+		}
+		return equalStringSlices(a, b.([]string))
+	}
+	return false
+}
+
+// Sets, adds or removes a key in a mapping, keeping the style and comment of replaced values.
+func setMappingValue(mapping *ast.MappingNode, key string, value any) error {
+	index := slices.IndexFunc(mapping.Values, func(mv *ast.MappingValueNode) bool { return mv.Key.String() == key })
+
+	if isEmptyMergeFieldValue(value) {
+		if index != -1 {
+			mapping.Values = slices.Delete(mapping.Values, index, index+1)
+		}
+		return nil
+	}
+
+	if index == -1 {
+		node, err := yaml.ValueToNode(yaml.MapSlice{{Key: key, Value: value}})
+		if err != nil {
+			return err
+		}
+		added, ok := node.(*ast.MappingNode)
+		if !ok {
+			return fmt.Errorf("unexpected yaml node type: %s", node.Type())
+		}
+		mapping.Merge(added)
+		return nil
+	}
+
+	node, err := yaml.ValueToNode(value)
+	if err != nil {
+		return err
+	}
+
+	existing := mapping.Values[index]
+	if existingSeq, ok := existing.Value.(*ast.SequenceNode); ok && existingSeq.IsFlowStyle {
+		if seq, ok := node.(*ast.SequenceNode); ok {
+			seq.SetIsFlowStyle(true)
+		}
+	}
+	if comment := existing.Value.GetComment(); comment != nil {
+		node.SetComment(comment)
+	}
+
+	return existing.Replace(node)
+}
+
+func isEmptyMergeFieldValue(value any) bool {
+	switch value := value.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case []string:
+		return len(value) == 0
+	case []any:
+		return len(value) == 0
+	}
+	return false
+}
+
+// Adds a head comment to a list entry, unless it's already marked. Returns whether it was added.
+func markRemovedRepo(seq *ast.SequenceNode, index int, names []string, removed []string) bool {
+	text := removedRepoComment
+	if len(names) > 1 {
+		text += ": " + strings.Join(removed, ", ")
+	}
+
+	headComment := seq.ValueHeadComments[index]
+	if headComment != nil {
+		for _, comment := range headComment.Comments {
+			if strings.TrimSpace(comment.Token.Value) == strings.TrimSpace(text) {
+				return false
+			}
+		}
+	}
+
+	tk := token.Comment(text, "#"+text, &token.Position{})
+	if headComment == nil {
+		seq.ValueHeadComments[index] = ast.CommentGroup([]*token.Token{tk})
+	} else {
+		headComment.Comments = append(headComment.Comments, ast.Comment(tk))
+	}
+
+	return true
+}
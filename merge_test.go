@@ -0,0 +1,318 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateMerge(t *testing.T) {
+	existing := `# Team repos
+- name: repo1 # the first
+  description: old description # keep this comment
+  rclass: local
+  read: [user1, user2]
+  custom: keep me
+
+# grouped
+- names:
+  - repo2
+  - repo3
+  write:
+  - group1
+
+- name: filtered
+  description: not generated, but exists
+
+- name: gone
+`
+
+	repos := []ArtifactoryRepoDetailsResponse{
+		{Key: "repo1", Description: "new description", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "repo2", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "repo3", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "new1", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "new2", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "filtered", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+	}
+	permissiondetails := []ArtifactoryPermissionDetails{
+		{Name: "repo1", Resources: ArtifactoryPermissionDetailsResources{Artifact: ArtifactoryPermissionDetailsArtifact{
+			Actions: ArtifactoryPermissionDetailsActions{Users: map[string][]string{"user1": {"READ"}, "user3": {"READ"}}},
+			Targets: map[string]ArtifactoryPermissionDetailsTarget{"repo1": {IncludePatterns: []string{"**"}}},
+		}}},
+		{Name: "repo2", Resources: ArtifactoryPermissionDetailsResources{Artifact: ArtifactoryPermissionDetailsArtifact{
+			Actions: ArtifactoryPermissionDetailsActions{Groups: map[string][]string{"group1": {"WRITE"}}},
+			Targets: map[string]ArtifactoryPermissionDetailsTarget{"repo2": {IncludePatterns: []string{"**"}}},
+		}}},
+		{Name: "repo3", Resources: ArtifactoryPermissionDetailsResources{Artifact: ArtifactoryPermissionDetailsArtifact{
+			Actions: ArtifactoryPermissionDetailsActions{Groups: map[string][]string{"group1": {"WRITE"}}},
+			Targets: map[string]ArtifactoryPermissionDetailsTarget{"repo3": {IncludePatterns: []string{"**"}}},
+		}}},
+		{Name: "filtered", Resources: ArtifactoryPermissionDetailsResources{Artifact: ArtifactoryPermissionDetailsArtifact{
+			Targets: map[string]ArtifactoryPermissionDetailsTarget{"filtered": {IncludePatterns: []string{"*.jar"}}},
+		}}},
+	}
+
+	filename := filepath.Join(t.TempDir(), "repos.yaml")
+	os.WriteFile(filename, []byte(existing), 0644)
+
+	err := Generate(nil, "", "", repos, permissiondetails, filename, GenerateConfig{OnlyCleanRepos: true, Combine: true, Merge: true})
+	if err != nil {
+		t.Fatalf("GenerateMerge: error = %v", err)
+	}
+
+	want := `# Team repos
+- name: repo1 # the first
+  description: new description # keep this comment
+  rclass: local
+  read: [user1, user3]
+  custom: keep me
+
+# grouped
+- names:
+  - repo2
+  - repo3
+  write:
+  - group1
+
+- name: filtered
+  description: not generated, but exists
+
+# artsync: removed from Artifactory
+- name: gone
+- names:
+  - new1
+  - new2
+`
+	data, _ := os.ReadFile(filename)
+	if string(data) != want {
+		t.Errorf("GenerateMerge: output mismatch:\nGot:\n%s\nWant:\n%s", string(data), want)
+	}
+
+	// Merging again doesn't change anything.
+	err = Generate(nil, "", "", repos, permissiondetails, filename, GenerateConfig{OnlyCleanRepos: true, Combine: true, Merge: true})
+	if err != nil {
+		t.Fatalf("GenerateMerge: second merge error = %v", err)
+	}
+	data, _ = os.ReadFile(filename)
+	if string(data) != want {
+		t.Errorf("GenerateMerge: second merge changed output:\n%s", string(data))
+	}
+}
+
+func TestGenerateMergeGroupDiffers(t *testing.T) {
+	existing := `- names: [repo1, repo2]
+  description: shared
+`
+	repos := []ArtifactoryRepoDetailsResponse{
+		{Key: "repo1", Description: "changed", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "repo2", Description: "shared", Rclass: "local", PackageType: "maven", RepoLayoutRef: "simple-default"},
+	}
+
+	filename := filepath.Join(t.TempDir(), "repos.yaml")
+	os.WriteFile(filename, []byte(existing), 0644)
+
+	err := Generate(nil, "", "", repos, nil, filename, GenerateConfig{Merge: true})
+	if err != nil {
+		t.Fatalf("GenerateMergeGroupDiffers: error = %v", err)
+	}
+
+	data, _ := os.ReadFile(filename)
+	if string(data) != existing {
+		t.Errorf("GenerateMergeGroupDiffers: fields that differ within the group were merged:\n%s", string(data))
+	}
+}
+
+func TestGenerateMergeProperties(t *testing.T) {
+	existing := `- name: repo1
+  team: old # owning team
+  stale: x
+`
+	repos := []ArtifactoryRepoDetailsResponse{{Key: "repo1", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"}}
+
+	client := mockHTTPClient(func(req *http.Request) (*http.Response, error) {
+		response := `{"properties":{"p.team":["new"],"p.owners":["a","b"]}}`
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(response)), Header: make(http.Header)}, nil
+	})
+
+	filename := filepath.Join(t.TempDir(), "repos.yaml")
+	os.WriteFile(filename, []byte(existing), 0644)
+
+	config := GenerateConfig{Merge: true, Properties: PropertiesConfig{SetProperties: true, Prefix: "p"}}
+	err := Generate(client, "", "", repos, nil, filename, config)
+	if err != nil {
+		t.Fatalf("GenerateMergeProperties: error = %v", err)
+	}
+
+	want := `- name: repo1
+  team: new # owning team
+  owners:
+  - a
+  - b
+`
+	data, _ := os.ReadFile(filename)
+	if string(data) != want {
+		t.Errorf("GenerateMergeProperties: output mismatch:\nGot:\n%s\nWant:\n%s", string(data), want)
+	}
+}
+
+func TestGenerateMergeWithDefaults(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "_defaults.yaml"), []byte("description: team repos\nread: [readers]\n"), 0644)
+
+	existing := `defaults:
+  write: [deployers] # file default
+---
+# Team repos
+- name: repo1
+  read: [user1]
+
+- name: repo2
+  description: special
+`
+
+	repos := []ArtifactoryRepoDetailsResponse{
+		{Key: "repo1", Description: "team repos", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "repo2", Description: "team repos", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "new1", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+	}
+	permission := func(name string, users map[string][]string, groups map[string][]string) ArtifactoryPermissionDetails {
+		return ArtifactoryPermissionDetails{Name: name, Resources: ArtifactoryPermissionDetailsResources{Artifact: ArtifactoryPermissionDetailsArtifact{
+			Actions: ArtifactoryPermissionDetailsActions{Users: users, Groups: groups},
+			Targets: map[string]ArtifactoryPermissionDetailsTarget{name: {IncludePatterns: []string{"**"}}},
+		}}}
+	}
+	permissiondetails := []ArtifactoryPermissionDetails{
+		permission("repo1", map[string][]string{"user1": {"READ"}, "user3": {"READ"}}, map[string][]string{"readers": {"READ"}, "deployers": {"WRITE"}}),
+		permission("repo2", nil, map[string][]string{"readers": {"READ"}, "deployers": {"WRITE"}}),
+		permission("new1", nil, map[string][]string{"readers": {"READ"}}),
+	}
+
+	filename := filepath.Join(dir, "repos.yaml")
+	os.WriteFile(filename, []byte(existing), 0644)
+
+	err := Generate(nil, "", "", repos, permissiondetails, filename, GenerateConfig{Merge: true})
+	if err != nil {
+		t.Fatalf("GenerateMergeWithDefaults: error = %v", err)
+	}
+
+	want := `defaults:
+  write: [deployers] # file default
+---
+# Team repos
+- name: repo1
+  read: [user1, user3]
+
+- name: repo2
+- name: new1
+  override:
+  - description
+  - write
+`
+	data, _ := os.ReadFile(filename)
+	if string(data) != want {
+		t.Errorf("GenerateMergeWithDefaults: output mismatch:\nGot:\n%s\nWant:\n%s", string(data), want)
+	}
+
+	// Merging again doesn't change anything.
+	err = Generate(nil, "", "", repos, permissiondetails, filename, GenerateConfig{Merge: true})
+	if err != nil {
+		t.Fatalf("GenerateMergeWithDefaults: second merge error = %v", err)
+	}
+	data, _ = os.ReadFile(filename)
+	if string(data) != want {
+		t.Errorf("GenerateMergeWithDefaults: second merge changed output:\n%s", string(data))
+	}
+
+	// With the defaults applied, the merged repos are the generated ones.
+	loaded := LoadRepoFiles([]string{filename}, false)
+	if len(loaded) != 3 {
+		t.Fatalf("GenerateMergeWithDefaults: got %d loaded repos, want 3", len(loaded))
+	}
+	if !equalStringSlices(loaded[0].Read, []string{"readers", "user1", "user3"}) || !equalStringSlices(loaded[0].Write, []string{"deployers"}) {
+		t.Errorf("GenerateMergeWithDefaults: got repo1 %+v", loaded[0])
+	}
+	if loaded[1].Description != "team repos" {
+		t.Errorf("GenerateMergeWithDefaults: got repo2 %+v", loaded[1])
+	}
+	if loaded[2].Description != "" || len(loaded[2].Write) != 0 || !equalStringSlices(loaded[2].Read, []string{"readers"}) {
+		t.Errorf("GenerateMergeWithDefaults: got new1 %+v", loaded[2])
+	}
+}
+
+func TestGenerateMergeRoles(t *testing.T) {
+	existing := `- name: repo1
+  deployers: [userx]
+- name: repo2
+  readers: [usera]
+  deployers: [userb] # team b
+`
+
+	repos := []ArtifactoryRepoDetailsResponse{
+		{Key: "repo1", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "repo2", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+	}
+	permission := func(name string, users map[string][]string) ArtifactoryPermissionDetails {
+		return ArtifactoryPermissionDetails{Name: name, Resources: ArtifactoryPermissionDetailsResources{Artifact: ArtifactoryPermissionDetailsArtifact{
+			Actions: ArtifactoryPermissionDetailsActions{Users: users},
+			Targets: map[string]ArtifactoryPermissionDetailsTarget{name: {IncludePatterns: []string{"**"}}},
+		}}}
+	}
+	permissiondetails := []ArtifactoryPermissionDetails{
+		// userx lost all permissions, userb lost ANNOTATE and WRITE.
+		permission("repo1", map[string][]string{"usery": {"READ"}}),
+		permission("repo2", map[string][]string{"usera": {"READ"}, "userb": {"READ"}}),
+	}
+
+	filename := filepath.Join(t.TempDir(), "repos.yaml")
+	os.WriteFile(filename, []byte(existing), 0644)
+
+	err := Generate(nil, "", "", repos, permissiondetails, filename, GenerateConfig{Merge: true})
+	if err != nil {
+		t.Fatalf("GenerateMergeRoles: error = %v", err)
+	}
+
+	// With the role fields updated, the loaded repos have the permissions in Artifactory.
+	loaded := LoadRepoFiles([]string{filename}, false)
+	if len(loaded) != 2 {
+		t.Fatalf("GenerateMergeRoles: got %d loaded repos, want 2", len(loaded))
+	}
+	want := []struct {
+		Read  []string
+		Write []string
+	}{
+		{[]string{"usery"}, nil},
+		{[]string{"usera", "userb"}, nil},
+	}
+	for i, repo := range loaded {
+		if !equalStringSlices(repo.Read, want[i].Read) || !equalStringSlices(repo.Write, want[i].Write) || len(repo.Annotate) > 0 {
+			t.Errorf("GenerateMergeRoles (%d/%d): got %+v", i+1, len(want), repo)
+		}
+	}
+
+	wantFile := `- name: repo1
+  read:
+  - usery
+- name: repo2
+  readers: [usera]
+  read:
+  - userb
+`
+	data, _ := os.ReadFile(filename)
+	if string(data) != wantFile {
+		t.Errorf("GenerateMergeRoles: output mismatch:\nGot:\n%s\nWant:\n%s", string(data), wantFile)
+	}
+
+	// Merging again doesn't change anything.
+	err = Generate(nil, "", "", repos, permissiondetails, filename, GenerateConfig{Merge: true})
+	if err != nil {
+		t.Fatalf("GenerateMergeRoles: second merge error = %v", err)
+	}
+	merged, _ := os.ReadFile(filename)
+	if string(merged) != string(data) {
+		t.Errorf("GenerateMergeRoles: second merge changed output:\n%s", string(merged))
+	}
+}
@@ -15,6 +15,7 @@ type ArtifactoryRepoDetailsResponse struct {
 	RepoLayoutRef string   `json:"repoLayoutRef"`
 	Url           string   `json:"url,omitempty"`
 	Repositories  []string `json:"repositories,omitempty"`
+	JsonSource    string   `json:"-"`
 }
 
 type ArtifactoryRepoRequest struct {
@@ -79,32 +80,97 @@ type ArtifactoryGroup struct {
 	GroupName string `json:"group_name"`
 }
 
+type ArtifactoryGroupRequest struct {
+	Name            string `json:"name"`
+	Description     string `json:"description,omitempty"`
+	AutoJoin        bool   `json:"auto_join"`
+	AdminPrivileges bool   `json:"admin_privileges"`
+	Realm           string `json:"realm,omitempty"`
+	RealmAttributes string `json:"realm_attributes,omitempty"`
+}
+
 type ArtifactoryUserRequest struct {
 	Username                 string `json:"username"`
 	Email                    string `json:"email"`
+	Admin                    bool   `json:"admin,omitempty"`
 	InternalPasswordDisabled bool   `json:"internal_password_disabled"`
 }
 
+type ArtifactoryUserDetails struct {
+	Username string   `json:"username"`
+	Email    string   `json:"email"`
+	Admin    bool     `json:"admin"`
+	Realm    string   `json:"realm"`
+	Status   string   `json:"status"`
+	Groups   []string `json:"groups"`
+}
+
+type ArtifactoryGroupDetails struct {
+	Name            string   `json:"name"`
+	Description     string   `json:"description"`
+	AutoJoin        bool     `json:"auto_join"`
+	AdminPrivileges bool     `json:"admin_privileges"`
+	Realm           string   `json:"realm"`
+	RealmAttributes string   `json:"realm_attributes"`
+	Members         []string `json:"members"`
+}
+
+type ArtifactoryGroupMembersRequest struct {
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+}
+
+// Users and groups in a principals file. Passwords are never part of it.
+type Principals struct {
+	Groups []PrincipalGroup `json:"groups,omitempty"`
+	Users  []PrincipalUser  `json:"users,omitempty"`
+}
+
+type PrincipalGroup struct {
+	Name            string   `json:"name"`
+	Description     string   `json:"description,omitempty"`
+	AutoJoin        bool     `json:"autoJoin,omitempty"`
+	Admin           bool     `json:"admin,omitempty"`
+	Realm           string   `json:"realm,omitempty"`
+	RealmAttributes string   `json:"realmAttributes,omitempty"`
+	Members         []string `json:"members,omitempty"`
+}
+
+type PrincipalUser struct {
+	Name   string   `json:"name"`
+	Email  string   `json:"email,omitempty"`
+	Realm  string   `json:"realm,omitempty"`
+	Admin  bool     `json:"admin,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
 type Repo struct {
-	Name           string         `json:"name,omitempty"`
-	Names          []string       `json:"names,omitempty"`
-	Description    string         `json:"description,omitempty"`
-	Rclass         string         `json:"rclass,omitempty"`
-	PackageType    string         `json:"packageType,omitempty"`
-	Layout         string         `json:"layout,omitempty"`
-	Url            string         `json:"url,omitempty"`
-	PermissionName string         `json:"permissionName,omitempty"`
-	Read           []string       `json:"read,omitempty"`
-	Annotate       []string       `json:"annotate,omitempty"`
-	Write          []string       `json:"write,omitempty"`
-	Delete         []string       `json:"delete,omitempty"`
-	Manage         []string       `json:"manage,omitempty"`
-	Scan           []string       `json:"scan,omitempty"`
-	Repositories   []string       `json:"repositories,omitempty"`
-	SourceFile     string         `json:"-"`
-	SourceOffset   int            `json:"-"`
-	SourceLine     int            `json:"-"`
-	ExtraFields    map[string]any `json:"-"`
+	Name           string              `json:"name,omitempty"`
+	Names          []string            `json:"names,omitempty"`
+	Matrix         map[string][]string `json:"matrix,omitempty"`
+	Description    string              `json:"description,omitempty"`
+	Rclass         string              `json:"rclass,omitempty"`
+	PackageType    string              `json:"packageType,omitempty"`
+	Layout         string              `json:"layout,omitempty"`
+	Url            string              `json:"url,omitempty"`
+	PermissionName string              `json:"permissionName,omitempty"`
+	Read           []string            `json:"read,omitempty"`
+	Annotate       []string            `json:"annotate,omitempty"`
+	Write          []string            `json:"write,omitempty"`
+	Delete         []string            `json:"delete,omitempty"`
+	Manage         []string            `json:"manage,omitempty"`
+	Scan           []string            `json:"scan,omitempty"`
+	Repositories   []string            `json:"repositories,omitempty"`
+	Override       []string            `json:"override,omitempty"`
+	Inherit        *bool               `json:"inherit,omitempty"`
+	SourceFile     string              `json:"-"`
+	SourceOffset   int                 `json:"-"`
+	SourceLine     int                 `json:"-"`
+	SourceVariant  string              `json:"-"`
+	ExtraFields    map[string]any      `json:"-"`
+
+	// Principals of roles, by role name. Only set when generating with roles.
+	Roles map[string][]string `json:"-"`
 }
 
 type ArtifactoryLDAPGroupSettings struct {
@@ -166,6 +232,8 @@ type LdapConfig struct {
 	Ldapsettings         []ArtifactoryLDAPSettings      `json:"-"`
 	Ldapgroupsettings    []ArtifactoryLDAPGroupSettings `json:"-"`
 	ExcludeSettings      []string                       `json:"excludesettings"`
+	ResolveNestedGroups  bool                           `json:"resolvenestedgroups"`
+	UseTokenImport       bool                           `json:"usetokenimport"`
 }
 
 type PropertiesConfig struct {
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// For mock testing.
+var queryldapNestedGroupsFn = queryldap
+var queryldapEntryFn = queryldapEntry
+
+// Active Directory LDAP_MATCHING_RULE_IN_CHAIN, resolves nested memberships server side.
+const ldapMatchingRuleInChain = "1.2.840.113556.1.4.1941"
+
+const maxNestedGroupDepth = 32
+
+// Object classes of groups and users, in lower case.
+var ldapGroupObjectClasses = []string{"group", "groupofnames", "groupofuniquenames", "groupofurls", "posixgroup"}
+var ldapUserObjectClasses = []string{"person", "organizationalperson", "inetorgperson", "user", "posixaccount"}
+
+// Matches the username attribute in a user search filter, like '(sAMAccountName={0})'.
+var ldapUsernameFilterPattern = regexp.MustCompile(`\(([a-zA-Z][a-zA-Z0-9-]*)=\{0\}\)`)
+
+type nestedGroupMembers struct {
+	GroupName string
+	GroupDn   string
+	Direct    []string
+	Nested    []string
+}
+
+func reportNestedGroupAccess(reposToProvision []Repo, allgroups []ArtifactoryGroup, ldapConfig LdapConfig) {
+	resolved := make(map[string]*nestedGroupMembers)
+
+	for _, repo := range reposToProvision {
+		for _, perm := range []struct {
+			name       string
+			principals []string
+		}{
+			{"READ", repo.Read},
+			{"ANNOTATE", repo.Annotate},
+			{"WRITE", repo.Write},
+			{"DELETE", repo.Delete},
+			{"MANAGE", repo.Manage},
+			{"SCAN", repo.Scan},
+		} {
+			for _, principal := range perm.principals {
+				isGroup := slices.ContainsFunc(allgroups, func(g ArtifactoryGroup) bool {
+					return g.GroupName == principal
+				})
+				if !isGroup {
+					continue
+				}
+
+				members, ok := resolved[principal]
+				if !ok {
+					var err error
+					members, err = resolveNestedGroupMembers(principal, ldapConfig)
+					if err != nil {
+						fmt.Printf("Warning: Couldn't resolve nested members of group '%s': %v\n", principal, err)
+					} else if members == nil {
+						log.Printf("Group '%s' not found in ldap, not resolving nested members.\n", principal)
+					} else if len(members.Direct) == 0 && len(members.Nested) == 0 {
+						fmt.Printf("Warning: Group '%s' has no effective members in ldap, it doesn't grant access to anyone.\n", principal)
+					}
+					resolved[principal] = members
+				}
+
+				if members == nil || len(members.Nested) == 0 {
+					continue
+				}
+				if perm.name != "WRITE" && perm.name != "MANAGE" {
+					continue
+				}
+
+				fmt.Printf("'%s': Group '%s' grants %s to %d users through nested groups: %s\n",
+					repo.Name, principal, perm.name, len(members.Nested), strings.Join(members.Nested, ", "))
+			}
+		}
+	}
+}
+
+func resolveNestedGroupMembers(groupname string, ldapConfig LdapConfig) (*nestedGroupMembers, error) {
+	for _, ldapGroupSettingsSingle := range ldapConfig.Ldapgroupsettings {
+		settingsIndex := slices.IndexFunc(ldapConfig.Ldapsettings, func(s ArtifactoryLDAPSettings) bool {
+			return s.Key == ldapGroupSettingsSingle.EnabledLdap
+		})
+		if settingsIndex == -1 {
+			return nil, fmt.Errorf("LDAP settings named '%s' not found", ldapGroupSettingsSingle.EnabledLdap)
+		}
+		ldapSettingsSingle := ldapConfig.Ldapsettings[settingsIndex]
+
+		memberAttribute := ldapGroupSettingsSingle.GroupMemberAttribute
+		if memberAttribute == "" {
+			memberAttribute = "member"
+		}
+
+		basedn := getLdapBaseDn(ldapSettingsSingle.LdapUrl, ldapGroupSettingsSingle.GroupBaseDn)
+
+		var filter string
+		if ldapGroupSettingsSingle.Filter != "" {
+			filter = fmt.Sprintf("(&%s(%s=%s))", ldapGroupSettingsSingle.Filter, ldapGroupSettingsSingle.GroupNameAttribute, ldap.EscapeFilter(groupname))
+		} else {
+			filter = fmt.Sprintf("(%s=%s)", ldapGroupSettingsSingle.GroupNameAttribute, ldap.EscapeFilter(groupname))
+		}
+
+		entries, err := queryldapNestedGroupsFn(
+			ldapSettingsSingle.LdapUrl,
+			basedn,
+			filter,
+			ldapConfig.LdapUsername,
+			ldapConfig.LdapPassword,
+			[]string{memberAttribute})
+		if err != nil {
+			return nil, fmt.Errorf("query failed: %w", err)
+		}
+		if len(entries) < 1 {
+			continue
+		}
+		if len(entries) > 1 {
+			return nil, fmt.Errorf("error: multiple DNs found for group: '%s'", groupname)
+		}
+		group := entries[0]
+
+		members := &nestedGroupMembers{GroupName: groupname, GroupDn: group.DN}
+
+		if strings.EqualFold(ldapGroupSettingsSingle.Strategy, "DYNAMIC") {
+			err = resolveMembersInChain(members, ldapSettingsSingle, ldapConfig)
+		} else {
+			err = resolveMembersRecursively(members, group.GetAttributeValues(memberAttribute), memberAttribute, ldapSettingsSingle, ldapConfig)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		slices.Sort(members.Direct)
+		slices.Sort(members.Nested)
+
+		log.Printf("Group '%s' (%s): %d direct members, %d nested members\n", groupname, group.DN, len(members.Direct), len(members.Nested))
+
+		return members, nil
+	}
+
+	return nil, nil
+}
+
+// Dynamic groups in Active Directory are resolved through the memberOf attribute of the users.
+func resolveMembersInChain(members *nestedGroupMembers, ldapSettings ArtifactoryLDAPSettings, ldapConfig LdapConfig) error {
+	escapedDn := ldap.EscapeFilter(members.GroupDn)
+	usernameAttribute := getLdapUsernameAttribute(ldapSettings)
+	attrs := []string{"objectClass", usernameAttribute}
+
+	for basednPart := range strings.SplitSeq(ldapSettings.Search.SearchBase, "|") {
+		basedn := getLdapBaseDn(ldapSettings.LdapUrl, basednPart)
+
+		direct, err := queryldapNestedGroupsFn(
+			ldapSettings.LdapUrl,
+			basedn,
+			fmt.Sprintf("(memberOf=%s)", escapedDn),
+			ldapConfig.LdapUsername,
+			ldapConfig.LdapPassword,
+			attrs)
+		if err != nil {
+			return fmt.Errorf("query failed: %w", err)
+		}
+
+		inChain, err := queryldapNestedGroupsFn(
+			ldapSettings.LdapUrl,
+			basedn,
+			fmt.Sprintf("(memberOf:%s:=%s)", ldapMatchingRuleInChain, escapedDn),
+			ldapConfig.LdapUsername,
+			ldapConfig.LdapPassword,
+			attrs)
+		if err != nil {
+			return fmt.Errorf("query failed: %w", err)
+		}
+
+		var directDns []string
+		for _, entry := range direct {
+			directDns = append(directDns, entry.DN)
+			if name, ok := getLdapUsername(entry, usernameAttribute); ok {
+				members.Direct = appendUnique(members.Direct, name)
+			}
+		}
+		for _, entry := range inChain {
+			if slices.Contains(directDns, entry.DN) {
+				continue
+			}
+			if name, ok := getLdapUsername(entry, usernameAttribute); ok {
+				members.Nested = appendUnique(members.Nested, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Static and hierarchical groups are resolved by following the member attribute of each group.
+func resolveMembersRecursively(members *nestedGroupMembers, memberDns []string, memberAttribute string, ldapSettings ArtifactoryLDAPSettings, ldapConfig LdapConfig) error {
+	visited := map[string]bool{strings.ToLower(members.GroupDn): true}
+	usernameAttribute := getLdapUsernameAttribute(ldapSettings)
+
+	type pending struct {
+		dn    string
+		depth int
+	}
+	var queue []pending
+	for _, dn := range memberDns {
+		queue = append(queue, pending{dn: dn, depth: 0})
+	}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		if visited[strings.ToLower(current.dn)] {
+			continue
+		}
+		visited[strings.ToLower(current.dn)] = true
+
+		entry, err := queryldapEntryFn(
+			ldapSettings.LdapUrl,
+			current.dn,
+			ldapConfig.LdapUsername,
+			ldapConfig.LdapPassword,
+			[]string{"objectClass", memberAttribute, usernameAttribute})
+		if err != nil {
+			return fmt.Errorf("query failed: %w", err)
+		}
+		if entry == nil {
+			fmt.Printf("Warning: Ignoring member of group '%s' not found in ldap: '%s'\n", members.GroupName, current.dn)
+			continue
+		}
+
+		if !isLdapGroup(entry) {
+			name, ok := getLdapUsername(entry, usernameAttribute)
+			if !ok {
+				continue
+			}
+			if current.depth == 0 {
+				members.Direct = appendUnique(members.Direct, name)
+			} else if !slices.Contains(members.Direct, name) {
+				members.Nested = appendUnique(members.Nested, name)
+			}
+			continue
+		}
+
+		if current.depth+1 >= maxNestedGroupDepth {
+			fmt.Printf("Warning: Group nesting too deep, not following group: '%s'\n", current.dn)
+			continue
+		}
+
+		for _, dn := range entry.GetAttributeValues(memberAttribute) {
+			queue = append(queue, pending{dn: dn, depth: current.depth + 1})
+		}
+	}
+
+	members.Nested = slices.DeleteFunc(members.Nested, func(name string) bool {
+		return slices.Contains(members.Direct, name)
+	})
+
+	return nil
+}
+
+// Returns the attribute users log in with, from the user search filter. Defaults to 'uid'.
+func getLdapUsernameAttribute(ldapSettings ArtifactoryLDAPSettings) string {
+	match := ldapUsernameFilterPattern.FindStringSubmatch(ldapSettings.Search.SearchFilter)
+	if match == nil {
+		return "uid"
+	}
+	return match[1]
+}
+
+func hasLdapObjectClass(entry *ldap.Entry, objectClasses []string) bool {
+	return slices.ContainsFunc(entry.GetAttributeValues("objectClass"), func(objectClass string) bool {
+		return slices.Contains(objectClasses, strings.ToLower(objectClass))
+	})
+}
+
+func isLdapGroup(entry *ldap.Entry) bool {
+	return hasLdapObjectClass(entry, ldapGroupObjectClasses)
+}
+
+// Returns the username of a user entry. Entries that aren't users or have no username are
+// ignored with a warning.
+func getLdapUsername(entry *ldap.Entry, usernameAttribute string) (string, bool) {
+	if !hasLdapObjectClass(entry, ldapUserObjectClasses) {
+		if !isLdapGroup(entry) {
+			fmt.Printf("Warning: Ignoring ldap member that is neither a user nor a group: '%s'\n", entry.DN)
+		}
+		return "", false
+	}
+
+	username := entry.GetAttributeValue(usernameAttribute)
+	if username == "" {
+		fmt.Printf("Warning: Ignoring ldap user without username attribute '%s': '%s'\n", usernameAttribute, entry.DN)
+		return "", false
+	}
+	return username, true
+}
+
+func appendUnique(values []string, value string) []string {
+	if slices.Contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
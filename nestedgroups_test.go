@@ -0,0 +1,145 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestResolveNestedGroupMembersStatic(t *testing.T) {
+	ldapConfig := LdapConfig{
+		Ldapsettings: []ArtifactoryLDAPSettings{
+			{
+				Key:     "ldapsettings",
+				LdapUrl: "ldap://ldap.example.com",
+				Search:  ArtifactoryLDAPSettingsSearch{SearchFilter: "(&(objectClass=user)(sAMAccountName={0}))"},
+			},
+		},
+		Ldapgroupsettings: []ArtifactoryLDAPGroupSettings{
+			{
+				Name:                 "groupsettings",
+				EnabledLdap:          "ldapsettings",
+				GroupBaseDn:          "ou=groups,dc=example,dc=com",
+				GroupNameAttribute:   "cn",
+				GroupMemberAttribute: "member",
+				Strategy:             "STATIC",
+			},
+		},
+	}
+
+	entries := map[string]map[string][]string{
+		"cn=team-x,ou=groups,dc=example,dc=com": {"objectClass": {"top", "group"}, "member": {
+			"cn=Alice Smith,ou=users,dc=example,dc=com",
+			"cn=team-x-sub,ou=groups,dc=example,dc=com",
+			"cn=team-empty,ou=groups,dc=example,dc=com",
+			"cn=deleted,ou=users,dc=example,dc=com",
+		}},
+		"cn=team-x-sub,ou=groups,dc=example,dc=com": {"objectClass": {"top", "group"}, "member": {
+			"cn=Bob Jones,ou=users,dc=example,dc=com",
+			"cn=Alice Smith,ou=users,dc=example,dc=com",
+			"cn=team-x,ou=groups,dc=example,dc=com",
+		}},
+		"cn=team-empty,ou=groups,dc=example,dc=com": {"objectClass": {"top", "group"}},
+		"cn=Alice Smith,ou=users,dc=example,dc=com": {"objectClass": {"top", "person", "user"}, "sAMAccountName": {"alice"}},
+		"cn=Bob Jones,ou=users,dc=example,dc=com":   {"objectClass": {"top", "person", "user"}, "sAMAccountName": {"bob"}},
+	}
+
+	origQuery := queryldapNestedGroupsFn
+	origQueryEntry := queryldapEntryFn
+	defer func() {
+		queryldapNestedGroupsFn = origQuery
+		queryldapEntryFn = origQueryEntry
+	}()
+
+	queryldapNestedGroupsFn = func(server, baseDN, filter, bindDN, bindPW string, attrs []string) ([]*ldap.Entry, error) {
+		if filter == "(cn=team-x)" {
+			dn := "cn=team-x,ou=groups,dc=example,dc=com"
+			return []*ldap.Entry{ldap.NewEntry(dn, entries[dn])}, nil
+		}
+		t.Errorf("ResolveNestedGroupMembersStatic: unexpected ldap query: baseDN='%s', filter='%s'", baseDN, filter)
+		return []*ldap.Entry{}, nil
+	}
+	queryldapEntryFn = func(server, dn, bindDN, bindPW string, attrs []string) (*ldap.Entry, error) {
+		if !slices.Contains(attrs, "sAMAccountName") {
+			t.Errorf("ResolveNestedGroupMembersStatic: username attribute not queried: %v", attrs)
+		}
+		attributes, ok := entries[dn]
+		if !ok {
+			return nil, nil
+		}
+		return ldap.NewEntry(dn, attributes), nil
+	}
+
+	members, err := resolveNestedGroupMembers("team-x", ldapConfig)
+	if err != nil {
+		t.Fatalf("ResolveNestedGroupMembersStatic: error = %v", err)
+	}
+	if members == nil {
+		t.Fatalf("ResolveNestedGroupMembersStatic: group not found")
+	}
+	if !slices.Equal(members.Direct, []string{"alice"}) {
+		t.Errorf("ResolveNestedGroupMembersStatic: got direct members %v, want %v", members.Direct, []string{"alice"})
+	}
+	if !slices.Equal(members.Nested, []string{"bob"}) {
+		t.Errorf("ResolveNestedGroupMembersStatic: got nested members %v, want %v", members.Nested, []string{"bob"})
+	}
+}
+
+func TestResolveNestedGroupMembersDynamic(t *testing.T) {
+	ldapConfig := LdapConfig{
+		Ldapsettings: []ArtifactoryLDAPSettings{
+			{
+				Key:     "ldapsettings",
+				LdapUrl: "ldap://ldap.example.com/dc=example,dc=com",
+				Search:  ArtifactoryLDAPSettingsSearch{SearchBase: "ou=users", SearchFilter: "(sAMAccountName={0})"},
+			},
+		},
+		Ldapgroupsettings: []ArtifactoryLDAPGroupSettings{
+			{
+				Name:               "groupsettings",
+				EnabledLdap:        "ldapsettings",
+				GroupBaseDn:        "ou=groups",
+				GroupNameAttribute: "cn",
+				Strategy:           "DYNAMIC",
+			},
+		},
+	}
+
+	alice := ldap.NewEntry("cn=Alice Smith,ou=users,dc=example,dc=com", map[string][]string{"objectClass": {"user"}, "sAMAccountName": {"alice"}})
+	carol := ldap.NewEntry("cn=Carol King,ou=users,dc=example,dc=com", map[string][]string{"objectClass": {"user"}, "sAMAccountName": {"carol"}})
+	sub := ldap.NewEntry("cn=team-x-sub,ou=groups,dc=example,dc=com", map[string][]string{"objectClass": {"group"}})
+
+	origQuery := queryldapNestedGroupsFn
+	defer func() { queryldapNestedGroupsFn = origQuery }()
+
+	queryldapNestedGroupsFn = func(server, baseDN, filter, bindDN, bindPW string, attrs []string) ([]*ldap.Entry, error) {
+		switch {
+		case filter == "(cn=team-x)":
+			return []*ldap.Entry{ldap.NewEntry("cn=team-x,ou=groups,dc=example,dc=com", nil)}, nil
+		case baseDN != "ou=users,dc=example,dc=com":
+			t.Errorf("ResolveNestedGroupMembersDynamic: unexpected baseDN '%s'", baseDN)
+		case filter == "(memberOf=cn=team-x,ou=groups,dc=example,dc=com)":
+			return []*ldap.Entry{alice, sub}, nil
+		case strings.HasPrefix(filter, "(memberOf:"+ldapMatchingRuleInChain+":="):
+			return []*ldap.Entry{alice, sub, carol}, nil
+		}
+		t.Errorf("ResolveNestedGroupMembersDynamic: unexpected ldap query: baseDN='%s', filter='%s'", baseDN, filter)
+		return []*ldap.Entry{}, nil
+	}
+
+	members, err := resolveNestedGroupMembers("team-x", ldapConfig)
+	if err != nil {
+		t.Fatalf("ResolveNestedGroupMembersDynamic: error = %v", err)
+	}
+	if members == nil {
+		t.Fatalf("ResolveNestedGroupMembersDynamic: group not found")
+	}
+	if !slices.Equal(members.Direct, []string{"alice"}) {
+		t.Errorf("ResolveNestedGroupMembersDynamic: got direct members %v, want %v", members.Direct, []string{"alice"})
+	}
+	if !slices.Equal(members.Nested, []string{"carol"}) {
+		t.Errorf("ResolveNestedGroupMembersDynamic: got nested members %v, want %v", members.Nested, []string{"carol"})
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Retrieves the details of all users and groups and saves them as a principals file, which
+// can be provisioned with -principals-file. Passwords can't be retrieved and are not saved.
+func GeneratePrincipals(
+	client *http.Client,
+	baseurl string,
+	token string,
+	allusers []ArtifactoryUser,
+	allgroups []ArtifactoryGroup,
+	filename string,
+	generatejson bool,
+	concurrency int) error {
+
+	groups := make([]PrincipalGroup, len(allgroups))
+	err := runConcurrently(concurrency, len(allgroups), func(i int) error {
+		details, err := getGroupDetails(client, baseurl, token, allgroups[i].GroupName)
+		if err != nil {
+			return fmt.Errorf("error retrieving group '%s': %w", allgroups[i].GroupName, err)
+		}
+		groups[i] = getPrincipalGroup(*details)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	users := make([]PrincipalUser, len(allusers))
+	err = runConcurrently(concurrency, len(allusers), func(i int) error {
+		details, err := getUserDetails(client, baseurl, token, allusers[i].Username)
+		if err != nil {
+			return fmt.Errorf("error retrieving user '%s': %w", allusers[i].Username, err)
+		}
+		users[i] = getPrincipalUser(*details)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slices.SortFunc(groups, func(a, b PrincipalGroup) int { return cmp.Compare(a.Name, b.Name) })
+	slices.SortFunc(users, func(a, b PrincipalUser) int { return cmp.Compare(a.Name, b.Name) })
+
+	principals := Principals{Groups: groups, Users: users}
+
+	var data []byte
+	if generatejson {
+		data, err = json.MarshalIndent(principals, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error generating json: %w", err)
+		}
+	} else {
+		data, err = yaml.Marshal(principals)
+		if err != nil {
+			return fmt.Errorf("error generating yaml: %w", err)
+		}
+	}
+
+	err = os.WriteFile(filename, data, 0644)
+	if err != nil {
+		return fmt.Errorf("error saving file: %w", err)
+	}
+
+	fmt.Printf("Saved %d groups and %d users to '%s'\n", len(groups), len(users), filename)
+
+	return nil
+}
+
+func getPrincipalGroup(details ArtifactoryGroupDetails) PrincipalGroup {
+	members := slices.Clone(details.Members)
+	slices.Sort(members)
+
+	return PrincipalGroup{
+		Name:            details.Name,
+		Description:     details.Description,
+		AutoJoin:        details.AutoJoin,
+		Admin:           details.AdminPrivileges,
+		Realm:           details.Realm,
+		RealmAttributes: details.RealmAttributes,
+		Members:         members,
+	}
+}
+
+func getPrincipalUser(details ArtifactoryUserDetails) PrincipalUser {
+	groups := slices.Clone(details.Groups)
+	slices.Sort(groups)
+
+	return PrincipalUser{
+		Name:   details.Username,
+		Email:  details.Email,
+		Realm:  details.Realm,
+		Admin:  details.Admin,
+		Groups: groups,
+	}
+}
+
+func LoadPrincipalsFile(filename string) (Principals, error) {
+	var principals Principals
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return principals, fmt.Errorf("error reading file: %w", err)
+	}
+
+	// Json is valid yaml.
+	err = yaml.Unmarshal(data, &principals)
+	if err != nil {
+		return principals, fmt.Errorf("error parsing file '%s': %w", filename, err)
+	}
+
+	var errs []error
+	var groupnames, usernames []string
+	for _, group := range principals.Groups {
+		if group.Name == "" {
+			errs = append(errs, fmt.Errorf("group without name"))
+		} else if slices.Contains(groupnames, group.Name) {
+			errs = append(errs, fmt.Errorf("duplicate group '%s'", group.Name))
+		}
+		groupnames = append(groupnames, group.Name)
+	}
+	for _, user := range principals.Users {
+		if user.Name == "" {
+			errs = append(errs, fmt.Errorf("user without name"))
+		} else if slices.Contains(usernames, user.Name) {
+			errs = append(errs, fmt.Errorf("duplicate user '%s'", user.Name))
+		}
+		usernames = append(usernames, user.Name)
+	}
+	if len(errs) > 0 {
+		return principals, fmt.Errorf("invalid principals file '%s': %w", filename, errors.Join(errs...))
+	}
+
+	return principals, nil
+}
+
+// Creates and updates the groups and users of a principals file, and their memberships.
+// Internal groups listing members in the file are made to match exactly, for other groups
+// members are only added. Users are created with the internal password disabled, so they
+// log in through ldap or saml. Returns the users and groups including the created ones.
+func ProvisionPrincipals(
+	client *http.Client,
+	baseurl string,
+	token string,
+	principals Principals,
+	allusers []ArtifactoryUser,
+	allgroups []ArtifactoryGroup,
+	dryRun bool) ([]ArtifactoryUser, []ArtifactoryGroup, error) {
+
+	fmt.Printf("Principals to provision: %d groups, %d users\n", len(principals.Groups), len(principals.Users))
+
+	var errs []error
+	updatedGroups := make(map[string]bool)
+	createdGroups := make(map[string]bool)
+
+	for _, group := range principals.Groups {
+		exists := slices.ContainsFunc(allgroups, func(g ArtifactoryGroup) bool { return g.GroupName == group.Name })
+		if !exists {
+			err := createGroup(client, baseurl, token, group, dryRun)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error creating group '%s': %w", group.Name, err))
+				continue
+			}
+			createdGroups[group.Name] = true
+			allgroups = append(allgroups, ArtifactoryGroup{GroupName: group.Name})
+			stats.CreatedGroupCount++
+			continue
+		}
+
+		updated, err := updateGroup(client, baseurl, token, group, dryRun)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error updating group '%s': %w", group.Name, err))
+			continue
+		}
+		updatedGroups[group.Name] = updated
+	}
+
+	for _, user := range principals.Users {
+		exists := slices.ContainsFunc(allusers, func(u ArtifactoryUser) bool { return u.Username == user.Name })
+		if !exists {
+			err := createPrincipalUser(client, baseurl, token, user, dryRun)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error creating user '%s': %w", user.Name, err))
+				continue
+			}
+			allusers = append(allusers, ArtifactoryUser{Username: user.Name})
+			stats.CreatedUserCount++
+			continue
+		}
+
+		err := updateUser(client, baseurl, token, user, dryRun)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error updating user '%s': %w", user.Name, err))
+		}
+	}
+
+	// Memberships are updated last, when all groups and users exist.
+	for _, groupname := range getPrincipalGroupNames(principals) {
+		var current []string
+		if !createdGroups[groupname] || !dryRun {
+			details, err := getGroupDetails(client, baseurl, token, groupname)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error retrieving group '%s': %w", groupname, err))
+				continue
+			}
+			current = details.Members
+		}
+
+		index := slices.IndexFunc(principals.Groups, func(g PrincipalGroup) bool { return g.Name == groupname })
+		exact := index != -1 && len(principals.Groups[index].Members) > 0 && cmp.Or(principals.Groups[index].Realm, "internal") == "internal"
+
+		members := getDesiredGroupMembers(principals, groupname)
+		request := ArtifactoryGroupMembersRequest{}
+		for _, member := range members {
+			if !slices.Contains(current, member) {
+				request.Add = append(request.Add, member)
+			}
+		}
+		if exact {
+			for _, member := range current {
+				if !slices.Contains(members, member) {
+					request.Remove = append(request.Remove, member)
+				}
+			}
+		}
+		if len(request.Add) == 0 && len(request.Remove) == 0 {
+			continue
+		}
+
+		err := updateGroupMembers(client, baseurl, token, groupname, request, dryRun)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error updating members of group '%s': %w", groupname, err))
+			continue
+		}
+		if !createdGroups[groupname] {
+			updatedGroups[groupname] = true
+		}
+	}
+
+	for _, updated := range updatedGroups {
+		if updated {
+			stats.UpdatedGroupCount++
+		}
+	}
+
+	return allusers, allgroups, errors.Join(errs...)
+}
+
+// Returns the groups of the file and the groups its users are in, sorted.
+func getPrincipalGroupNames(principals Principals) []string {
+	var groupnames []string
+	for _, group := range principals.Groups {
+		groupnames = append(groupnames, group.Name)
+	}
+	for _, user := range principals.Users {
+		groupnames = append(groupnames, user.Groups...)
+	}
+	slices.Sort(groupnames)
+	return slices.Compact(groupnames)
+}
+
+// Members of a group are its listed members and the users listing the group, sorted.
+func getDesiredGroupMembers(principals Principals, groupname string) []string {
+	var members []string
+	for _, group := range principals.Groups {
+		if group.Name == groupname {
+			members = append(members, group.Members...)
+		}
+	}
+	for _, user := range principals.Users {
+		if slices.Contains(user.Groups, groupname) {
+			members = append(members, user.Name)
+		}
+	}
+	slices.Sort(members)
+	return slices.Compact(members)
+}
+
+func createGroup(client *http.Client, baseurl string, token string, group PrincipalGroup, dryRun bool) error {
+	fmt.Printf("Creating group: '%s'\n", group.Name)
+
+	request := ArtifactoryGroupRequest{
+		Name:            group.Name,
+		Description:     group.Description,
+		AutoJoin:        group.AutoJoin,
+		AdminPrivileges: group.Admin,
+		Realm:           group.Realm,
+		RealmAttributes: group.RealmAttributes,
+	}
+
+	err := principalsRequest(client, token, "POST", fmt.Sprintf("%s/access/api/v2/groups", baseurl), request, dryRun)
+	if err != nil {
+		return err
+	}
+
+	if !dryRun {
+		log.Printf("'%s': Created group successfully.\n", group.Name)
+	}
+	return nil
+}
+
+// Updates the changed fields of an existing group. Returns whether it was changed.
+func updateGroup(client *http.Client, baseurl string, token string, group PrincipalGroup, dryRun bool) (bool, error) {
+	details, err := getGroupDetails(client, baseurl, token, group.Name)
+	if err != nil {
+		return false, err
+	}
+
+	changes := make(map[string]any)
+	if details.Description != group.Description {
+		changes["description"] = group.Description
+	}
+	if details.AutoJoin != group.AutoJoin {
+		changes["auto_join"] = group.AutoJoin
+	}
+	if details.AdminPrivileges != group.Admin {
+		changes["admin_privileges"] = group.Admin
+	}
+	if group.Realm != "" && details.Realm != group.Realm {
+		fmt.Printf("'%s': Warning: Group realm differs, '%s' in Artifactory, '%s' in file. Realms are not updated.\n", group.Name, details.Realm, group.Realm)
+	}
+	if len(changes) == 0 {
+		return false, nil
+	}
+
+	fmt.Printf("Updating group: '%s', fields: %s\n", group.Name, strings.Join(slices.Sorted(maps.Keys(changes)), ", "))
+
+	err = principalsRequest(client, token, "PATCH", getGroupUrl(baseurl, group.Name), changes, dryRun)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func updateGroupMembers(client *http.Client, baseurl string, token string, groupname string, request ArtifactoryGroupMembersRequest, dryRun bool) error {
+	fmt.Printf("Updating members of group: '%s', adding: %v, removing: %v\n", groupname, request.Add, request.Remove)
+
+	return principalsRequest(client, token, "PATCH", getGroupUrl(baseurl, groupname)+"/members", request, dryRun)
+}
+
+func createPrincipalUser(client *http.Client, baseurl string, token string, user PrincipalUser, dryRun bool) error {
+	fmt.Printf("Creating user: '%s'\n", user.Name)
+
+	request := ArtifactoryUserRequest{
+		Username:                 user.Name,
+		Email:                    user.Email,
+		Admin:                    user.Admin,
+		InternalPasswordDisabled: true,
+	}
+
+	err := principalsRequest(client, token, "POST", fmt.Sprintf("%s/access/api/v2/users", baseurl), request, dryRun)
+	if err != nil {
+		return err
+	}
+
+	if !dryRun {
+		log.Printf("'%s': Created user successfully.\n", user.Name)
+	}
+	return nil
+}
+
+// Updates the email and admin flag of an existing user, if changed.
+func updateUser(client *http.Client, baseurl string, token string, user PrincipalUser, dryRun bool) error {
+	details, err := getUserDetails(client, baseurl, token, user.Name)
+	if err != nil {
+		return err
+	}
+
+	changes := make(map[string]any)
+	if details.Email != user.Email {
+		changes["email"] = user.Email
+	}
+	if details.Admin != user.Admin {
+		changes["admin"] = user.Admin
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	fmt.Printf("Updating user: '%s', fields: %s\n", user.Name, strings.Join(slices.Sorted(maps.Keys(changes)), ", "))
+
+	err = principalsRequest(client, token, "PATCH", getUserUrl(baseurl, user.Name), changes, dryRun)
+	if err != nil {
+		return err
+	}
+
+	stats.UpdatedUserCount++
+	return nil
+}
+
+func getGroupDetails(client *http.Client, baseurl string, token string, groupname string) (*ArtifactoryGroupDetails, error) {
+	var details ArtifactoryGroupDetails
+	err := getPrincipalDetails(client, token, getGroupUrl(baseurl, groupname), &details)
+	if err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+func getUserDetails(client *http.Client, baseurl string, token string, username string) (*ArtifactoryUserDetails, error) {
+	var details ArtifactoryUserDetails
+	err := getPrincipalDetails(client, token, getUserUrl(baseurl, username), &details)
+	if err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+func getPrincipalDetails(client *http.Client, token string, url string, details any) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := doRequest(client, req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return newHTTPStatusError(req, resp, body)
+	}
+
+	err = json.Unmarshal(body, details)
+	if err != nil {
+		return fmt.Errorf("error parsing response body: %w", err)
+	}
+
+	return nil
+}
+
+func principalsRequest(client *http.Client, token string, method string, url string, payload any, dryRun bool) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error generating json: %w", err)
+	}
+
+	req, err := http.NewRequest(method, url, strings.NewReader(string(data)))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	if dryRun {
+		return nil
+	}
+
+	resp, err := doRequest(client, req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newHTTPStatusError(req, resp, body)
+	}
+
+	return nil
+}
+
+func getGroupUrl(baseurl string, groupname string) string {
+	return fmt.Sprintf("%s/access/api/v2/groups/%s", baseurl, url.PathEscape(groupname))
+}
+
+func getUserUrl(baseurl string, username string) string {
+	return fmt.Sprintf("%s/access/api/v2/users/%s", baseurl, url.PathEscape(username))
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Serves user and group details, and records all other requests as "METHOD path body".
+func mockPrincipalsClient(details map[string]string, requests *[]string) *http.Client {
+	var mutex sync.Mutex
+	return mockHTTPClient(func(req *http.Request) (*http.Response, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		status := 200
+		body := ""
+		if req.Method == "GET" {
+			var ok bool
+			body, ok = details[req.URL.Path]
+			if !ok {
+				status = 404
+			}
+		} else {
+			data, _ := io.ReadAll(req.Body)
+			*requests = append(*requests, req.Method+" "+req.URL.Path+" "+string(data))
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+	})
+}
+
+func TestGeneratePrincipals(t *testing.T) {
+	details := map[string]string{
+		"/access/api/v2/groups/devs":  `{"name":"devs","description":"Developers","auto_join":true,"admin_privileges":false,"realm":"internal","members":["bob","alice"]}`,
+		"/access/api/v2/groups/ops":   `{"name":"ops","realm":"ldap","realm_attributes":"ldapGroupName=ops;groupsStrategy=STATIC;groupDn=cn=ops","members":[]}`,
+		"/access/api/v2/users/alice":  `{"username":"alice","email":"alice@example.com","admin":true,"realm":"internal","status":"enabled","groups":["devs"],"internal_password_disabled":false}`,
+		"/access/api/v2/users/bob":    `{"username":"bob","email":"bob@example.com","admin":false,"realm":"ldap","status":"enabled","groups":["readers","devs"]}`,
+		"/access/api/v2/users/nobody": `{"username":"nobody"}`,
+	}
+	client := mockPrincipalsClient(details, &[]string{})
+
+	users := []ArtifactoryUser{{Username: "bob"}, {Username: "alice"}, {Username: "nobody"}}
+	groups := []ArtifactoryGroup{{GroupName: "ops"}, {GroupName: "devs"}}
+
+	filename := filepath.Join(t.TempDir(), "principals.yaml")
+	err := GeneratePrincipals(client, "", "", users, groups, filename, false, 2)
+	if err != nil {
+		t.Fatalf("GeneratePrincipals: error = %v", err)
+	}
+
+	want := `groups:
+- name: devs
+  description: Developers
+  autoJoin: true
+  realm: internal
+  members:
+  - alice
+  - bob
+- name: ops
+  realm: ldap
+  realmAttributes: ldapGroupName=ops;groupsStrategy=STATIC;groupDn=cn=ops
+users:
+- name: alice
+  email: alice@example.com
+  realm: internal
+  admin: true
+  groups:
+  - devs
+- name: bob
+  email: bob@example.com
+  realm: ldap
+  groups:
+  - devs
+  - readers
+- name: nobody
+`
+	data, _ := os.ReadFile(filename)
+	if string(data) != want {
+		t.Errorf("GeneratePrincipals: output mismatch:\nGot:\n%s\nWant:\n%s", string(data), want)
+	}
+
+	// The generated file loads back unchanged.
+	principals, err := LoadPrincipalsFile(filename)
+	if err != nil {
+		t.Fatalf("GeneratePrincipals: error loading generated file = %v", err)
+	}
+	if len(principals.Groups) != 2 || len(principals.Users) != 3 || !principals.Users[0].Admin {
+		t.Errorf("GeneratePrincipals: loaded %+v", principals)
+	}
+}
+
+func TestProvisionPrincipals(t *testing.T) {
+	ClearStats()
+
+	details := map[string]string{
+		"/access/api/v2/groups/devs":  `{"name":"devs","description":"Old","realm":"internal","members":["alice","mallory"]}`,
+		"/access/api/v2/groups/ops":   `{"name":"ops","realm":"ldap","members":["carol"]}`,
+		"/access/api/v2/users/alice":  `{"username":"alice","email":"alice@example.com","admin":false}`,
+		"/access/api/v2/groups/other": `{"name":"other","members":["x"]}`,
+		// Only retrieved after creating it, when not a dry run.
+		"/access/api/v2/groups/new": `{"name":"new","description":"New group","members":[]}`,
+	}
+	var requests []string
+	client := mockPrincipalsClient(details, &requests)
+
+	principals := Principals{
+		Groups: []PrincipalGroup{
+			{Name: "devs", Description: "Developers", Members: []string{"alice"}},
+			{Name: "ops", Realm: "ldap"},
+			{Name: "new", Description: "New group"},
+		},
+		Users: []PrincipalUser{
+			{Name: "alice", Email: "alice@example.com", Admin: true},
+			{Name: "bob", Email: "bob@example.com", Groups: []string{"devs", "ops", "other"}},
+		},
+	}
+	users := []ArtifactoryUser{{Username: "alice"}}
+	groups := []ArtifactoryGroup{{GroupName: "devs"}, {GroupName: "ops"}, {GroupName: "other"}}
+
+	users, groups, err := ProvisionPrincipals(client, "", "", principals, users, groups, true)
+	if err != nil {
+		t.Fatalf("ProvisionPrincipals dry run: error = %v", err)
+	}
+	if len(requests) != 0 {
+		t.Errorf("ProvisionPrincipals dry run: sent requests: %v", requests)
+	}
+	if len(users) != 2 || len(groups) != 4 {
+		t.Errorf("ProvisionPrincipals dry run: got %d users and %d groups, want 2 and 4", len(users), len(groups))
+	}
+
+	ClearStats()
+	_, _, err = ProvisionPrincipals(client, "", "", principals, []ArtifactoryUser{{Username: "alice"}}, []ArtifactoryGroup{{GroupName: "devs"}, {GroupName: "ops"}, {GroupName: "other"}}, false)
+	if err != nil {
+		t.Fatalf("ProvisionPrincipals: error = %v", err)
+	}
+
+	want := []string{
+		`PATCH /access/api/v2/groups/devs {"description":"Developers"}`,
+		`POST /access/api/v2/groups {"name":"new","description":"New group","auto_join":false,"admin_privileges":false}`,
+		`PATCH /access/api/v2/users/alice {"admin":true}`,
+		`POST /access/api/v2/users {"username":"bob","email":"bob@example.com","internal_password_disabled":true}`,
+		// Exact members for internal groups listing members, only additions otherwise.
+		`PATCH /access/api/v2/groups/devs/members {"add":["bob"],"remove":["mallory"]}`,
+		`PATCH /access/api/v2/groups/ops/members {"add":["bob"]}`,
+		`PATCH /access/api/v2/groups/other/members {"add":["bob"]}`,
+	}
+	if !slices.Equal(requests, want) {
+		t.Errorf("ProvisionPrincipals: requests mismatch:\nGot:\n%s\nWant:\n%s", strings.Join(requests, "\n"), strings.Join(want, "\n"))
+	}
+	if stats.CreatedGroupCount != 1 || stats.UpdatedGroupCount != 3 || stats.CreatedUserCount != 1 || stats.UpdatedUserCount != 1 {
+		t.Errorf("ProvisionPrincipals: unexpected stats: %+v", stats)
+	}
+}
+
+func TestLoadPrincipalsFileInvalid(t *testing.T) {
+	tests := []string{
+		"groups:\n- name: a\n- name: a\n",
+		"users:\n- email: a@example.com\n",
+		"groups: [",
+	}
+
+	for i, content := range tests {
+		filename := filepath.Join(t.TempDir(), "principals.yaml")
+		os.WriteFile(filename, []byte(content), 0644)
+
+		if _, err := LoadPrincipalsFile(filename); err == nil {
+			t.Errorf("LoadPrincipalsFile (%d/%d): expected error for:\n%s", i+1, len(tests), content)
+		}
+	}
+}
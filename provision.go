@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"slices"
 	"strings"
+	"sync"
 )
 
 type Statistics struct {
@@ -21,14 +22,28 @@ type Statistics struct {
 	IgnoredDuplicatePermissionCount int
 	CreatedUserCount                int
 	ImportedGroupCount              int
+	UpdatedUserCount                int
+	CreatedGroupCount               int
+	UpdatedGroupCount               int
 	CreatedRepoCount                int
 	UpdatedRepoCount                int
 	CreatedPermissionCount          int
 	UpdatedPermissionCount          int
+	RetriedRequestCount             int
 }
 
 var stats Statistics
 
+// Repos are provisioned and requests are retried concurrently, their stats must be updated with incStat.
+var statsMutex sync.Mutex
+
+func incStat(counter *int) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	*counter++
+}
+
 type repoDiff struct {
 	repo               Repo
 	hasRepoDiff        bool
@@ -57,44 +72,59 @@ func Provision(
 	allowpatterns bool,
 	ldapConfig LdapConfig,
 	propertiesConfig PropertiesConfig,
+	cache *Cache,
+	backup *Backup,
+	lock *Lock,
+	concurrency int,
 	dryRun bool) error {
 
+	err := lock.Check()
+	if err != nil {
+		return err
+	}
+
 	if ldapConfig.ImportUsersAndGroups {
-		accessToken, refreshToken, err := getUITokens(client, baseurl, ldapConfig.ArtifactoryUsername, ldapConfig.ArtifactoryPassword)
-		if err != nil {
-			return fmt.Errorf("unable to obtain UI tokens for Artifactory, cannot import ldap groups: %w", err)
+		auth := GroupImportAuth{Token: token, UseToken: ldapConfig.UseTokenImport}
+		if ldapConfig.ArtifactoryUsername != "" && ldapConfig.ArtifactoryPassword != "" {
+			var err error
+			auth.AccessToken, auth.RefreshToken, err = getUITokens(client, baseurl, ldapConfig.ArtifactoryUsername, ldapConfig.ArtifactoryPassword)
+			if err != nil {
+				if !ldapConfig.UseTokenImport {
+					return fmt.Errorf("unable to obtain UI tokens for Artifactory, cannot import ldap groups: %w", err)
+				}
+				fmt.Printf("Warning: Unable to obtain UI tokens for Artifactory, importing ldap groups using access token only: %v\n", err)
+			}
+		} else {
+			fmt.Println("No Artifactory username/password configured, importing ldap groups using access token.")
+			auth.UseToken = true
 		}
 
-		reposToProvision, allusers, allgroups = provisionUsersAndGroups(client, baseurl, token, reposToProvision, allusers, allgroups, ldapConfig, accessToken, refreshToken, dryRun)
+		ldapConnections.SetPagingSupport(ldapConfig.Ldapsettings)
+		defer ldapConnections.Close()
+
+		reposToProvision, allusers, allgroups = provisionUsersAndGroups(client, baseurl, token, reposToProvision, allusers, allgroups, ldapConfig, auth, dryRun)
+
+		if ldapConfig.ResolveNestedGroups {
+			reportNestedGroupAccess(reposToProvision, allgroups, ldapConfig)
+		}
 	}
 
 	reposWithDiffs := findReposWithDiffs(reposToProvision, allrepos, allpermissiondetails, allusers, allowpatterns)
 
 	fmt.Printf("Repos to provision: %d/%d\n", len(reposWithDiffs), len(reposToProvision))
 
-	for _, diffRepo := range reposWithDiffs {
-		if diffRepo.hasRepoDiff {
-			err := provisionRepo(client, baseurl, token, diffRepo.repo, diffRepo.existingRepo, dryRun)
-			if err != nil {
-				fmt.Printf("'%s': Warning: Ignoring repo: %v\n", diffRepo.repo.Name, err)
-				stats.IgnoredInvalidRepoCount++
-			}
-		}
+	levels := getProvisionLevels(reposWithDiffs)
 
-		if diffRepo.hasPermDiff {
-			err := provisionPermissionTarget(client, baseurl, token, diffRepo.repo, diffRepo.existingPermission, diffRepo.permUsers, diffRepo.permGroups, showDiff, dryRun)
-			if err != nil {
-				fmt.Printf("'%s': Warning: Ignoring repo's permission target: %v\n", diffRepo.repo.Name, err)
-				stats.IgnoredInvalidPermissionCount++
-			}
+	for _, level := range levels {
+		err := lock.Check()
+		if err != nil {
+			return err
 		}
 
-		if diffRepo.hasRepoDiff || diffRepo.hasPermDiff {
-			err := setRepoProperties(client, baseurl, token, diffRepo.repo, propertiesConfig, dryRun)
-			if err != nil {
-				fmt.Printf("'%s': Warning: Error setting properties: %v\n", diffRepo.repo.Name, err)
-			}
-		}
+		runConcurrently(concurrency, len(level), func(i int) error {
+			provisionRepoWithDiff(client, baseurl, token, level[i], showDiff, propertiesConfig, cache, backup, dryRun)
+			return nil
+		})
 	}
 
 	fmt.Printf("Results:\n")
@@ -108,15 +138,129 @@ func Provision(
 
 	fmt.Printf("  Created users: %d\n", stats.CreatedUserCount)
 	fmt.Printf("  Imported groups: %d\n", stats.ImportedGroupCount)
+	fmt.Printf("  Updated users: %d\n", stats.UpdatedUserCount)
+	fmt.Printf("  Created groups: %d\n", stats.CreatedGroupCount)
+	fmt.Printf("  Updated groups: %d\n", stats.UpdatedGroupCount)
 
 	fmt.Printf("  Created repos: %d\n", stats.CreatedRepoCount)
 	fmt.Printf("  Updated repos: %d\n", stats.UpdatedRepoCount)
 	fmt.Printf("  Created permission targets: %d\n", stats.CreatedPermissionCount)
 	fmt.Printf("  Updated permission targets: %d\n", stats.UpdatedPermissionCount)
 
+	fmt.Printf("  Retried requests: %d\n", stats.RetriedRequestCount)
+
+	if backup.HasChanges() {
+		fmt.Printf("Backup of changed repos and permission targets: '%s'\n", backup.Dir)
+	}
+
 	return nil
 }
 
+// Repo, permission target and properties of one repo are applied in order.
+func provisionRepoWithDiff(
+	client *http.Client,
+	baseurl string,
+	token string,
+	diffRepo repoDiff,
+	showDiff bool,
+	propertiesConfig PropertiesConfig,
+	cache *Cache,
+	backup *Backup,
+	dryRun bool) {
+
+	if diffRepo.hasRepoDiff {
+		err := backupRepo(client, baseurl, token, diffRepo, backup, dryRun)
+		if err == nil {
+			err = provisionRepo(client, baseurl, token, diffRepo.repo, diffRepo.existingRepo, dryRun)
+		}
+		if err != nil {
+			fmt.Printf("'%s': Warning: Ignoring repo: %v\n", diffRepo.repo.Name, err)
+			incStat(&stats.IgnoredInvalidRepoCount)
+		} else if !dryRun {
+			cache.UpdateRepo(diffRepo.repo, diffRepo.existingRepo)
+			if diffRepo.existingRepo == nil {
+				backup.AddCreatedRepo(diffRepo.repo.Name)
+			}
+		}
+	}
+
+	if diffRepo.hasPermDiff {
+		err := backupPermission(client, baseurl, token, diffRepo, backup, dryRun)
+		if err == nil {
+			err = provisionPermissionTarget(client, baseurl, token, diffRepo.repo, diffRepo.existingPermission, diffRepo.permUsers, diffRepo.permGroups, showDiff, dryRun)
+		}
+		if err != nil {
+			fmt.Printf("'%s': Warning: Ignoring repo's permission target: %v\n", diffRepo.repo.Name, err)
+			incStat(&stats.IgnoredInvalidPermissionCount)
+		} else if !dryRun && diffRepo.repo.Rclass != "virtual" {
+			cache.UpdatePermission(getProvisionedPermissionDetails(diffRepo.repo, diffRepo.existingPermission, diffRepo.permUsers, diffRepo.permGroups))
+			if diffRepo.existingPermission == nil {
+				backup.AddCreatedPermission(getPermissionName(diffRepo.repo))
+			}
+		}
+	}
+
+	if diffRepo.hasRepoDiff || diffRepo.hasPermDiff {
+		err := setRepoProperties(client, baseurl, token, diffRepo.repo, propertiesConfig, dryRun)
+		if err != nil {
+			fmt.Printf("'%s': Warning: Error setting properties: %v\n", diffRepo.repo.Name, err)
+		}
+	}
+}
+
+// Groups the repos into levels that can be provisioned concurrently. Virtual repos
+// are placed after the levels of their member repos that are also provisioned.
+func getProvisionLevels(reposWithDiffs []repoDiff) [][]repoDiff {
+	indexByName := make(map[string]int)
+	for i, diffRepo := range reposWithDiffs {
+		indexByName[diffRepo.repo.Name] = i
+	}
+
+	levelOf := make([]int, len(reposWithDiffs))
+	for i := range levelOf {
+		levelOf[i] = -1
+	}
+
+	var resolve func(i int, visiting map[int]bool) int
+	resolve = func(i int, visiting map[int]bool) int {
+		if levelOf[i] >= 0 {
+			return levelOf[i]
+		}
+		if visiting[i] {
+			fmt.Printf("'%s': Warning: Cyclic virtual repo dependency, ignoring ordering.\n", reposWithDiffs[i].repo.Name)
+			return -1
+		}
+		visiting[i] = true
+
+		level := 0
+		repo := reposWithDiffs[i].repo
+		if repo.Rclass == "virtual" {
+			for _, member := range repo.Repositories {
+				j, ok := indexByName[member]
+				if !ok || j == i || !reposWithDiffs[j].hasRepoDiff {
+					continue
+				}
+				level = max(level, resolve(j, visiting)+1)
+			}
+		}
+
+		delete(visiting, i)
+		levelOf[i] = level
+		return level
+	}
+
+	var levels [][]repoDiff
+	for i := range reposWithDiffs {
+		level := resolve(i, make(map[int]bool))
+		for len(levels) <= level {
+			levels = append(levels, nil)
+		}
+		levels[level] = append(levels[level], reposWithDiffs[i])
+	}
+
+	return levels
+}
+
 func findReposWithDiffs(
 	reposToProvision []Repo,
 	allrepos []ArtifactoryRepoDetailsResponse,
@@ -161,8 +305,7 @@ func provisionUsersAndGroups(
 	allusers []ArtifactoryUser,
 	allgroups []ArtifactoryGroup,
 	ldapConfig LdapConfig,
-	accessToken string,
-	refreshToken string,
+	auth GroupImportAuth,
 	dryRun bool) ([]Repo, []ArtifactoryUser, []ArtifactoryGroup) {
 
 	var usersAndGroups []string
@@ -240,8 +383,7 @@ func provisionUsersAndGroups(
 			ug,
 			ldapConfig.Ldapsettings,
 			ldapConfig.Ldapgroupsettings,
-			accessToken,
-			refreshToken,
+			auth,
 			dryRun)
 		if errGroup != nil {
 			fmt.Printf("Importing group '%s' failed: %v\n", ug, errGroup)
@@ -434,7 +576,7 @@ func updateExistingRepo(
 	req.Header.Set("Authorization", "Bearer "+token)
 
 	if !dryRun {
-		resp, err := client.Do(req)
+		resp, err := doRequest(client, req)
 		if err != nil {
 			return fmt.Errorf("error updating repo: %w", err)
 		}
@@ -452,7 +594,7 @@ func updateExistingRepo(
 			fmt.Printf("'%s': Updated repo successfully.\n", repo.Name)
 		}
 	}
-	stats.UpdatedRepoCount++
+	incStat(&stats.UpdatedRepoCount)
 
 	return nil
 }
@@ -511,7 +653,7 @@ func createNewRepo(
 	fmt.Printf("'%s': %s\n", repo.Name, strings.Join(fields, ", "))
 
 	if !dryRun {
-		resp, err := client.Do(req)
+		resp, err := doRequest(client, req)
 		if err != nil {
 			return fmt.Errorf("error creating repo: %w", err)
 		}
@@ -529,7 +671,7 @@ func createNewRepo(
 			fmt.Printf("'%s': Created repo successfully.\n", repo.Name)
 		}
 	}
-	stats.CreatedRepoCount++
+	incStat(&stats.CreatedRepoCount)
 
 	return nil
 }
@@ -543,67 +685,16 @@ func setRepoProperties(
 	dryRun bool,
 ) error {
 
-	if !propertiesConfig.SetProperties {
-		return nil
-	}
-
-	if repo.Rclass == "virtual" {
-		return nil
-	}
-
-	repoName := repo.Name
-	if repo.Rclass == "remote" {
-		repoName = repo.Name + "-cache"
-	}
-
-	desiredProps := make(map[string][]string)
-
-	if propertiesConfig.Url != "" {
-		desiredProps[fmt.Sprintf("%s.url", propertiesConfig.Prefix)] = []string{propertiesConfig.Url}
-	}
-
-	for key, value := range repo.ExtraFields {
-		if value == nil || value == "" || value == false || value == 0 {
-			continue
-		}
-
-		var values []string
-		switch v := value.(type) {
-		case []any:
-			for _, item := range v {
-				values = append(values, fmt.Sprintf("%v", item))
-			}
-		default:
-			values = []string{fmt.Sprintf("%v", value)}
-		}
-
-		desiredProps[fmt.Sprintf("%s.%s", propertiesConfig.Prefix, key)] = values
-	}
-
-	if len(desiredProps) == 0 {
-		return nil
-	}
-
-	currentProps, err := getRepoProperties(client, baseurl, token, repoName)
-	if err != nil {
+	diff, err := getRepoPropertiesDiff(client, baseurl, token, repo, propertiesConfig)
+	if err != nil || diff == nil {
 		return err
 	}
 
-	needsUpdate := false
-	if len(desiredProps) != len(currentProps) {
-		needsUpdate = true
-	} else {
-		for key, desiredVal := range desiredProps {
-			if currentVal, ok := currentProps[key]; !ok || !equalStringSlices(currentVal, desiredVal) {
-				needsUpdate = true
-				break
-			}
-		}
-	}
+	repoName := diff.repoName
 
-	if needsUpdate {
+	if diff.needsUpdate {
 		var properties []string
-		for key, value := range desiredProps {
+		for key, value := range diff.desired {
 			properties = append(properties, fmt.Sprintf("%s=%s", url.QueryEscape(key), url.QueryEscape(fmt.Sprint(value))))
 		}
 
@@ -616,7 +707,7 @@ func setRepoProperties(
 		req.Header.Set("Authorization", "Bearer "+token)
 
 		if !dryRun {
-			resp, err := client.Do(req)
+			resp, err := doRequest(client, req)
 			if err != nil {
 				return fmt.Errorf("error setting repo properties: %w", err)
 			}
@@ -631,15 +722,7 @@ func setRepoProperties(
 		fmt.Printf("'%s': Set properties: %s.\n", repoName, strings.Join(properties, ", "))
 	}
 
-	prefix := propertiesConfig.Prefix + "."
-	var unused []string
-	for key := range currentProps {
-		if strings.HasPrefix(key, prefix) {
-			if _, ok := desiredProps[key]; !ok {
-				unused = append(unused, key)
-			}
-		}
-	}
+	unused := diff.unused
 	if len(unused) > 0 {
 		deleteUrl := fmt.Sprintf("%s/artifactory/api/storage/%s?properties=%s&recursive=0", baseurl, repoName, strings.Join(unused, ";"))
 		reqDel, err := http.NewRequest("DELETE", deleteUrl, nil)
@@ -648,7 +731,7 @@ func setRepoProperties(
 		}
 		reqDel.Header.Set("Authorization", "Bearer "+token)
 		if !dryRun {
-			resp, err := client.Do(reqDel)
+			resp, err := doRequest(client, reqDel)
 			if err != nil {
 				return fmt.Errorf("error deleting unused properties: %w", err)
 			}
@@ -664,6 +747,116 @@ func setRepoProperties(
 	return nil
 }
 
+type propertiesDiff struct {
+	repoName    string
+	desired     map[string][]string
+	current     map[string][]string
+	needsUpdate bool
+	unused      []string
+}
+
+// Compares the declared properties of a repo with the properties set in Artifactory.
+// Returns nil when no properties are managed for the repo.
+func getRepoPropertiesDiff(
+	client *http.Client,
+	baseurl string,
+	token string,
+	repo Repo,
+	propertiesConfig PropertiesConfig,
+) (*propertiesDiff, error) {
+
+	if !propertiesConfig.SetProperties {
+		return nil, nil
+	}
+
+	if repo.Rclass == "virtual" {
+		return nil, nil
+	}
+
+	repoName := getPropertiesRepoName(repo)
+
+	desiredProps := make(map[string][]string)
+
+	if propertiesConfig.Url != "" {
+		desiredProps[fmt.Sprintf("%s.url", propertiesConfig.Prefix)] = []string{propertiesConfig.Url}
+	}
+
+	for key, value := range repo.ExtraFields {
+		values := getPropertyValues(value)
+		if values == nil {
+			continue
+		}
+
+		desiredProps[fmt.Sprintf("%s.%s", propertiesConfig.Prefix, key)] = values
+	}
+
+	if len(desiredProps) == 0 {
+		return nil, nil
+	}
+
+	currentProps, err := getRepoProperties(client, baseurl, token, repoName)
+	if err != nil {
+		return nil, err
+	}
+
+	needsUpdate := false
+	if len(desiredProps) != len(currentProps) {
+		needsUpdate = true
+	} else {
+		for key, desiredVal := range desiredProps {
+			if currentVal, ok := currentProps[key]; !ok || !equalStringSlices(currentVal, desiredVal) {
+				needsUpdate = true
+				break
+			}
+		}
+	}
+
+	prefix := propertiesConfig.Prefix + "."
+	var unused []string
+	for key := range currentProps {
+		if strings.HasPrefix(key, prefix) {
+			if _, ok := desiredProps[key]; !ok {
+				unused = append(unused, key)
+			}
+		}
+	}
+
+	return &propertiesDiff{
+		repoName:    repoName,
+		desired:     desiredProps,
+		current:     currentProps,
+		needsUpdate: needsUpdate,
+		unused:      unused,
+	}, nil
+}
+
+// Returns the property values of an extra field, or nil if no property is set for it.
+func getPropertyValues(value any) []string {
+	if value == nil || value == "" || value == false || value == 0 {
+		return nil
+	}
+
+	var values []string
+	switch v := value.(type) {
+	case []any:
+		for _, item := range v {
+			values = append(values, fmt.Sprintf("%v", item))
+		}
+	default:
+		values = []string{fmt.Sprintf("%v", value)}
+	}
+
+	return values
+}
+
+// Properties of remote repos are set on their cache repo.
+func getPropertiesRepoName(repo Repo) string {
+	if repo.Rclass == "remote" {
+		return repo.Name + "-cache"
+	}
+	return repo.Name
+}
+
 type ArtifactoryPropertiesResponse struct {
 	Properties map[string][]string `json:"properties"`
 }
@@ -682,7 +875,7 @@ func getRepoProperties(
 	}
 	req.Header.Set("Authorization", "Bearer "+token)
 
-	resp, err := client.Do(req)
+	resp, err := doRequest(client, req)
 	if err != nil {
 		return nil, fmt.Errorf("error getting repo properties: %w", err)
 	}
@@ -863,7 +1056,7 @@ func updateExistingPermission(
 	log.Printf("Permission (existing) diff: '%s'\n%s", permissionName, difftext)
 
 	if !dryRun {
-		resp, err := client.Do(req)
+		resp, err := doRequest(client, req)
 		if err != nil {
 			return fmt.Errorf("error updating permission target: %w", err)
 		}
@@ -881,7 +1074,7 @@ func updateExistingPermission(
 			fmt.Printf("'%s': Updated permission target successfully.\n", permissionName)
 		}
 	}
-	stats.UpdatedPermissionCount++
+	incStat(&stats.UpdatedPermissionCount)
 
 	return nil
 }
@@ -935,7 +1128,7 @@ func createNewPermission(
 	log.Printf("Permission (new): '%s'\n%s\n", permissionName, string(json))
 
 	if !dryRun {
-		resp, err := client.Do(req)
+		resp, err := doRequest(client, req)
 		if err != nil {
 			return fmt.Errorf("error creating permission target: %w", err)
 		}
@@ -953,7 +1146,7 @@ func createNewPermission(
 			fmt.Printf("'%s': Created permission target successfully.\n", permissionName)
 		}
 	}
-	stats.CreatedPermissionCount++
+	incStat(&stats.CreatedPermissionCount)
 
 	return nil
 }
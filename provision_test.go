@@ -6,7 +6,9 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/go-ldap/ldap/v3"
@@ -51,7 +53,7 @@ func TestProvisionSimple(t *testing.T) {
 	}
 	for i, tc := range tests {
 		var client *http.Client
-		err := Provision(client, "", "", tc.reposToProvision, tc.repos, tc.users, tc.groups, tc.permissiondetails, false, tc.allowPatterns, LdapConfig{}, PropertiesConfig{}, tc.dryRun)
+		err := Provision(client, "", "", tc.reposToProvision, tc.repos, tc.users, tc.groups, tc.permissiondetails, false, tc.allowPatterns, LdapConfig{}, PropertiesConfig{}, nil, nil, nil, 1, tc.dryRun)
 		if err != nil {
 			t.Errorf("ProvisionSimple (%d/%d): error = %v", i+1, len(tests), err)
 		}
@@ -122,7 +124,7 @@ func TestProvisionPermissions(t *testing.T) {
 		return response, nil
 	})
 
-	err := Provision(client, "", "", tc.reposToProvision, tc.repos, tc.users, tc.groups, tc.permissiondetails, true, tc.allowPatterns, LdapConfig{}, PropertiesConfig{}, tc.dryRun)
+	err := Provision(client, "", "", tc.reposToProvision, tc.repos, tc.users, tc.groups, tc.permissiondetails, true, tc.allowPatterns, LdapConfig{}, PropertiesConfig{}, nil, nil, nil, 1, tc.dryRun)
 	if err != nil {
 		t.Errorf("ProvisionPermissions: error = %v", err)
 	}
@@ -208,7 +210,7 @@ func TestProvisionRenamedPermissions(t *testing.T) {
 		return response, nil
 	})
 
-	err := Provision(client, "", "", tc.reposToProvision, tc.repos, tc.users, tc.groups, tc.permissiondetails, true, tc.allowPatterns, LdapConfig{}, PropertiesConfig{}, tc.dryRun)
+	err := Provision(client, "", "", tc.reposToProvision, tc.repos, tc.users, tc.groups, tc.permissiondetails, true, tc.allowPatterns, LdapConfig{}, PropertiesConfig{}, nil, nil, nil, 1, tc.dryRun)
 	if err != nil {
 		t.Errorf("ProvisionRenamedPermissions: error = %v", err)
 	}
@@ -401,7 +403,7 @@ func TestProvisionLdap(t *testing.T) {
 
 	queryldapImportGroupFn = queryldapCreateUserFn
 
-	err := Provision(client, "", "", tc.reposToProvision, tc.repos, tc.users, tc.groups, tc.permissiondetails, false, tc.allowPatterns, ldapConfig, PropertiesConfig{}, tc.dryRun)
+	err := Provision(client, "", "", tc.reposToProvision, tc.repos, tc.users, tc.groups, tc.permissiondetails, false, tc.allowPatterns, ldapConfig, PropertiesConfig{}, nil, nil, nil, 1, tc.dryRun)
 	if err != nil {
 		t.Errorf("ProvisionLdap: unexpected error = %v", err)
 	}
@@ -568,7 +570,7 @@ func TestProvisionLdapFail(t *testing.T) {
 
 	queryldapImportGroupFn = queryldapCreateUserFn
 
-	err := Provision(client, "", "", tc.reposToProvision, tc.repos, tc.users, tc.groups, tc.permissiondetails, false, tc.allowPatterns, ldapConfig, PropertiesConfig{}, tc.dryRun)
+	err := Provision(client, "", "", tc.reposToProvision, tc.repos, tc.users, tc.groups, tc.permissiondetails, false, tc.allowPatterns, ldapConfig, PropertiesConfig{}, nil, nil, nil, 1, tc.dryRun)
 	if err != nil {
 		t.Errorf("ProvisionLdapFail: unexpected error = %v", err)
 	}
@@ -873,7 +875,7 @@ func TestProvisionCreateVirtualRepo(t *testing.T) {
 	})
 
 	ClearStats()
-	err = Provision(client, "", "", tc.reposToProvision, tc.repos, tc.users, tc.groups, tc.permissiondetails, true, tc.allowPatterns, LdapConfig{}, PropertiesConfig{}, tc.dryRun)
+	err = Provision(client, "", "", tc.reposToProvision, tc.repos, tc.users, tc.groups, tc.permissiondetails, true, tc.allowPatterns, LdapConfig{}, PropertiesConfig{}, nil, nil, nil, 1, tc.dryRun)
 	if err != nil {
 		t.Errorf("ProvisionCreateVirtualRepo: error = %v", err)
 	}
@@ -948,7 +950,7 @@ func TestProvisionUpdateVirtualRepo(t *testing.T) {
 	})
 
 	ClearStats()
-	err = Provision(client, "", "", tc.reposToProvision, tc.repos, tc.users, tc.groups, tc.permissiondetails, true, tc.allowPatterns, LdapConfig{}, PropertiesConfig{}, tc.dryRun)
+	err = Provision(client, "", "", tc.reposToProvision, tc.repos, tc.users, tc.groups, tc.permissiondetails, true, tc.allowPatterns, LdapConfig{}, PropertiesConfig{}, nil, nil, nil, 1, tc.dryRun)
 	if err != nil {
 		t.Errorf("ProvisionUpdateVirtualRepo: error = %v", err)
 	}
@@ -1005,7 +1007,7 @@ func TestProvisionVirtualRepoMissingRepoList(t *testing.T) {
 		return nil, nil
 	})
 
-	err := Provision(client, "", "", tc.reposToProvision, tc.repos, tc.users, tc.groups, tc.permissiondetails, true, tc.allowPatterns, LdapConfig{}, PropertiesConfig{}, tc.dryRun)
+	err := Provision(client, "", "", tc.reposToProvision, tc.repos, tc.users, tc.groups, tc.permissiondetails, true, tc.allowPatterns, LdapConfig{}, PropertiesConfig{}, nil, nil, nil, 1, tc.dryRun)
 	if err != nil {
 		t.Errorf("ProvisionVirtualRepoMissingRepoList: error = %v", err)
 	}
@@ -1059,8 +1061,84 @@ func TestProvisionVirtualRepoMissingRepoListTriggerChange(t *testing.T) {
 		return response, nil
 	})
 
-	err := Provision(client, "", "", tc.reposToProvision, tc.repos, tc.users, tc.groups, tc.permissiondetails, true, tc.allowPatterns, LdapConfig{}, PropertiesConfig{}, tc.dryRun)
+	err := Provision(client, "", "", tc.reposToProvision, tc.repos, tc.users, tc.groups, tc.permissiondetails, true, tc.allowPatterns, LdapConfig{}, PropertiesConfig{}, nil, nil, nil, 1, tc.dryRun)
 	if err != nil {
 		t.Errorf("ProvisionVirtualRepoMissingRepoListTriggerChange: error = %v", err)
 	}
 }
+
+func TestGetProvisionLevels(t *testing.T) {
+	reposWithDiffs := []repoDiff{
+		{repo: Repo{Name: "virtual-all", Rclass: "virtual", Repositories: []string{"virtual-inner", "local1"}}, hasRepoDiff: true},
+		{repo: Repo{Name: "local1"}, hasRepoDiff: true},
+		{repo: Repo{Name: "virtual-inner", Rclass: "virtual", Repositories: []string{"remote1", "local-unchanged"}}, hasRepoDiff: true},
+		{repo: Repo{Name: "remote1", Rclass: "remote"}, hasRepoDiff: true},
+		{repo: Repo{Name: "local-unchanged"}, hasRepoDiff: false, hasPermDiff: true},
+	}
+
+	levels := getProvisionLevels(reposWithDiffs)
+
+	var got [][]string
+	for _, level := range levels {
+		var names []string
+		for _, diffRepo := range level {
+			names = append(names, diffRepo.repo.Name)
+		}
+		got = append(got, names)
+	}
+
+	want := [][]string{
+		{"local1", "remote1", "local-unchanged"},
+		{"virtual-inner"},
+		{"virtual-all"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("GetProvisionLevels: got levels %v, want %v", got, want)
+	}
+	for i := range want {
+		if !slices.Equal(got[i], want[i]) {
+			t.Errorf("GetProvisionLevels: level %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestProvisionConcurrent(t *testing.T) {
+	var reposToProvision []Repo
+	for i := range 20 {
+		reposToProvision = append(reposToProvision, Repo{Name: fmt.Sprintf("repo%02d", i), Read: []string{"test-user"}})
+	}
+	reposToProvision = append(reposToProvision, Repo{Name: "virtual", Rclass: "virtual", Repositories: []string{"repo00", "repo19"}})
+
+	var mutex sync.Mutex
+	var created []string
+
+	client := mockHTTPClient(func(req *http.Request) (*http.Response, error) {
+		if req.Method == "PUT" && strings.HasPrefix(req.URL.Path, "/artifactory/api/repositories/") {
+			mutex.Lock()
+			created = append(created, strings.TrimPrefix(req.URL.Path, "/artifactory/api/repositories/"))
+			mutex.Unlock()
+			return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+		}
+		if req.Method == "POST" && req.URL.Path == "/access/api/v2/permissions" {
+			return &http.Response{StatusCode: 201, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+		}
+		t.Errorf("ProvisionConcurrent: unexpected request: %s %s", req.Method, req.URL.Path)
+		return &http.Response{StatusCode: 400, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+	})
+
+	ClearStats()
+	err := Provision(client, "", "", reposToProvision, nil, []ArtifactoryUser{{Username: "test-user"}}, nil, nil, false, false, LdapConfig{}, PropertiesConfig{}, nil, nil, nil, 8, false)
+	if err != nil {
+		t.Fatalf("ProvisionConcurrent: error = %v", err)
+	}
+
+	if stats.CreatedRepoCount != 21 {
+		t.Errorf("ProvisionConcurrent: got %d created repos, want 21", stats.CreatedRepoCount)
+	}
+	if stats.CreatedPermissionCount != 20 {
+		t.Errorf("ProvisionConcurrent: got %d created permission targets, want 20", stats.CreatedPermissionCount)
+	}
+	if len(created) != 21 || created[len(created)-1] != "virtual" {
+		t.Errorf("ProvisionConcurrent: virtual repo not created after its members: %v", created)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// A named set of permissions. Repo files list the principals of a role in a field named as
+// the role in plural, e.g. 'deployers: [team-x]'.
+type Role struct {
+	Name    string   `json:"name"`
+	Actions []string `json:"actions"`
+}
+
+var standardRoles = []Role{
+	{"reader", []string{"READ"}},
+	{"deployer", []string{"READ", "ANNOTATE", "WRITE"}},
+	{"maintainer", []string{"READ", "ANNOTATE", "WRITE", "DELETE"}},
+	{"admin", []string{"READ", "ANNOTATE", "WRITE", "DELETE", "MANAGE"}},
+}
+
+// Roles that are expanded when loading repo files, and emitted when generating.
+var repoRoles = standardRoles
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-zA-Z0-9]*$`)
+
+func (r Role) FieldName() string {
+	return r.Name + "s"
+}
+
+// Loads role definitions from a json file, like [{"name": "deployer", "actions": ["read", "write"]}].
+// Roles named as a standard role replace it, others are added.
+func LoadRoles(filename string) ([]Role, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+
+	var loaded []Role
+	err = json.Unmarshal(data, &loaded)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing file '%s': %w", filename, err)
+	}
+
+	roles := slices.Clone(standardRoles)
+	var names []string
+	for _, role := range loaded {
+		if !roleNamePattern.MatchString(role.Name) {
+			return nil, fmt.Errorf("invalid role name '%s', expected letters and digits starting with a lowercase letter", role.Name)
+		}
+		if isKnownRepoField(role.FieldName()) {
+			return nil, fmt.Errorf("invalid role name '%s', field '%s' is a repo field", role.Name, role.FieldName())
+		}
+		if slices.Contains(names, role.Name) {
+			return nil, fmt.Errorf("duplicate role '%s'", role.Name)
+		}
+		names = append(names, role.Name)
+
+		actions, err := normalizeRoleActions(role.Actions)
+		if err != nil {
+			return nil, fmt.Errorf("invalid role '%s': %w", role.Name, err)
+		}
+		role.Actions = actions
+
+		index := slices.IndexFunc(roles, func(r Role) bool { return r.Name == role.Name })
+		if index != -1 {
+			roles[index] = role
+		} else {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles, nil
+}
+
+// Returns the actions in upper case and in the order of permissionActions.
+func normalizeRoleActions(actions []string) ([]string, error) {
+	if len(actions) == 0 {
+		return nil, fmt.Errorf("no actions")
+	}
+
+	var normalized []string
+	for _, action := range actions {
+		action = strings.ToUpper(action)
+		if !slices.Contains(permissionActions, action) {
+			return nil, fmt.Errorf("invalid action '%s', expected one of: %s", action, strings.Join(permissionActions, ", "))
+		}
+		normalized = append(normalized, action)
+	}
+
+	return slices.DeleteFunc(slices.Clone(permissionActions), func(action string) bool {
+		return !slices.Contains(normalized, action)
+	}), nil
+}
+
+func isRoleField(name string) bool {
+	return slices.ContainsFunc(repoRoles, func(r Role) bool { return r.FieldName() == name })
+}
+
+// Moves the principals of role fields into the action lists of a repo. Role fields are read
+// from the extra fields of repo files, and from the roles of generated repos.
+func ExpandRoles(repo *Repo, roles []Role) {
+	for _, role := range roles {
+		var principals []string
+		if value, ok := repo.ExtraFields[role.FieldName()]; ok {
+			principals = getPropertyValues(value)
+			delete(repo.ExtraFields, role.FieldName())
+		}
+		principals = append(principals, repo.Roles[role.Name]...)
+		delete(repo.Roles, role.Name)
+
+		for _, list := range getRepoActionLists(repo) {
+			if !slices.Contains(role.Actions, list.action) {
+				continue
+			}
+			for _, principal := range principals {
+				if !slices.Contains(*list.principals, principal) {
+					*list.principals = append(*list.principals, principal)
+				}
+			}
+		}
+	}
+
+	if len(repo.ExtraFields) == 0 {
+		repo.ExtraFields = nil
+	}
+	if len(repo.Roles) == 0 {
+		repo.Roles = nil
+	}
+}
+
+// Replaces the permissions of principals whose actions on a repo are exactly those of a role,
+// with the role. The first matching role is used.
+func emitRoles(repo *Repo, roles []Role) {
+	actions := getPrincipalActions(*repo)
+
+	for _, principal := range slices.Sorted(maps.Keys(actions)) {
+		role := getMatchingRole(actions[principal], roles)
+		if role == "" {
+			continue
+		}
+
+		for _, list := range getRepoActionLists(repo) {
+			*list.principals = slices.DeleteFunc(*list.principals, func(p string) bool { return p == principal })
+			if len(*list.principals) == 0 {
+				*list.principals = nil
+			}
+		}
+
+		if repo.Roles == nil {
+			repo.Roles = make(map[string][]string)
+		}
+		repo.Roles[role] = append(repo.Roles[role], principal)
+	}
+}
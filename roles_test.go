@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadRepoFileWithRoles(t *testing.T) {
+	path := writeTempFile(t, "roles-*.yaml", `- name: repo1
+  read: [auditor]
+  deployers: [team-x, ci]
+  maintainers: team-y
+  owner: team-x
+`)
+	defer os.Remove(path)
+
+	repos := LoadRepoFiles([]string{path}, false)
+	if len(repos) != 1 {
+		t.Fatalf("LoadRepoFilesWithRoles: got %d repos, want 1", len(repos))
+	}
+
+	want := Repo{
+		Name:        "repo1",
+		Read:        []string{"auditor", "team-x", "ci", "team-y"},
+		Annotate:    []string{"team-x", "ci", "team-y"},
+		Write:       []string{"team-x", "ci", "team-y"},
+		Delete:      []string{"team-y"},
+		ExtraFields: map[string]any{"owner": "team-x"},
+	}
+	got := repos[0]
+	got.SourceFile, got.SourceOffset, got.SourceLine = "", 0, 0
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadRepoFilesWithRoles: got %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadRoles(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Content string
+		Want    []Role
+		WantErr bool
+	}{
+		{"Override and add", `[{"name": "deployer", "actions": ["write", "read"]}, {"name": "scanner", "actions": ["SCAN"]}]`, []Role{
+			{"reader", []string{"READ"}},
+			{"deployer", []string{"READ", "WRITE"}},
+			{"maintainer", []string{"READ", "ANNOTATE", "WRITE", "DELETE"}},
+			{"admin", []string{"READ", "ANNOTATE", "WRITE", "DELETE", "MANAGE"}},
+			{"scanner", []string{"SCAN"}},
+		}, false},
+		{"Invalid action", `[{"name": "x", "actions": ["ADMIN"]}]`, nil, true},
+		{"No actions", `[{"name": "x", "actions": []}]`, nil, true},
+		{"Repo field", `[{"name": "name", "actions": ["READ"]}]`, nil, true},
+		{"Invalid name", `[{"name": "Team X", "actions": ["READ"]}]`, nil, true},
+		{"Duplicate", `[{"name": "x", "actions": ["READ"]}, {"name": "x", "actions": ["WRITE"]}]`, nil, true},
+	}
+
+	for i, test := range tests {
+		filename := filepath.Join(t.TempDir(), "roles.json")
+		os.WriteFile(filename, []byte(test.Content), 0644)
+
+		got, err := LoadRoles(filename)
+		if (err != nil) != test.WantErr {
+			t.Errorf("%s (%d/%d): error = %v, wantErr %v", test.Name, i+1, len(tests), err, test.WantErr)
+			continue
+		}
+		if !test.WantErr && !reflect.DeepEqual(got, test.Want) {
+			t.Errorf("%s (%d/%d): got %+v, want %+v", test.Name, i+1, len(tests), got, test.Want)
+		}
+	}
+}
+
+func TestGenerateEmitRoles(t *testing.T) {
+	repos := []ArtifactoryRepoDetailsResponse{
+		{Key: "repo1", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+	}
+	permissiondetails := []ArtifactoryPermissionDetails{
+		{Name: "repo1", Resources: ArtifactoryPermissionDetailsResources{Artifact: ArtifactoryPermissionDetailsArtifact{
+			Actions: ArtifactoryPermissionDetailsActions{
+				Users:  map[string][]string{"alice": {"READ", "WRITE"}, "bob": {"READ"}},
+				Groups: map[string][]string{"team-x": {"READ", "ANNOTATE", "WRITE"}, "team-y": {"READ", "ANNOTATE", "WRITE", "DELETE", "MANAGE"}},
+			},
+		}}},
+	}
+
+	filename := filepath.Join(t.TempDir(), "repos.yaml")
+	err := Generate(nil, "", "", repos, permissiondetails, filename, GenerateConfig{EmitRoles: true})
+	if err != nil {
+		t.Fatalf("GenerateEmitRoles: error = %v", err)
+	}
+
+	want := `- name: repo1
+  read:
+  - alice
+  write:
+  - alice
+  readers:
+  - bob
+  deployers:
+  - team-x
+  admins:
+  - team-y
+`
+	data, _ := os.ReadFile(filename)
+	if string(data) != want {
+		t.Errorf("GenerateEmitRoles: output mismatch:\nGot:\n%s\nWant:\n%s", string(data), want)
+	}
+
+	// The roles expand back to the generated permissions.
+	loaded := LoadRepoFiles([]string{filename}, false)
+	if len(loaded) != 1 {
+		t.Fatalf("GenerateEmitRoles: loaded %d repos, want 1", len(loaded))
+	}
+	users, groups := convertUsersAndGroups(loaded[0], []ArtifactoryUser{{Username: "alice"}, {Username: "bob"}}, nil)
+	for name, want := range permissiondetails[0].Resources.Artifact.Actions.Users {
+		if !equalStringSlices(users[name], want) {
+			t.Errorf("GenerateEmitRoles: user '%s' got %v, want %v", name, users[name], want)
+		}
+	}
+	for name, want := range permissiondetails[0].Resources.Artifact.Actions.Groups {
+		if !equalStringSlices(groups[name], want) {
+			t.Errorf("GenerateEmitRoles: group '%s' got %v, want %v", name, groups[name], want)
+		}
+	}
+}
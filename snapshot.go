@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const snapshotVersion = 1
+
+const redactedValue = "***"
+
+type Snapshot struct {
+	Version           int                              `json:"version"`
+	BaseURL           string                           `json:"baseUrl"`
+	CreatedAt         time.Time                        `json:"createdAt"`
+	Repos             []ArtifactoryRepoDetailsResponse `json:"repos"`
+	Users             []ArtifactoryUser                `json:"users"`
+	Groups            []ArtifactoryGroup               `json:"groups"`
+	PermissionDetails []json.RawMessage                `json:"permissionDetails"`
+	LdapSettings      []ArtifactoryLDAPSettings        `json:"ldapSettings"`
+	LdapGroupSettings []ArtifactoryLDAPGroupSettings   `json:"ldapGroupSettings"`
+}
+
+// Permission targets are kept as retrieved, so that diffs against the snapshot look the same as against Artifactory.
+func ExportSnapshot(
+	filename string,
+	baseurl string,
+	repos []ArtifactoryRepoDetailsResponse,
+	users []ArtifactoryUser,
+	groups []ArtifactoryGroup,
+	permissiondetails []ArtifactoryPermissionDetails,
+	ldapsettings []ArtifactoryLDAPSettings,
+	ldapgroupsettings []ArtifactoryLDAPGroupSettings) error {
+
+	snapshot := Snapshot{
+		Version:           snapshotVersion,
+		BaseURL:           baseurl,
+		CreatedAt:         time.Now().UTC(),
+		Repos:             repos,
+		Users:             users,
+		Groups:            groups,
+		LdapGroupSettings: ldapgroupsettings,
+	}
+
+	for _, permission := range permissiondetails {
+		source := []byte(permission.JsonSource)
+		if len(source) == 0 || !json.Valid(source) {
+			var err error
+			source, err = json.Marshal(permission)
+			if err != nil {
+				return fmt.Errorf("error generating json for permission target '%s': %w", permission.Name, err)
+			}
+		}
+		snapshot.PermissionDetails = append(snapshot.PermissionDetails, source)
+	}
+
+	for _, settings := range ldapsettings {
+		if settings.Search.ManagerPassword != "" {
+			settings.Search.ManagerPassword = redactedValue
+		}
+		snapshot.LdapSettings = append(snapshot.LdapSettings, settings)
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error generating json: %w", err)
+	}
+
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("error creating snapshot file: %w", err)
+	}
+	defer f.Close()
+
+	writer := gzip.NewWriter(f)
+	_, err = writer.Write(data)
+	if err != nil {
+		return fmt.Errorf("error writing snapshot file: %w", err)
+	}
+	err = writer.Close()
+	if err != nil {
+		return fmt.Errorf("error writing snapshot file: %w", err)
+	}
+
+	fmt.Printf("Saved snapshot of '%s': '%s'\n", baseurl, filename)
+
+	return nil
+}
+
+// Reads a snapshot, both gzipped and plain json snapshots are supported.
+func LoadSnapshot(filename string) (Snapshot, error) {
+	var snapshot Snapshot
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return snapshot, fmt.Errorf("error opening snapshot file: %w", err)
+	}
+	defer f.Close()
+
+	buffered := bufio.NewReader(f)
+	var reader io.Reader = buffered
+
+	magic, _ := buffered.Peek(2)
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return snapshot, fmt.Errorf("error reading snapshot file: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return snapshot, fmt.Errorf("error reading snapshot file: %w", err)
+	}
+
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return snapshot, fmt.Errorf("error parsing snapshot file: %w", err)
+	}
+
+	if snapshot.Version < 1 || snapshot.Version > snapshotVersion {
+		return snapshot, fmt.Errorf("unsupported snapshot version: %d, supported version: %d", snapshot.Version, snapshotVersion)
+	}
+
+	fmt.Printf("Using snapshot of '%s' from %s: '%s'\n", snapshot.BaseURL, snapshot.CreatedAt.Local().Format(time.DateTime), filename)
+
+	return snapshot, nil
+}
+
+func (s Snapshot) GetPermissionDetails() ([]ArtifactoryPermissionDetails, error) {
+	var permissiondetails []ArtifactoryPermissionDetails
+
+	for _, source := range s.PermissionDetails {
+		var permission ArtifactoryPermissionDetails
+		err := json.Unmarshal(source, &permission)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing permission target in snapshot: %w", err)
+		}
+		permission.JsonSource = string(source)
+		permissiondetails = append(permissiondetails, permission)
+	}
+
+	return permissiondetails, nil
+}
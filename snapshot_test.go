@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "snapshot.json.gz")
+
+	repos := []ArtifactoryRepoDetailsResponse{{Key: "repo1", Rclass: "local", PackageType: "generic"}}
+	users := []ArtifactoryUser{{Username: "user1"}}
+	groups := []ArtifactoryGroup{{GroupName: "group1"}}
+	permissiondetails := []ArtifactoryPermissionDetails{
+		{Name: "repo1", JsonSource: `{"name":"repo1","resources":{"artifact":{"actions":{"users":{"user1":["READ"]}},"targets":{"repo1":{"include_patterns":["**"]}}},"build":{}}}`},
+		{Name: "repo2"},
+	}
+	ldapsettings := []ArtifactoryLDAPSettings{{Key: "ldap1", Search: ArtifactoryLDAPSettingsSearch{ManagerDn: "cn=manager", ManagerPassword: "secret"}}}
+
+	err := ExportSnapshot(filename, "https://artifactory", repos, users, groups, permissiondetails, ldapsettings, nil)
+	if err != nil {
+		t.Fatalf("SnapshotRoundTrip: export error = %v", err)
+	}
+
+	data, _ := os.ReadFile(filename)
+	if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		t.Errorf("SnapshotRoundTrip: snapshot isn't gzip compressed")
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("SnapshotRoundTrip: gzip error = %v", err)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("SnapshotRoundTrip: gzip read error = %v", err)
+	}
+	if strings.Contains(string(decoded), "secret") {
+		t.Errorf("SnapshotRoundTrip: snapshot contains secrets:\n%s", decoded)
+	}
+
+	snapshot, err := LoadSnapshot(filename)
+	if err != nil {
+		t.Fatalf("SnapshotRoundTrip: load error = %v", err)
+	}
+
+	if snapshot.Version != snapshotVersion || snapshot.BaseURL != "https://artifactory" {
+		t.Errorf("SnapshotRoundTrip: got version %d, base url '%s'", snapshot.Version, snapshot.BaseURL)
+	}
+	if len(snapshot.Repos) != 1 || len(snapshot.Users) != 1 || len(snapshot.Groups) != 1 {
+		t.Errorf("SnapshotRoundTrip: got %d repos, %d users, %d groups", len(snapshot.Repos), len(snapshot.Users), len(snapshot.Groups))
+	}
+	if snapshot.LdapSettings[0].Search.ManagerPassword != "***" || snapshot.LdapSettings[0].Search.ManagerDn != "cn=manager" {
+		t.Errorf("SnapshotRoundTrip: ldap settings not redacted: %v", snapshot.LdapSettings[0].Search)
+	}
+
+	loaded, err := snapshot.GetPermissionDetails()
+	if err != nil {
+		t.Fatalf("SnapshotRoundTrip: permission details error = %v", err)
+	}
+	if len(loaded) != 2 || loaded[0].Resources.Artifact.Actions.Users["user1"][0] != "READ" || loaded[1].Name != "repo2" {
+		t.Errorf("SnapshotRoundTrip: got permission details %v", loaded)
+	}
+	if !strings.Contains(loaded[0].JsonSource, `"build"`) {
+		t.Errorf("SnapshotRoundTrip: permission json source not kept: '%s'", loaded[0].JsonSource)
+	}
+}
+
+func TestLoadSnapshotUnsupportedVersion(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "snapshot.json")
+	os.WriteFile(filename, []byte(`{"version":99}`), 0600)
+
+	_, err := LoadSnapshot(filename)
+	if err == nil {
+		t.Errorf("LoadSnapshotUnsupportedVersion: expected error")
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var terraformInvalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// Writes repos and permission targets as resources of the JFrog artifactory and platform
+// Terraform providers, with import blocks for adopting the existing objects.
+func saveTerraform(repos []ArtifactoryRepoDetailsResponse, permissiondetails []ArtifactoryPermissionDetails, filename string) error {
+	var sb strings.Builder
+	names := make(terraformNames)
+
+	for _, repo := range repos {
+		resourceType, err := getTerraformRepoResourceType(repo)
+		if err != nil {
+			fmt.Printf("'%s': Warning: Ignoring repo in terraform output: %v\n", repo.Key, err)
+			continue
+		}
+		writeTerraformRepo(&sb, names, resourceType, repo)
+	}
+
+	slices.SortFunc(permissiondetails, func(a, b ArtifactoryPermissionDetails) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	for _, permission := range permissiondetails {
+		writeTerraformPermission(&sb, names, permission)
+	}
+
+	err := os.WriteFile(filename, []byte(sb.String()), 0644)
+	if err != nil {
+		return fmt.Errorf("error saving file: %w", err)
+	}
+	return nil
+}
+
+// Repo resources of the JFrog artifactory provider, by rclass and package type. Not every
+// package type has a resource for every rclass, and local docker repos only have the v2 variant.
+var terraformRepoResourceTypes = map[string]string{
+	"local/alpine":      "artifactory_local_alpine_repository",
+	"local/bower":       "artifactory_local_bower_repository",
+	"local/cargo":       "artifactory_local_cargo_repository",
+	"local/chef":        "artifactory_local_chef_repository",
+	"local/cocoapods":   "artifactory_local_cocoapods_repository",
+	"local/composer":    "artifactory_local_composer_repository",
+	"local/conan":       "artifactory_local_conan_repository",
+	"local/conda":       "artifactory_local_conda_repository",
+	"local/cran":        "artifactory_local_cran_repository",
+	"local/debian":      "artifactory_local_debian_repository",
+	"local/docker":      "artifactory_local_docker_v2_repository",
+	"local/gems":        "artifactory_local_gems_repository",
+	"local/generic":     "artifactory_local_generic_repository",
+	"local/gitlfs":      "artifactory_local_gitlfs_repository",
+	"local/gradle":      "artifactory_local_gradle_repository",
+	"local/helm":        "artifactory_local_helm_repository",
+	"local/ivy":         "artifactory_local_ivy_repository",
+	"local/maven":       "artifactory_local_maven_repository",
+	"local/npm":         "artifactory_local_npm_repository",
+	"local/nuget":       "artifactory_local_nuget_repository",
+	"local/opkg":        "artifactory_local_opkg_repository",
+	"local/pub":         "artifactory_local_pub_repository",
+	"local/puppet":      "artifactory_local_puppet_repository",
+	"local/pypi":        "artifactory_local_pypi_repository",
+	"local/rpm":         "artifactory_local_rpm_repository",
+	"local/sbt":         "artifactory_local_sbt_repository",
+	"local/swift":       "artifactory_local_swift_repository",
+	"local/vagrant":     "artifactory_local_vagrant_repository",
+	"remote/alpine":     "artifactory_remote_alpine_repository",
+	"remote/bower":      "artifactory_remote_bower_repository",
+	"remote/cargo":      "artifactory_remote_cargo_repository",
+	"remote/chef":       "artifactory_remote_chef_repository",
+	"remote/cocoapods":  "artifactory_remote_cocoapods_repository",
+	"remote/composer":   "artifactory_remote_composer_repository",
+	"remote/conan":      "artifactory_remote_conan_repository",
+	"remote/conda":      "artifactory_remote_conda_repository",
+	"remote/cran":       "artifactory_remote_cran_repository",
+	"remote/debian":     "artifactory_remote_debian_repository",
+	"remote/docker":     "artifactory_remote_docker_repository",
+	"remote/gems":       "artifactory_remote_gems_repository",
+	"remote/generic":    "artifactory_remote_generic_repository",
+	"remote/gitlfs":     "artifactory_remote_gitlfs_repository",
+	"remote/go":         "artifactory_remote_go_repository",
+	"remote/gradle":     "artifactory_remote_gradle_repository",
+	"remote/helm":       "artifactory_remote_helm_repository",
+	"remote/ivy":        "artifactory_remote_ivy_repository",
+	"remote/maven":      "artifactory_remote_maven_repository",
+	"remote/npm":        "artifactory_remote_npm_repository",
+	"remote/nuget":      "artifactory_remote_nuget_repository",
+	"remote/opkg":       "artifactory_remote_opkg_repository",
+	"remote/p2":         "artifactory_remote_p2_repository",
+	"remote/pub":        "artifactory_remote_pub_repository",
+	"remote/puppet":     "artifactory_remote_puppet_repository",
+	"remote/pypi":       "artifactory_remote_pypi_repository",
+	"remote/rpm":        "artifactory_remote_rpm_repository",
+	"remote/sbt":        "artifactory_remote_sbt_repository",
+	"remote/swift":      "artifactory_remote_swift_repository",
+	"remote/terraform":  "artifactory_remote_terraform_repository",
+	"remote/vcs":        "artifactory_remote_vcs_repository",
+	"virtual/alpine":    "artifactory_virtual_alpine_repository",
+	"virtual/bower":     "artifactory_virtual_bower_repository",
+	"virtual/chef":      "artifactory_virtual_chef_repository",
+	"virtual/composer":  "artifactory_virtual_composer_repository",
+	"virtual/conan":     "artifactory_virtual_conan_repository",
+	"virtual/conda":     "artifactory_virtual_conda_repository",
+	"virtual/cran":      "artifactory_virtual_cran_repository",
+	"virtual/debian":    "artifactory_virtual_debian_repository",
+	"virtual/docker":    "artifactory_virtual_docker_repository",
+	"virtual/gems":      "artifactory_virtual_gems_repository",
+	"virtual/generic":   "artifactory_virtual_generic_repository",
+	"virtual/gitlfs":    "artifactory_virtual_gitlfs_repository",
+	"virtual/go":        "artifactory_virtual_go_repository",
+	"virtual/gradle":    "artifactory_virtual_gradle_repository",
+	"virtual/helm":      "artifactory_virtual_helm_repository",
+	"virtual/ivy":       "artifactory_virtual_ivy_repository",
+	"virtual/maven":     "artifactory_virtual_maven_repository",
+	"virtual/npm":       "artifactory_virtual_npm_repository",
+	"virtual/nuget":     "artifactory_virtual_nuget_repository",
+	"virtual/p2":        "artifactory_virtual_p2_repository",
+	"virtual/pub":       "artifactory_virtual_pub_repository",
+	"virtual/puppet":    "artifactory_virtual_puppet_repository",
+	"virtual/pypi":      "artifactory_virtual_pypi_repository",
+	"virtual/rpm":       "artifactory_virtual_rpm_repository",
+	"virtual/sbt":       "artifactory_virtual_sbt_repository",
+	"virtual/swift":     "artifactory_virtual_swift_repository",
+	"virtual/terraform": "artifactory_virtual_terraform_repository",
+}
+
+func getTerraformRepoResourceType(repo ArtifactoryRepoDetailsResponse) (string, error) {
+	rclass := strings.ToLower(repo.Rclass)
+	if rclass != "local" && rclass != "remote" && rclass != "virtual" {
+		return "", fmt.Errorf("unsupported rclass '%s'", repo.Rclass)
+	}
+
+	packageType := strings.ToLower(repo.PackageType)
+	if packageType == "" {
+		return "", fmt.Errorf("missing package type")
+	}
+
+	resourceType, ok := terraformRepoResourceTypes[rclass+"/"+packageType]
+	if !ok {
+		return "", fmt.Errorf("no terraform resource for %s %s repos", rclass, repo.PackageType)
+	}
+
+	return resourceType, nil
+}
+
+func writeTerraformRepo(sb *strings.Builder, names terraformNames, resourceType string, repo ArtifactoryRepoDetailsResponse) {
+	name := names.get(resourceType, repo.Key)
+
+	fmt.Fprintf(sb, "resource \"%s\" \"%s\" {\n", resourceType, name)
+	fmt.Fprintf(sb, "  key = %s\n", getHclString(repo.Key))
+	if repo.Description != "" {
+		fmt.Fprintf(sb, "  description = %s\n", getHclString(repo.Description))
+	}
+	if repo.RepoLayoutRef != "" {
+		fmt.Fprintf(sb, "  repo_layout_ref = %s\n", getHclString(repo.RepoLayoutRef))
+	}
+	if strings.EqualFold(repo.Rclass, "remote") {
+		fmt.Fprintf(sb, "  url = %s\n", getHclString(repo.Url))
+	}
+	if strings.EqualFold(repo.Rclass, "virtual") {
+		fmt.Fprintf(sb, "  repositories = %s\n", getHclList(repo.Repositories))
+	}
+	sb.WriteString("}\n\n")
+
+	writeTerraformImport(sb, resourceType+"."+name, repo.Key)
+}
+
+func writeTerraformPermission(sb *strings.Builder, names terraformNames, permission ArtifactoryPermissionDetails) {
+	name := names.get("platform_permission", permission.Name)
+	artifact := permission.Resources.Artifact
+
+	fmt.Fprintf(sb, "resource \"platform_permission\" \"%s\" {\n", name)
+	fmt.Fprintf(sb, "  name = %s\n", getHclString(permission.Name))
+	sb.WriteString("  artifact = {\n")
+	sb.WriteString("    actions = {\n")
+	writeTerraformActions(sb, "users", artifact.Actions.Users)
+	writeTerraformActions(sb, "groups", artifact.Actions.Groups)
+	sb.WriteString("    }\n")
+	sb.WriteString("    targets = [\n")
+	for _, target := range slices.Sorted(maps.Keys(artifact.Targets)) {
+		patterns := artifact.Targets[target]
+		// Artifactory returns an empty string for no exclude patterns.
+		excludePatterns := slices.DeleteFunc(slices.Clone(patterns.ExcludePatterns), func(p string) bool { return p == "" })
+		fmt.Fprintf(sb, "      {\n        name = %s\n        include_patterns = %s\n        exclude_patterns = %s\n      },\n",
+			getHclString(target), getHclList(patterns.IncludePatterns), getHclList(excludePatterns))
+	}
+	sb.WriteString("    ]\n")
+	sb.WriteString("  }\n")
+	sb.WriteString("}\n\n")
+
+	writeTerraformImport(sb, "platform_permission."+name, permission.Name)
+}
+
+func writeTerraformActions(sb *strings.Builder, kind string, actions map[string][]string) {
+	if len(actions) == 0 {
+		return
+	}
+
+	fmt.Fprintf(sb, "      %s = [\n", kind)
+	for _, principal := range slices.Sorted(maps.Keys(actions)) {
+		permissions := slices.Clone(actions[principal])
+		slices.Sort(permissions)
+		fmt.Fprintf(sb, "        {\n          name = %s\n          permissions = %s\n        },\n", getHclString(principal), getHclList(permissions))
+	}
+	sb.WriteString("      ]\n")
+}
+
+func writeTerraformImport(sb *strings.Builder, to string, id string) {
+	fmt.Fprintf(sb, "import {\n  to = %s\n  id = %s\n}\n\n", to, getHclString(id))
+}
+
+// Resource addresses already written, a name must be unique for each resource type.
+type terraformNames map[string]bool
+
+// Returns the resource name of an object. Different keys can have the same name after
+// replacing invalid characters, like 'foo.bar' and 'foo_bar', so a suffix is added to later ones.
+func (n terraformNames) get(resourceType string, key string) string {
+	name := getTerraformName(key)
+	unique := name
+	for i := 2; n[resourceType+"."+unique]; i++ {
+		unique = fmt.Sprintf("%s_%d", name, i)
+	}
+	if unique != name {
+		fmt.Printf("'%s': Warning: Terraform name '%s' is already used, using '%s'\n", key, name, unique)
+	}
+	n[resourceType+"."+unique] = true
+
+	return unique
+}
+
+// Terraform resource names may only contain letters, digits, underscores and dashes,
+// and must not start with a digit or dash.
+func getTerraformName(name string) string {
+	name = terraformInvalidNameChars.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') || name[0] == '-' {
+		name = "_" + name
+	}
+	return name
+}
+
+func getHclString(s string) string {
+	quoted := strconv.Quote(s)
+	quoted = strings.ReplaceAll(quoted, "${", "$${")
+	quoted = strings.ReplaceAll(quoted, "%{", "%%{")
+	return quoted
+}
+
+func getHclList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = getHclString(value)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateTerraform(t *testing.T) {
+	repos := []ArtifactoryRepoDetailsResponse{
+		{Key: "repo1", Description: "Test ${repo}", Rclass: "local", PackageType: "generic", RepoLayoutRef: "simple-default"},
+		{Key: "docker-remote", Rclass: "remote", PackageType: "docker", RepoLayoutRef: "simple-default", Url: "https://registry-1.docker.io"},
+		{Key: "1-virtual", Rclass: "virtual", PackageType: "maven", RepoLayoutRef: "maven-2-default", Repositories: []string{"repo1"}},
+	}
+	permissiondetails := []ArtifactoryPermissionDetails{
+		{Name: "repo1", Resources: ArtifactoryPermissionDetailsResources{Artifact: ArtifactoryPermissionDetailsArtifact{
+			Actions: ArtifactoryPermissionDetailsActions{
+				Users:  map[string][]string{"user1": {"WRITE", "READ"}},
+				Groups: map[string][]string{"group1": {"READ"}},
+			},
+			Targets: map[string]ArtifactoryPermissionDetailsTarget{"repo1": {IncludePatterns: []string{"**"}, ExcludePatterns: []string{""}}},
+		}}},
+		{Name: "unrelated"},
+	}
+
+	filename := filepath.Join(t.TempDir(), "main.tf")
+	err := Generate(nil, "", "", repos, permissiondetails, filename, GenerateConfig{Terraform: true})
+	if err != nil {
+		t.Fatalf("GenerateTerraform: error = %v", err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("GenerateTerraform: failed to read file: %v", err)
+	}
+
+	want := `resource "artifactory_virtual_maven_repository" "_1-virtual" {
+  key = "1-virtual"
+  repo_layout_ref = "maven-2-default"
+  repositories = ["repo1"]
+}
+
+import {
+  to = artifactory_virtual_maven_repository._1-virtual
+  id = "1-virtual"
+}
+
+resource "artifactory_remote_docker_repository" "docker-remote" {
+  key = "docker-remote"
+  repo_layout_ref = "simple-default"
+  url = "https://registry-1.docker.io"
+}
+
+import {
+  to = artifactory_remote_docker_repository.docker-remote
+  id = "docker-remote"
+}
+
+resource "artifactory_local_generic_repository" "repo1" {
+  key = "repo1"
+  description = "Test $${repo}"
+  repo_layout_ref = "simple-default"
+}
+
+import {
+  to = artifactory_local_generic_repository.repo1
+  id = "repo1"
+}
+
+resource "platform_permission" "repo1" {
+  name = "repo1"
+  artifact = {
+    actions = {
+      users = [
+        {
+          name = "user1"
+          permissions = ["READ", "WRITE"]
+        },
+      ]
+      groups = [
+        {
+          name = "group1"
+          permissions = ["READ"]
+        },
+      ]
+    }
+    targets = [
+      {
+        name = "repo1"
+        include_patterns = ["**"]
+        exclude_patterns = []
+      },
+    ]
+  }
+}
+
+import {
+  to = platform_permission.repo1
+  id = "repo1"
+}
+
+`
+	if string(data) != want {
+		t.Errorf("GenerateTerraform: output mismatch:\nGot:\n%s\nWant:\n%s", string(data), want)
+	}
+}
+
+func TestGetTerraformRepoResourceType(t *testing.T) {
+	tests := []struct {
+		Rclass      string
+		PackageType string
+		Want        string
+		WantErr     bool
+	}{
+		{"local", "Maven", "artifactory_local_maven_repository", false},
+		{"local", "docker", "artifactory_local_docker_v2_repository", false},
+		{"virtual", "docker", "artifactory_virtual_docker_repository", false},
+		{"remote", "docker", "artifactory_remote_docker_repository", false},
+		{"Remote", "Go", "artifactory_remote_go_repository", false},
+		{"virtual", "terraform", "artifactory_virtual_terraform_repository", false},
+		{"federated", "generic", "", true},
+		{"local", "", "", true},
+		// Local go and terraform repos have no resource, or need a subtype.
+		{"local", "go", "", true},
+		{"local", "terraform", "", true},
+		{"virtual", "vagrant", "", true},
+		{"local", "unknown", "", true},
+	}
+
+	for i, test := range tests {
+		got, err := getTerraformRepoResourceType(ArtifactoryRepoDetailsResponse{Rclass: test.Rclass, PackageType: test.PackageType})
+		if (err != nil) != test.WantErr || got != test.Want {
+			t.Errorf("getTerraformRepoResourceType (%d/%d): got '%s', %v, want '%s'", i+1, len(tests), got, err, test.Want)
+		}
+	}
+}
+
+func TestGenerateTerraformNameCollision(t *testing.T) {
+	repos := []ArtifactoryRepoDetailsResponse{
+		{Key: "foo.bar", Rclass: "local", PackageType: "generic"},
+		{Key: "foo_bar", Rclass: "local", PackageType: "generic"},
+		{Key: "foo_bar_2", Rclass: "local", PackageType: "generic"},
+		{Key: "foo@bar", Rclass: "local", PackageType: "maven"},
+	}
+	permissiondetails := []ArtifactoryPermissionDetails{
+		{Name: "foo.bar"},
+		{Name: "foo_bar"},
+	}
+
+	filename := filepath.Join(t.TempDir(), "main.tf")
+	err := saveTerraform(repos, permissiondetails, filename)
+	if err != nil {
+		t.Fatalf("GenerateTerraformNameCollision: error = %v", err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("GenerateTerraformNameCollision: failed to read file: %v", err)
+	}
+
+	tests := []struct {
+		To string
+		Id string
+	}{
+		{"artifactory_local_generic_repository.foo_bar", "foo.bar"},
+		{"artifactory_local_generic_repository.foo_bar_2", "foo_bar"},
+		{"artifactory_local_generic_repository.foo_bar_2_2", "foo_bar_2"},
+		// Names only have to be unique for each resource type.
+		{"artifactory_local_maven_repository.foo_bar", "foo@bar"},
+		{"platform_permission.foo_bar", "foo.bar"},
+		{"platform_permission.foo_bar_2", "foo_bar"},
+	}
+	for i, test := range tests {
+		want := fmt.Sprintf("import {\n  to = %s\n  id = %q\n}\n", test.To, test.Id)
+		if !strings.Contains(string(data), want) {
+			t.Errorf("GenerateTerraformNameCollision (%d/%d): output doesn't contain:\n%s\nGot:\n%s", i+1, len(tests), want, string(data))
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"regexp"
 	"slices"
 	"strings"
+	"unicode"
 )
 
 func Validate(reposToProvision []Repo, existingRepos []ArtifactoryRepoDetailsResponse, existingPermissions []ArtifactoryPermissionDetails) (repos []Repo, err error) {
@@ -14,6 +15,8 @@ func Validate(reposToProvision []Repo, existingRepos []ArtifactoryRepoDetailsRes
 
 	reposToProvision = validateCasePermissions(reposToProvision)
 
+	reposToProvision = validatePrincipalNames(reposToProvision)
+
 	return reposToProvision, nil
 }
 
@@ -116,6 +119,40 @@ func isValidRepoName(s string) bool {
 	return regex.MatchString(s)
 }
 
+func validatePrincipalNames(reposToProvision []Repo) []Repo {
+	for i := 0; i < len(reposToProvision); i++ {
+		repo := reposToProvision[i]
+		var offendingValues []string
+
+		permSlices := [][]string{repo.Read, repo.Annotate, repo.Write, repo.Delete, repo.Manage, repo.Scan}
+		for _, permSlice := range permSlices {
+			for _, value := range permSlice {
+				if !isValidPrincipalName(value) && !slices.Contains(offendingValues, value) {
+					offendingValues = append(offendingValues, value)
+				}
+			}
+		}
+
+		if len(offendingValues) > 0 {
+			fmt.Printf("Warning: Ignoring repo '%s', due to invalid user/group names: %q\n", repo.Name, offendingValues)
+			stats.IgnoredInvalidRepoCount++
+			reposToProvision = slices.Delete(reposToProvision, i, i+1)
+			i--
+		}
+	}
+
+	return reposToProvision
+}
+
+// Artifactory can't represent empty user/group names, surrounding whitespace or control
+// characters. Other special characters are valid, and escaped in ldap filters.
+func isValidPrincipalName(s string) bool {
+	if s == "" || strings.TrimSpace(s) != s {
+		return false
+	}
+	return !strings.ContainsFunc(s, unicode.IsControl)
+}
+
 func validateCasePermissions(reposToProvision []Repo) []Repo {
 	for i := range reposToProvision {
 		repo := reposToProvision[i]
@@ -207,3 +207,46 @@ func TestValidateCasePermissionsAllLowercase(t *testing.T) {
 		t.Errorf("ValidateCasePermissionsAllLowercase: unexpected ignore count: want: '%d', got: '%d'", wantIgnoreCount, stats.IgnoredInvalidRepoCount)
 	}
 }
+
+func TestValidatePrincipalNames(t *testing.T) {
+	reposToProvision := []Repo{
+		{
+			Name: "repo1",
+			Read: []string{"user1", ""},
+		},
+		{
+			Name:  "repo2",
+			Read:  []string{"user2"},
+			Write: []string{"group\x00admins"},
+		},
+		{
+			Name:   "repo3",
+			Read:   []string{"user3", "domain.user@example.com", `DOMAIN\user`, "group)(cn=admins"},
+			Manage: []string{"team-x", "ou=a,#b"},
+		},
+		{
+			Name: "repo4",
+			Read: []string{" user4"},
+		},
+	}
+
+	existingRepos := []ArtifactoryRepoDetailsResponse{}
+	existingPermissions := []ArtifactoryPermissionDetails{}
+
+	stats.IgnoredInvalidRepoCount = 0
+	reposToProvision, err := Validate(reposToProvision, existingRepos, existingPermissions)
+	if err != nil {
+		t.Errorf("ValidatePrincipalNames: error = %v", err)
+	}
+
+	wantCount := 1
+	if len(reposToProvision) != wantCount {
+		t.Errorf("ValidatePrincipalNames: expected %d repos to provision, got %d", wantCount, len(reposToProvision))
+	} else if reposToProvision[0].Name != "repo3" {
+		t.Errorf("ValidatePrincipalNames: unexpected repo name: got: '%s', want: '%s'", reposToProvision[0].Name, "repo3")
+	}
+	wantIgnoreCount := 3
+	if stats.IgnoredInvalidRepoCount != wantIgnoreCount {
+		t.Errorf("ValidatePrincipalNames: unexpected ignore count: want: '%d', got: '%d'", wantIgnoreCount, stats.IgnoredInvalidRepoCount)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+type rateLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// Limits the rate of all Artifactory requests, nil means unlimited.
+var requestLimiter *rateLimiter
+
+func newRateLimiter(requestsPerSecond float64) *rateLimiter {
+	if requestsPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / requestsPerSecond)}
+}
+
+func (r *rateLimiter) Wait() {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	wait := r.next.Sub(now)
+	r.next = r.next.Add(r.interval)
+	r.mutex.Unlock()
+
+	if wait > 0 {
+		sleepFn(wait)
+	}
+}
+
+// Calls fn for each index in [0, count) using at most concurrency goroutines.
+// Results are expected to be stored by index, to keep the ordering deterministic.
+// All errors are returned, in index order.
+func runConcurrently(concurrency int, count int, fn func(i int) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	errs := make([]error, count)
+	indices := make(chan int)
+
+	var wg sync.WaitGroup
+	for range min(concurrency, count) {
+		wg.Go(func() {
+			for i := range indices {
+				errs[i] = fn(i)
+			}
+		})
+	}
+
+	for i := range count {
+		indices <- i
+	}
+	close(indices)
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunConcurrently(t *testing.T) {
+	results := make([]int, 100)
+	var running, maxRunning atomic.Int32
+
+	err := runConcurrently(4, len(results), func(i int) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+
+		results[i] = i * i
+		if i == 7 || i == 3 {
+			return fmt.Errorf("failed %d", i)
+		}
+		return nil
+	})
+
+	for i, result := range results {
+		if result != i*i {
+			t.Errorf("RunConcurrently: result %d: got %d, want %d", i, result, i*i)
+		}
+	}
+	if maxRunning.Load() > 4 {
+		t.Errorf("RunConcurrently: got %d concurrent calls, want at most 4", maxRunning.Load())
+	}
+	if err == nil || err.Error() != "failed 3\nfailed 7" {
+		t.Errorf("RunConcurrently: unexpected aggregated error: %v", err)
+	}
+
+	err = runConcurrently(0, 0, func(i int) error { return fmt.Errorf("unexpected call") })
+	if err != nil {
+		t.Errorf("RunConcurrently: unexpected error for empty input: %v", err)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	origSleep := sleepFn
+	defer func() { sleepFn = origSleep }()
+
+	var waits []time.Duration
+	sleepFn = func(d time.Duration) { waits = append(waits, d) }
+
+	var limiter *rateLimiter
+	limiter.Wait()
+	if len(waits) != 0 {
+		t.Errorf("RateLimiter: unlimited limiter waited %v", waits)
+	}
+
+	// Sleeping is mocked, so each request has to wait one interval longer than the previous one.
+	limiter = newRateLimiter(10)
+	for range 5 {
+		limiter.Wait()
+	}
+	if len(waits) != 4 {
+		t.Fatalf("RateLimiter: got %d waits for 5 requests, want 4", len(waits))
+	}
+	last := waits[len(waits)-1]
+	if last < 350*time.Millisecond || last > 400*time.Millisecond {
+		t.Errorf("RateLimiter: got %s wait for 5th request at 10/s, want about 400ms", last)
+	}
+}